@@ -2,11 +2,21 @@ package ovs
 
 // Some linux commands.
 var (
-	IPCmd    = "ip"
-	OfctlCmd = "ovs-ofctl"
-	VsctlCmd = "ovs-vsctl"
+	IPCmd     = "ip"
+	OfctlCmd  = "ovs-ofctl"
+	VsctlCmd  = "ovs-vsctl"
+	AppctlCmd = "ovs-appctl"
 )
 
+// OfctlProtocol is the OpenFlow versions allowed by the ovs-ofctl commands
+// which require OpenFlow 1.3 or later, such as the group and meter commands,
+// and the highest one also enabled by the bridge is negotiated.
+var OfctlProtocol = "OpenFlow13,OpenFlow14,OpenFlow15"
+
+// OfctlProtocol15 is the OpenFlow version used by the ovs-ofctl commands
+// which require OpenFlow 1.5, such as the group with the selection method.
+var OfctlProtocol15 = "OpenFlow15"
+
 // L2 Data-Link Protocol Number
 const (
 	ARP  = 0x0806
@@ -16,10 +26,11 @@ const (
 
 // L3 IP Protocol Number
 const (
-	ICMP = 1
-	TCP  = 6
-	UDP  = 17
-	GRE  = 47
+	ICMP   = 1
+	TCP    = 6
+	UDP    = 17
+	GRE    = 47
+	ICMPv6 = 58
 )
 
 // OVS Actions
@@ -30,6 +41,13 @@ const (
 	NORMAL = "normal"
 )
 
+// OVS Reserved Ports, which are case-insensitive and may also be used
+// as the port argument, such as the input port of the packet-out.
+const (
+	CONTROLLER = "controller"
+	NONE       = "none"
+)
+
 // MAC address
 const (
 	BroadcastMac = "ff:ff:ff:ff:ff:ff"
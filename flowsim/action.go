@@ -0,0 +1,471 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-ovs"
+)
+
+type recirculation struct {
+	Table  uint8
+	Packet Packet
+}
+
+type state struct {
+	e       *Evaluator
+	packet  Packet
+	result  *Result
+	recircs []recirculation
+	exit    bool
+}
+
+// splitAction splits the action into the name and the argument,
+// such as "output:1" to "output" and "1", or "ct(commit)" to "ct" and "commit".
+func splitAction(action string) (name, arg string) {
+	index := strings.IndexAny(action, ":(")
+	if index < 0 {
+		return strings.ToLower(action), ""
+	}
+
+	name, arg = strings.ToLower(action[:index]), action[index+1:]
+	if action[index] == '(' {
+		arg = strings.TrimSuffix(arg, ")")
+	}
+	return
+}
+
+// checkAction checks whether the arguments of the action are valid.
+func (e *Evaluator) checkAction(action string) (err error) {
+	name, arg := splitAction(action)
+	switch name {
+	case "load":
+		_, _, err = parseLoad(arg)
+	case "move":
+		_, _, err = parseMove(arg)
+	case "set_field":
+		_, _, _, err = parseSetField(arg, e.resolvePort)
+	case "goto_table":
+		_, err = strconv.ParseUint(arg, 10, 8)
+	case "resubmit":
+		_, _, err = parseResubmit(arg, e.resolvePort)
+	case "ct":
+		_, _, err = parseCT(arg)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("invalid action '%s': %v", action, err)
+	}
+	return
+}
+
+func (s *state) lookup(table uint8, depth int) error {
+	maxDepth := s.e.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	if depth > maxDepth {
+		return fmt.Errorf("the resubmit depth exceeds %d", maxDepth)
+	} else if len(s.result.Stages) >= maxStages {
+		return fmt.Errorf("the table lookups exceed %d", maxStages)
+	}
+
+	index := len(s.result.Stages)
+	s.result.Stages = append(s.result.Stages, Stage{Table: table, Depth: depth})
+	r := s.e.lookup(table, s.packet)
+	if r == nil {
+		s.note(index, "no match, drop")
+		return nil
+	}
+
+	flow := r.Flow
+	s.result.Stages[index].Flow = &flow
+	return s.execute(index, table, depth, r.Actions)
+}
+
+func (s *state) note(index int, format string, args ...interface{}) {
+	s.result.Stages[index].Notes = append(s.result.Stages[index].Notes, fmt.Sprintf(format, args...))
+}
+
+func (s *state) execute(index int, table uint8, depth int, actions []string) (err error) {
+	for _, action := range actions {
+		if s.exit {
+			return
+		}
+
+		s.result.Actions = append(s.result.Actions, action)
+		s.result.Stages[index].Actions = append(s.result.Stages[index].Actions, action)
+
+		name, arg := splitAction(action)
+		switch name {
+		case "drop", "note":
+
+		case "output", "enqueue":
+			s.output(index, strings.SplitN(arg, ",", 2)[0])
+
+		case "in_port", "normal", "flood", "all", "local", "controller":
+			s.output(index, name)
+
+		case "goto_table":
+			next, _ := strconv.ParseUint(arg, 10, 8)
+			return s.lookup(uint8(next), depth)
+
+		case "resubmit":
+			port, next, _ := parseResubmit(arg, s.e.resolvePort)
+			if next < 0 {
+				next = int(table)
+			}
+
+			inPort := s.packet.Get("in_port")
+			if port >= 0 {
+				s.packet.Set("in_port", ovs.Uint128{Lo: uint64(port)})
+			}
+			err = s.lookup(uint8(next), depth+1)
+			if port >= 0 {
+				s.packet.Set("in_port", inPort)
+			}
+			if err != nil {
+				return
+			}
+
+		case "exit":
+			s.exit = true
+
+		case "load":
+			value, dst, _ := parseLoad(arg)
+			s.write(dst, value)
+
+		case "move":
+			src, dst, _ := parseMove(arg)
+			s.write(dst, s.read(src))
+
+		case "set_field":
+			f, value, mask, _ := parseSetField(arg, s.e.resolvePort)
+			old := s.packet.Get(f.Name)
+			s.packet.Set(f.Name, old.And(mask.Not()).Or(value.And(mask)))
+
+		case "write_metadata":
+			v, m, _ := strings.Cut(arg, "/")
+			value, e1 := ovs.ParseUint128(v)
+			mask := ovs.Uint128Ones(64)
+			if m != "" {
+				mask, err = ovs.ParseUint128(m)
+			}
+			if e1 != nil || err != nil {
+				return fmt.Errorf("invalid action '%s'", action)
+			}
+			old := s.packet.Get("metadata")
+			s.packet.Set("metadata", old.And(mask.Not()).Or(value.And(mask)))
+
+		case "mod_dl_src", "mod_dl_dst", "mod_nw_src", "mod_nw_dst",
+			"mod_tp_src", "mod_tp_dst", "mod_nw_tos":
+			f := fields[strings.TrimPrefix(name, "mod_")]
+			value, _, err := parseFieldValue(f, arg, nil)
+			if err != nil {
+				return fmt.Errorf("invalid action '%s': %v", action, err)
+			}
+			s.packet.Set(f.Name, value)
+
+		case "mod_vlan_vid":
+			vid, err := strconv.ParseUint(arg, 0, 12)
+			if err != nil {
+				return fmt.Errorf("invalid action '%s'", action)
+			}
+			tci := s.packet.Get("vlan_tci").Lo
+			s.packet.Set("vlan_tci", ovs.Uint128{Lo: tci&0xe000 | 0x1000 | vid})
+
+		case "strip_vlan", "pop_vlan":
+			s.packet.Set("vlan_tci", ovs.Uint128{})
+
+		case "push_vlan":
+			s.packet.Set("vlan_tci", ovs.Uint128{Lo: 0x1000})
+
+		case "dec_ttl":
+			ttl := s.packet.Get("nw_ttl").Lo
+			if ttl <= 1 {
+				s.note(index, "dec_ttl: the ttl is exceeded, stop")
+				s.exit = true
+				return
+			}
+			s.packet.Set("nw_ttl", ovs.Uint128{Lo: ttl - 1})
+
+		case "ct":
+			s.ct(arg)
+
+		case "clone":
+			packet := s.packet.Clone()
+			err = s.execute(index, table, depth, ovs.SplitActions(arg))
+			s.packet, s.exit = packet, false
+			if err != nil {
+				return
+			}
+
+		case "group":
+			s.note(index, "the group %s is not simulated", arg)
+
+		default:
+			s.note(index, "the action '%s' is not supported", action)
+		}
+	}
+
+	return
+}
+
+func (s *state) output(index int, port string) {
+	var number uint64
+	switch strings.ToUpper(port) {
+	case "IN_PORT":
+		number = s.packet.Get("in_port").Lo
+	case "NORMAL", "FLOOD", "ALL", "LOCAL", "CONTROLLER":
+		number = reservedPorts[strings.ToUpper(port)]
+	default:
+		if sf, err := parseSubfield(port); err == nil && strings.Contains(port, "[") {
+			number = s.read(sf).Lo
+		} else if v, ok := s.e.resolvePort(port); ok {
+			number = v
+		} else if v, err := strconv.ParseUint(port, 0, 32); err == nil {
+			number = v
+		} else {
+			s.note(index, "invalid output port '%s'", port)
+			return
+		}
+
+		if number == s.packet.Get("in_port").Lo {
+			s.note(index, "skipping output to the input port %d", number)
+			return
+		}
+	}
+
+	s.result.Outputs = append(s.result.Outputs, Output{Port: int(number), Packet: s.packet.Clone()})
+}
+
+func (s *state) ct(arg string) {
+	// Like OVS, only the packet recirculated to the table sees the result
+	// of conntrack, and the current packet continues to execute the actions
+	// after ct() without any change.
+	zone, table, _ := parseCT(arg)
+	if table < 0 {
+		return
+	}
+
+	var commit bool
+	var exec string
+	for _, a := range ovs.SplitActions(arg) {
+		if a == "commit" || a == "force" {
+			commit = true
+		} else if strings.HasPrefix(a, "exec(") {
+			exec = strings.TrimSuffix(strings.TrimPrefix(a, "exec("), ")")
+		}
+	}
+
+	p := s.packet.Clone()
+	if zone.Field.Name != "" {
+		p.Set("ct_zone", s.read(zone))
+	} else {
+		p.Set("ct_zone", ovs.Uint128{Lo: uint64(zone.Start)})
+	}
+
+	state := p.Get("ct_state")
+	if state.Lo&uint64(ovs.CTStateTrk) == 0 {
+		p.Set("ct_state", ovs.Uint128{Lo: uint64(ovs.CTStateTrk | ovs.CTStateNew)})
+	}
+
+	// exec() may only modify ct_mark and ct_label of the connection,
+	// which are seen by the recirculated packet.
+	if commit && exec != "" {
+		for _, action := range ovs.SplitActions(exec) {
+			var f field
+			var value, mask ovs.Uint128
+			name, arg := splitAction(action)
+			switch name {
+			case "load":
+				v, dst, err := parseLoad(arg)
+				if err != nil {
+					continue
+				}
+				f, value = dst.Field, v.Lsh(uint(dst.Start))
+				mask = ovs.Uint128Ones(dst.Bits).Lsh(uint(dst.Start))
+			case "set_field":
+				var err error
+				if f, value, mask, err = parseSetField(arg, nil); err != nil {
+					continue
+				}
+			default:
+				continue
+			}
+
+			if f.Name == "ct_mark" || f.Name == "ct_label" {
+				old := p.Get(f.Name)
+				p.Set(f.Name, old.And(mask.Not()).Or(value.And(mask)))
+			}
+		}
+	}
+
+	s.recircs = append(s.recircs, recirculation{Table: uint8(table), Packet: p})
+}
+
+// subfield is the bits [Start, Start+Bits) of the field.
+type subfield struct {
+	Field field
+	Start int
+	Bits  int
+}
+
+// parseSubfield parses the subfield, such as "NXM_NX_REG0[]",
+// "NXM_NX_REG0[0..15]", "NXM_NX_REG0[5]" or "reg0".
+func parseSubfield(s string) (sf subfield, err error) {
+	name, bits, hasBits := strings.Cut(s, "[")
+	if sf.Field, err = lookupField(name); err != nil {
+		return
+	}
+
+	sf.Bits = sf.Field.Bits
+	if !hasBits || bits == "]" {
+		return
+	}
+
+	bits = strings.TrimSuffix(bits, "]")
+	start, end, isRange := strings.Cut(bits, "..")
+	if sf.Start, err = strconv.Atoi(start); err != nil {
+		return sf, fmt.Errorf("invalid subfield '%s'", s)
+	}
+
+	last := sf.Start
+	if isRange {
+		if last, err = strconv.Atoi(end); err != nil {
+			return sf, fmt.Errorf("invalid subfield '%s'", s)
+		}
+	}
+
+	if sf.Start < 0 || last < sf.Start || last >= sf.Field.Bits {
+		return sf, fmt.Errorf("invalid subfield '%s'", s)
+	}
+	sf.Bits = last - sf.Start + 1
+	return
+}
+
+func (s *state) read(sf subfield) ovs.Uint128 {
+	return s.packet.Get(sf.Field.Name).Rsh(uint(sf.Start)).And(ovs.Uint128Ones(sf.Bits))
+}
+
+func (s *state) write(sf subfield, value ovs.Uint128) {
+	mask := ovs.Uint128Ones(sf.Bits).Lsh(uint(sf.Start))
+	old := s.packet.Get(sf.Field.Name)
+	s.packet.Set(sf.Field.Name, old.And(mask.Not()).Or(value.Lsh(uint(sf.Start)).And(mask)))
+}
+
+// parseLoad parses the argument of load, such as "0x1->NXM_NX_REG0[0..15]".
+func parseLoad(arg string) (value ovs.Uint128, dst subfield, err error) {
+	v, d, ok := strings.Cut(arg, "->")
+	if !ok {
+		return value, dst, fmt.Errorf("missing '->'")
+	}
+
+	if value, err = ovs.ParseUint128(v); err != nil {
+		return
+	} else if dst, err = parseSubfield(d); err != nil {
+		return
+	} else if value.Cmp(ovs.Uint128Ones(dst.Bits)) > 0 {
+		err = fmt.Errorf("the value '%s' overflows %d bits", v, dst.Bits)
+	}
+	return
+}
+
+// parseMove parses the argument of move, such as
+// "NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]".
+func parseMove(arg string) (src, dst subfield, err error) {
+	s, d, ok := strings.Cut(arg, "->")
+	if !ok {
+		return src, dst, fmt.Errorf("missing '->'")
+	}
+
+	if src, err = parseSubfield(s); err != nil {
+		return
+	} else if dst, err = parseSubfield(d); err != nil {
+		return
+	} else if src.Bits != dst.Bits {
+		err = fmt.Errorf("the source has %d bits, but the destination has %d bits", src.Bits, dst.Bits)
+	}
+	return
+}
+
+// parseSetField parses the argument of set_field, such as
+// "00:11:22:33:44:55->eth_dst" or "0x1/0x1->reg0".
+func parseSetField(arg string, resolve portResolver) (f field, value, mask ovs.Uint128, err error) {
+	v, d, ok := strings.Cut(arg, "->")
+	if !ok {
+		return f, value, mask, fmt.Errorf("missing '->'")
+	}
+
+	if f, err = lookupField(d); err == nil {
+		value, mask, err = parseFieldValue(f, v, resolve)
+	}
+	return
+}
+
+// parseResubmit parses the argument of resubmit, such as ",1", "2,1" or "2".
+//
+// If the port or table is missing, it is -1.
+func parseResubmit(arg string, resolve portResolver) (port, table int, err error) {
+	port, table = -1, -1
+	p, t, hasTable := strings.Cut(arg, ",")
+	if p = strings.TrimSpace(p); p != "" {
+		var v ovs.Uint128
+		if v, _, err = parseFieldValue(fields["in_port"], p, resolve); err != nil {
+			return
+		}
+		port = int(v.Lo)
+	}
+
+	if t = strings.TrimSpace(t); hasTable && t != "" {
+		var v uint64
+		if v, err = strconv.ParseUint(t, 10, 8); err != nil {
+			return
+		}
+		table = int(v)
+	}
+	return
+}
+
+// parseCT parses the zone and the table of the argument of ct.
+//
+// If the zone is a constant, zone.Field is empty and zone.Start is the zone.
+// If the table is missing, it is -1.
+func parseCT(arg string) (zone subfield, table int, err error) {
+	table = -1
+	for _, a := range ovs.SplitActions(arg) {
+		key, value, _ := strings.Cut(a, "=")
+		switch key {
+		case "zone":
+			if v, e := strconv.ParseUint(value, 0, 16); e == nil {
+				zone.Start = int(v)
+			} else if zone, err = parseSubfield(value); err != nil {
+				return
+			}
+
+		case "table":
+			var v uint64
+			if v, err = strconv.ParseUint(value, 10, 8); err != nil {
+				return
+			}
+			table = int(v)
+		}
+	}
+	return
+}
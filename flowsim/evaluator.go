@@ -0,0 +1,292 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flowsim supplies an offline OpenFlow pipeline evaluator,
+// which simulates a packet through the flow tables without OVS,
+// like an offline "ovs-appctl ofproto/trace".
+//
+// It supports the masked matches, the conjunctive matches, goto_table,
+// resubmit, load, move, set_field, output, drop, ct, etc. The connection
+// tracking is not really simulated: ct() only recirculates a clone
+// of the packet to the table, which has ct_zone, ct_state "+trk+new"
+// if the packet has not been tracked, and ct_mark and ct_label loaded
+// by exec(); the actions after ct() still see the original packet.
+// So set ct_state of the packet, such as "+trk+est", to simulate
+// an established connection.
+package flowsim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/xgfone/go-ovs"
+)
+
+// DefaultMaxDepth is the default max depth of resubmit.
+const DefaultMaxDepth = 64
+
+// maxStages is the max number of the table lookups of a packet.
+const maxStages = 4096
+
+type rule struct {
+	Flow    ovs.Flow
+	Match   match
+	Actions []string
+	Conj    []conjunction
+}
+
+type conjunction struct {
+	ID uint32
+	K  int
+	N  int
+}
+
+// Evaluator is an offline OpenFlow pipeline evaluator.
+type Evaluator struct {
+	// Ports is used to resolve the port names in the flows and packets
+	// to the port numbers.
+	Ports map[string]int
+
+	// MaxDepth is the max depth of resubmit, which is DefaultMaxDepth
+	// by default.
+	MaxDepth int
+
+	tables map[uint8][]*rule
+}
+
+// NewEvaluator returns a new evaluator without any flows.
+func NewEvaluator() *Evaluator {
+	return &Evaluator{
+		Ports:    make(map[string]int),
+		MaxDepth: DefaultMaxDepth,
+		tables:   make(map[uint8][]*rule),
+	}
+}
+
+func (e *Evaluator) resolvePort(name string) (uint64, bool) {
+	port, ok := e.Ports[name]
+	return uint64(port), ok
+}
+
+// AddFlows parses and adds the flows, which have the same format
+// as the argument of ovs.AddFlows or the output of dump-flows.
+func (e *Evaluator) AddFlows(flows ...string) error {
+	for _, s := range flows {
+		flow, err := ovs.ParseFlow(s)
+		if err != nil {
+			return err
+		} else if err = e.Add(flow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add adds the parsed flows.
+//
+// The flow with the same table, priority and match will be replaced.
+func (e *Evaluator) Add(flows ...ovs.Flow) error {
+	for _, flow := range flows {
+		r, err := e.compile(flow)
+		if err != nil {
+			return fmt.Errorf("invalid flow '%s': %v", flow, err)
+		}
+
+		rules := e.tables[flow.Table]
+		replaced := false
+		for i, _r := range rules {
+			if _r.Flow.Priority == flow.Priority && _r.Flow.Match == flow.Match {
+				rules[i], replaced = r, true
+				break
+			}
+		}
+
+		if !replaced {
+			rules = append(rules, r)
+			sort.SliceStable(rules, func(i, j int) bool {
+				return rules[i].Flow.Priority > rules[j].Flow.Priority
+			})
+		}
+		e.tables[flow.Table] = rules
+	}
+	return nil
+}
+
+// DelFlowsByCookie deletes all the flows with the cookie.
+func (e *Evaluator) DelFlowsByCookie(cookie uint64) {
+	for table, rules := range e.tables {
+		kept := rules[:0]
+		for _, r := range rules {
+			if r.Flow.Cookie != cookie {
+				kept = append(kept, r)
+			}
+		}
+		e.tables[table] = kept
+	}
+}
+
+// Flows returns all the flows sorted by the table and the priority.
+func (e *Evaluator) Flows() []ovs.Flow {
+	tables := make([]int, 0, len(e.tables))
+	for table := range e.tables {
+		tables = append(tables, int(table))
+	}
+	sort.Ints(tables)
+
+	var flows []ovs.Flow
+	for _, table := range tables {
+		for _, r := range e.tables[uint8(table)] {
+			flows = append(flows, r.Flow)
+		}
+	}
+	return flows
+}
+
+func (e *Evaluator) compile(flow ovs.Flow) (r *rule, err error) {
+	r = &rule{Flow: flow, Actions: ovs.SplitActions(flow.Actions)}
+	if r.Match, err = parseMatch(flow.Match, e.resolvePort); err != nil {
+		return
+	}
+
+	for _, action := range r.Actions {
+		if err = e.checkAction(action); err != nil {
+			return
+		}
+
+		var conj conjunction
+		if _, e := fmt.Sscanf(action, "conjunction(%d,%d/%d)", &conj.ID, &conj.K, &conj.N); e == nil {
+			if conj.N < 2 || conj.K < 1 || conj.K > conj.N {
+				return nil, fmt.Errorf("invalid action '%s'", action)
+			}
+			r.Conj = append(r.Conj, conj)
+		}
+	}
+
+	if len(r.Conj) > 0 && len(r.Conj) != len(r.Actions) {
+		return nil, errors.New("the conjunction action must not be mixed with other actions")
+	}
+	return
+}
+
+// lookup returns the flow with the highest priority matching the packet
+// in the table, or nil if no flow matches.
+func (e *Evaluator) lookup(table uint8, p Packet) *rule {
+	type conjState struct {
+		Priority int
+		N        int
+		Clauses  map[int]struct{}
+	}
+
+	rules := e.tables[table]
+	conjs := make(map[uint32]*conjState)
+
+	var best *rule
+	for _, r := range rules {
+		if !r.Match.Match(p) {
+			continue
+		} else if len(r.Conj) == 0 {
+			best = r
+			break
+		}
+
+		for _, c := range r.Conj {
+			s, ok := conjs[c.ID]
+			if !ok {
+				s = &conjState{Priority: r.Flow.Priority, N: c.N, Clauses: make(map[int]struct{})}
+				conjs[c.ID] = s
+			}
+			s.Clauses[c.K] = struct{}{}
+		}
+	}
+
+	// The conjunctive match takes effect only if all the dimensions match
+	// and its priority is higher than the matched ordinary flow.
+	ids := make([]uint32, 0, len(conjs))
+	for id, s := range conjs {
+		if len(s.Clauses) == s.N && (best == nil || s.Priority > best.Flow.Priority) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return conjs[ids[i]].Priority > conjs[ids[j]].Priority })
+
+	for _, id := range ids {
+		cp := p.Clone()
+		cp.Set("conj_id", ovs.Uint128{Lo: uint64(id)})
+		for _, r := range rules {
+			if len(r.Conj) == 0 && r.Match.has("conj_id") && r.Match.Match(cp) {
+				return r
+			}
+		}
+	}
+
+	return best
+}
+
+// Stage is a table lookup of the packet in the pipeline.
+type Stage struct {
+	Table uint8
+	Depth int // The depth of resubmit
+
+	// Flow is the matched flow. If no flow matches, it is nil.
+	Flow *ovs.Flow
+
+	// Actions is the actions executed in the stage.
+	Actions []string
+
+	// Notes is the notes of the stage, such as the unsupported actions.
+	Notes []string
+}
+
+// Output is the packet output to a port.
+type Output struct {
+	Port   int // The port number, or the reserved port such as PortController.
+	Packet Packet
+}
+
+// Result is the result of the packet evaluated by the pipeline.
+type Result struct {
+	Stages  []Stage
+	Actions []string // All the executed actions in order
+	Outputs []Output
+	Packet  Packet // The final packet
+}
+
+// Dropped reports whether the packet is dropped, that's, no output.
+func (r Result) Dropped() bool { return len(r.Outputs) == 0 }
+
+// Trace parses the packet and evaluates it from table 0,
+// such as "in_port=1,tcp,nw_src=10.0.0.1,nw_dst=10.0.0.2,tp_dst=80".
+func (e *Evaluator) Trace(packet string) (Result, error) {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return Result{}, err
+	}
+	return e.Run(p)
+}
+
+// Run evaluates the packet from table 0.
+func (e *Evaluator) Run(p Packet) (result Result, err error) {
+	s := &state{e: e, packet: p.Clone(), result: &result}
+	if err = s.lookup(0, 0); err == nil {
+		for len(s.recircs) > 0 && err == nil {
+			recirc := s.recircs[0]
+			s.recircs = s.recircs[1:]
+			s.packet, s.exit = recirc.Packet, false
+			err = s.lookup(recirc.Table, 0)
+		}
+	}
+	result.Packet = s.packet
+	return
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowsim
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xgfone/go-ovs"
+)
+
+func mustEvaluator(t *testing.T, flows ...string) *Evaluator {
+	e := NewEvaluator()
+	e.Ports["vm1"] = 1
+	e.Ports["vm2"] = 2
+	if err := e.AddFlows(flows...); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func mustTrace(t *testing.T, e *Evaluator, packet string) Result {
+	result, err := e.Trace(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestEvaluatorPipeline(t *testing.T) {
+	e := mustEvaluator(t,
+		"table=0,priority=100,in_port=vm1,actions=load:0x1->NXM_NX_REG5[],goto_table:10",
+		"table=0,priority=0,actions=drop",
+		"table=10,priority=100,ip,nw_dst=10.0.0.0/24,actions=resubmit(,20),output:vm2",
+		"table=20,priority=100,reg5=0x1,actions=set_field:00:00:00:00:00:02->eth_dst",
+	)
+
+	result := mustTrace(t, e, "in_port=1,ip,nw_src=10.0.0.1,nw_dst=10.0.0.2")
+	if len(result.Stages) != 3 {
+		t.Fatalf("expect 3 stages, but got %d", len(result.Stages))
+	} else if result.Stages[2].Depth != 1 {
+		t.Errorf("expect the depth 1, but got %d", result.Stages[2].Depth)
+	}
+
+	if len(result.Outputs) != 1 || result.Outputs[0].Port != 2 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	} else if v := result.Outputs[0].Packet.Get("dl_dst"); v.Lo != 2 {
+		t.Errorf("expect dl_dst 2, but got %s", v)
+	}
+
+	result = mustTrace(t, e, "in_port=2,ip,nw_dst=10.0.0.1")
+	if !result.Dropped() || len(result.Stages) != 1 {
+		t.Errorf("expect the packet to be dropped in table 0: %+v", result)
+	}
+
+	result = mustTrace(t, e, "in_port=1,ip,nw_dst=10.0.1.1")
+	if !result.Dropped() || len(result.Stages) != 2 || result.Stages[1].Flow != nil {
+		t.Errorf("expect the packet to miss table 10: %+v", result)
+	}
+}
+
+func TestEvaluatorPortRangeMasks(t *testing.T) {
+	var flows []string
+	for _, m := range ovs.PortRuleMasking(1000, 1999) {
+		flows = append(flows, fmt.Sprintf("priority=100,tcp,tp_dst=%s,actions=output:2", m))
+	}
+
+	e := mustEvaluator(t, flows...)
+	for _, port := range []int{999, 1000, 1500, 1999, 2000} {
+		result := mustTrace(t, e, fmt.Sprintf("in_port=1,tcp,tp_dst=%d", port))
+		if expect := port >= 1000 && port <= 1999; result.Dropped() == expect {
+			t.Errorf("port %d: expect matched=%v", port, expect)
+		}
+	}
+}
+
+func TestEvaluatorConjunction(t *testing.T) {
+	e := mustEvaluator(t,
+		"priority=100,ip,nw_src=10.0.0.1,actions=conjunction(7,1/2)",
+		"priority=100,ip,nw_src=10.0.0.2,actions=conjunction(7,1/2)",
+		"priority=100,tcp,tp_dst=80,actions=conjunction(7,2/2)",
+		"priority=100,conj_id=7,ip,actions=output:2",
+		"priority=50,ip,nw_src=10.0.0.3,actions=output:1",
+	)
+
+	result := mustTrace(t, e, "in_port=1,tcp,nw_src=10.0.0.2,tp_dst=80")
+	if result.Dropped() || result.Outputs[0].Port != 2 {
+		t.Errorf("expect the conjunction to match: %+v", result)
+	}
+
+	result = mustTrace(t, e, "in_port=1,tcp,nw_src=10.0.0.2,tp_dst=22")
+	if !result.Dropped() {
+		t.Errorf("expect the conjunction not to match: %+v", result)
+	}
+
+	result = mustTrace(t, e, "in_port=2,tcp,nw_src=10.0.0.3,tp_dst=22")
+	if result.Dropped() || result.Outputs[0].Port != 1 {
+		t.Errorf("expect the ordinary flow to match: %+v", result)
+	}
+}
+
+func TestEvaluatorActions(t *testing.T) {
+	e := mustEvaluator(t,
+		"table=0,actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],load:0xa->NXM_NX_REG0[8..15],ct(zone=5,table=1)",
+		"table=1,ct_state=+trk+new,actions=ct(commit,zone=NXM_NX_CT_ZONE[],table=2,"+
+			"exec(load:0x1->NXM_NX_CT_MARK[],load:0x1->NXM_NX_REG1[])),drop",
+		"table=2,ct_mark=0x1,actions=dec_ttl,output:2",
+	)
+
+	result := mustTrace(t, e, "in_port=1,ip,dl_src=00:00:00:00:00:01,nw_ttl=64")
+	if len(result.Outputs) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	p := result.Outputs[0].Packet
+	for name, expect := range map[string]uint64{
+		"dl_dst":  1,
+		"reg0":    0xa00,
+		"ct_zone": 5,
+		"ct_mark": 1,
+		"reg1":    0,
+		"nw_ttl":  63,
+	} {
+		if v := p.Get(name); v.Lo != expect {
+			t.Errorf("%s: expect %d, but got %s", name, expect, v)
+		}
+	}
+
+	if _, err := NewEvaluator().Trace("in_port=1,ip,nw_src=10.0.0.1/24"); err == nil {
+		t.Error("expect an error for the masked packet field")
+	}
+
+	if err := NewEvaluator().AddFlows("actions=load:0x10000->NXM_NX_REG0[0..15]"); err == nil {
+		t.Error("expect an error for the overflowed load")
+	}
+}
+
+func TestEvaluatorCTFork(t *testing.T) {
+	e := mustEvaluator(t,
+		"table=0,actions=ct(commit,zone=5,table=1,exec(load:0x1->NXM_NX_CT_MARK[])),output:2",
+		"table=1,ct_state=+trk+new,actions=output:3",
+	)
+
+	result := mustTrace(t, e, "in_port=1,ip")
+	if len(result.Outputs) != 2 || result.Outputs[0].Port != 2 || result.Outputs[1].Port != 3 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	}
+
+	trk := uint64(ovs.CTStateTrk | ovs.CTStateNew)
+	for i, expects := range []map[string]uint64{
+		{"ct_state": 0, "ct_zone": 0, "ct_mark": 0},   // The action after ct
+		{"ct_state": trk, "ct_zone": 5, "ct_mark": 1}, // The recirculated packet
+	} {
+		p := result.Outputs[i].Packet
+		for name, expect := range expects {
+			if v := p.Get(name); v.Lo != expect {
+				t.Errorf("output %d: expect %s %d, but got %s", result.Outputs[i].Port, name, expect, v)
+			}
+		}
+	}
+}
+
+func TestEvaluatorTrackedPacket(t *testing.T) {
+	e := mustEvaluator(t,
+		"table=0,actions=ct(zone=5,table=1)",
+		"table=1,ct_state=+trk+est-new,actions=output:2",
+		"table=1,ct_state=+trk+new,actions=output:3",
+	)
+
+	result := mustTrace(t, e, "in_port=1,ct_state=+trk+est,ip")
+	if len(result.Outputs) != 1 || result.Outputs[0].Port != 2 {
+		t.Errorf("expect the established packet to output 2, but got %+v", result.Outputs)
+	}
+
+	if _, err := e.Trace("in_port=1,ct_state=+trk-new,ip"); err == nil {
+		t.Error("expect an error for the masked ct_state")
+	}
+}
+
+func TestEvaluatorResubmitLoop(t *testing.T) {
+	e := mustEvaluator(t, "table=0,actions=resubmit(,0)")
+	if _, err := e.Trace("in_port=1"); err == nil {
+		t.Error("expect an error for the resubmit loop")
+	}
+}
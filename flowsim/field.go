@@ -0,0 +1,342 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowsim
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-ovs"
+)
+
+type fieldKind int
+
+const (
+	kindInt fieldKind = iota
+	kindPort
+	kindMAC
+	kindIPv4
+	kindIPv6
+	kindCTState
+)
+
+type field struct {
+	Name string
+	Bits int
+	Kind fieldKind
+}
+
+var fields = make(map[string]field, 128)
+
+func init() {
+	register := func(f field, aliases ...string) {
+		fields[f.Name] = f
+		for _, alias := range aliases {
+			fields[alias] = f
+		}
+	}
+
+	register(field{"in_port", 16, kindPort}, "NXM_OF_IN_PORT")
+	register(field{"dl_src", 48, kindMAC}, "eth_src", "NXM_OF_ETH_SRC")
+	register(field{"dl_dst", 48, kindMAC}, "eth_dst", "NXM_OF_ETH_DST")
+	register(field{"dl_type", 16, kindInt}, "eth_type", "NXM_OF_ETH_TYPE")
+	register(field{"vlan_tci", 16, kindInt}, "NXM_OF_VLAN_TCI")
+	register(field{"nw_src", 32, kindIPv4}, "ip_src", "NXM_OF_IP_SRC")
+	register(field{"nw_dst", 32, kindIPv4}, "ip_dst", "NXM_OF_IP_DST")
+	register(field{"nw_proto", 8, kindInt}, "ip_proto", "NXM_OF_IP_PROTO")
+	register(field{"nw_tos", 8, kindInt}, "NXM_OF_IP_TOS")
+	register(field{"nw_ttl", 8, kindInt}, "NXM_NX_IP_TTL")
+	register(field{"ipv6_src", 128, kindIPv6}, "NXM_NX_IPV6_SRC")
+	register(field{"ipv6_dst", 128, kindIPv6}, "NXM_NX_IPV6_DST")
+	register(field{"tp_src", 16, kindInt}, "tcp_src", "udp_src", "sctp_src",
+		"NXM_OF_TCP_SRC", "NXM_OF_UDP_SRC")
+	register(field{"tp_dst", 16, kindInt}, "tcp_dst", "udp_dst", "sctp_dst",
+		"NXM_OF_TCP_DST", "NXM_OF_UDP_DST")
+	register(field{"icmp_type", 8, kindInt}, "icmpv6_type", "NXM_OF_ICMP_TYPE", "NXM_NX_ICMPV6_TYPE")
+	register(field{"icmp_code", 8, kindInt}, "icmpv6_code", "NXM_OF_ICMP_CODE", "NXM_NX_ICMPV6_CODE")
+	register(field{"arp_op", 16, kindInt}, "NXM_OF_ARP_OP")
+	register(field{"arp_spa", 32, kindIPv4}, "NXM_OF_ARP_SPA")
+	register(field{"arp_tpa", 32, kindIPv4}, "NXM_OF_ARP_TPA")
+	register(field{"arp_sha", 48, kindMAC}, "NXM_NX_ARP_SHA")
+	register(field{"arp_tha", 48, kindMAC}, "NXM_NX_ARP_THA")
+	register(field{"nd_target", 128, kindIPv6}, "NXM_NX_ND_TARGET")
+	register(field{"nd_sll", 48, kindMAC}, "NXM_NX_ND_SLL")
+	register(field{"nd_tll", 48, kindMAC}, "NXM_NX_ND_TLL")
+	register(field{"metadata", 64, kindInt}, "OXM_OF_METADATA")
+	register(field{"tun_id", 64, kindInt}, "tunnel_id", "NXM_NX_TUN_ID")
+	register(field{"tun_src", 32, kindIPv4}, "NXM_NX_TUN_IPV4_SRC")
+	register(field{"tun_dst", 32, kindIPv4}, "NXM_NX_TUN_IPV4_DST")
+	register(field{"pkt_mark", 32, kindInt}, "NXM_NX_PKT_MARK")
+	register(field{"ct_state", 32, kindCTState}, "NXM_NX_CT_STATE")
+	register(field{"ct_zone", 16, kindInt}, "NXM_NX_CT_ZONE")
+	register(field{"ct_mark", 32, kindInt}, "NXM_NX_CT_MARK")
+	register(field{"ct_label", 128, kindInt}, "NXM_NX_CT_LABEL")
+	register(field{"conj_id", 32, kindInt})
+
+	for i := 0; i < 16; i++ {
+		register(field{fmt.Sprintf("reg%d", i), 32, kindInt}, fmt.Sprintf("NXM_NX_REG%d", i))
+	}
+	for i := 0; i < 8; i++ {
+		register(field{fmt.Sprintf("xreg%d", i), 64, kindInt}, fmt.Sprintf("OXM_OF_PKT_REG%d", i))
+	}
+	for i := 0; i < 4; i++ {
+		register(field{fmt.Sprintf("xxreg%d", i), 128, kindInt}, fmt.Sprintf("NXM_NX_XXREG%d", i))
+	}
+}
+
+func lookupField(name string) (field, error) {
+	if f, ok := fields[name]; ok {
+		return f, nil
+	}
+	return field{}, fmt.Errorf("unsupported field '%s'", name)
+}
+
+// Protocol shorthands, such as "tcp", which are expanded to the fields.
+var protocols = map[string][]matchField{
+	"ip":    protoMatch(ovs.IPv4, 0),
+	"ipv6":  protoMatch(ovs.IPv6, 0),
+	"arp":   protoMatch(ovs.ARP, 0),
+	"rarp":  protoMatch(0x8035, 0),
+	"icmp":  protoMatch(ovs.IPv4, ovs.ICMP),
+	"tcp":   protoMatch(ovs.IPv4, ovs.TCP),
+	"udp":   protoMatch(ovs.IPv4, ovs.UDP),
+	"sctp":  protoMatch(ovs.IPv4, 132),
+	"icmp6": protoMatch(ovs.IPv6, ovs.ICMPv6),
+	"tcp6":  protoMatch(ovs.IPv6, ovs.TCP),
+	"udp6":  protoMatch(ovs.IPv6, ovs.UDP),
+	"sctp6": protoMatch(ovs.IPv6, 132),
+}
+
+func protoMatch(ethType, ipProto uint64) []matchField {
+	m := []matchField{{Name: "dl_type", Value: ovs.Uint128{Lo: ethType}, Mask: ovs.Uint128Ones(16)}}
+	if ipProto > 0 {
+		m = append(m, matchField{Name: "nw_proto", Value: ovs.Uint128{Lo: ipProto}, Mask: ovs.Uint128Ones(8)})
+	}
+	return m
+}
+
+// Reserved OpenFlow 1.0 port numbers.
+const (
+	PortInPort     = 0xfff8
+	PortTable      = 0xfff9
+	PortNormal     = 0xfffa
+	PortFlood      = 0xfffb
+	PortAll        = 0xfffc
+	PortController = 0xfffd
+	PortLocal      = 0xfffe
+	PortNone       = 0xffff
+)
+
+var reservedPorts = map[string]uint64{
+	"IN_PORT":    PortInPort,
+	"TABLE":      PortTable,
+	"NORMAL":     PortNormal,
+	"FLOOD":      PortFlood,
+	"ALL":        PortAll,
+	"CONTROLLER": PortController,
+	"LOCAL":      PortLocal,
+	"NONE":       PortNone,
+}
+
+// portResolver resolves the port name to the port number.
+type portResolver func(name string) (uint64, bool)
+
+// parseFieldValue parses the value, which may have a mask, of the field.
+func parseFieldValue(f field, s string, resolve portResolver) (value, mask ovs.Uint128, err error) {
+	mask = ovs.Uint128Ones(f.Bits)
+	switch f.Kind {
+	case kindCTState:
+		return parseCTState(s)
+
+	case kindPort:
+		if v, ok := reservedPorts[strings.ToUpper(s)]; ok {
+			return ovs.Uint128{Lo: v}, mask, nil
+		} else if resolve != nil {
+			if v, ok := resolve(s); ok {
+				return ovs.Uint128{Lo: v}, mask, nil
+			}
+		}
+		value, err = ovs.ParseUint128(s)
+
+	case kindMAC:
+		var m string
+		s, m, _ = strings.Cut(s, "/")
+		if value, err = parseMAC(s); err == nil && m != "" {
+			mask, err = parseMAC(m)
+		}
+
+	case kindIPv4, kindIPv6:
+		value, mask, err = parseIP(s, f.Kind == kindIPv4)
+
+	default:
+		var m string
+		s, m, _ = strings.Cut(s, "/")
+		if value, err = ovs.ParseUint128(s); err == nil && m != "" {
+			mask, err = ovs.ParseUint128(m)
+		}
+	}
+
+	if err != nil {
+		return value, mask, fmt.Errorf("invalid value '%s' of the field '%s': %v", s, f.Name, err)
+	}
+
+	ones := ovs.Uint128Ones(f.Bits)
+	if value.Cmp(ones) > 0 {
+		return value, mask, fmt.Errorf("the value '%s' overflows the field '%s'", s, f.Name)
+	}
+	return value.And(mask), mask.And(ones), nil
+}
+
+func parseMAC(s string) (ovs.Uint128, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		return ovs.Uint128{}, fmt.Errorf("invalid mac '%s'", s)
+	}
+
+	var v uint64
+	for _, b := range mac {
+		v = v<<8 | uint64(b)
+	}
+	return ovs.Uint128{Lo: v}, nil
+}
+
+func parseIP(s string, is4 bool) (value, mask ovs.Uint128, err error) {
+	bits := 128
+	if is4 {
+		bits = 32
+	}
+
+	addr, m, hasMask := strings.Cut(s, "/")
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return
+	} else if ip.Is4() != is4 {
+		return value, mask, fmt.Errorf("the address '%s' is not the expected family", addr)
+	}
+	value = ovs.Uint128FromAddr(ip)
+	mask = ovs.Uint128Ones(bits)
+
+	if hasMask {
+		if n, e := strconv.Atoi(m); e == nil {
+			if n < 0 || n > bits {
+				return value, mask, fmt.Errorf("invalid prefix length %d", n)
+			}
+			mask = ovs.Uint128Ones(bits).And(ovs.Uint128Ones(bits - n).Not())
+		} else if mip, e := netip.ParseAddr(m); e == nil && mip.Is4() == is4 {
+			mask = ovs.Uint128FromAddr(mip)
+		} else {
+			return value, mask, fmt.Errorf("invalid mask '%s'", m)
+		}
+	}
+
+	return
+}
+
+var ctStateFlags = map[string]uint64{
+	"new":  uint64(ovs.CTStateNew),
+	"est":  uint64(ovs.CTStateEst),
+	"rel":  uint64(ovs.CTStateRel),
+	"rpl":  uint64(ovs.CTStateRpl),
+	"inv":  uint64(ovs.CTStateInv),
+	"trk":  uint64(ovs.CTStateTrk),
+	"snat": uint64(ovs.CTStateSNAT),
+	"dnat": uint64(ovs.CTStateDNAT),
+}
+
+// parseCTState parses the ct_state flags, such as "+trk+est-rel",
+// or the value with the mask, such as "0x22/0x3f".
+func parseCTState(s string) (value, mask ovs.Uint128, err error) {
+	if s == "" || (s[0] != '+' && s[0] != '-') {
+		var m string
+		s, m, _ = strings.Cut(s, "/")
+		if value, err = ovs.ParseUint128(s); err == nil {
+			mask = ovs.Uint128Ones(32)
+			if m != "" {
+				mask, err = ovs.ParseUint128(m)
+			}
+		}
+		return
+	}
+
+	for len(s) > 0 {
+		set := s[0] == '+'
+		if !set && s[0] != '-' {
+			return value, mask, fmt.Errorf("invalid ct_state '%s'", s)
+		}
+
+		end := strings.IndexAny(s[1:], "+-")
+		if end < 0 {
+			end = len(s)
+		} else {
+			end++
+		}
+
+		flag, ok := ctStateFlags[s[1:end]]
+		if !ok {
+			return value, mask, fmt.Errorf("unknown ct_state flag '%s'", s[1:end])
+		}
+
+		mask.Lo |= flag
+		if set {
+			value.Lo |= flag
+		}
+		s = s[end:]
+	}
+
+	return
+}
+
+// formatFieldValue formats the value of the field as the string.
+func formatFieldValue(f field, v ovs.Uint128) string {
+	switch f.Kind {
+	case kindMAC:
+		mac := make(net.HardwareAddr, 6)
+		for i := 5; i >= 0; i-- {
+			mac[i] = byte(v.Lo)
+			v.Lo >>= 8
+		}
+		return mac.String()
+
+	case kindIPv4, kindIPv6:
+		return v.Addr(f.Kind == kindIPv4).String()
+
+	case kindCTState:
+		var b strings.Builder
+		for _, name := range []string{"new", "est", "rel", "rpl", "inv", "trk", "snat", "dnat"} {
+			if v.Lo&ctStateFlags[name] != 0 {
+				b.WriteByte('+')
+				b.WriteString(name)
+			}
+		}
+		return b.String()
+
+	case kindPort:
+		for name, port := range reservedPorts {
+			if v.Hi == 0 && v.Lo == port {
+				return name
+			}
+		}
+		return strconv.FormatUint(v.Lo, 10)
+
+	default:
+		if v.Hi == 0 && v.Lo < 10 {
+			return strconv.FormatUint(v.Lo, 10)
+		}
+		return v.String()
+	}
+}
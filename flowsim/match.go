@@ -0,0 +1,108 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-ovs"
+)
+
+type matchField struct {
+	Name  string
+	Value ovs.Uint128
+	Mask  ovs.Uint128
+}
+
+type match []matchField
+
+// The flow arguments which are not the match fields.
+var flowOptions = map[string]struct{}{
+	"idle_timeout":     {},
+	"hard_timeout":     {},
+	"importance":       {},
+	"send_flow_rem":    {},
+	"check_overlap":    {},
+	"reset_counts":     {},
+	"no_packet_counts": {},
+	"no_byte_counts":   {},
+	"out_port":         {},
+	"out_group":        {},
+}
+
+func parseMatch(s string, resolve portResolver) (m match, err error) {
+	for _, arg := range ovs.SplitActions(s) {
+		if protoFields, ok := protocols[arg]; ok {
+			m = append(m, protoFields...)
+			continue
+		}
+
+		name, value, _ := strings.Cut(arg, "=")
+		if _, ok := flowOptions[name]; ok {
+			continue
+		}
+
+		if name == "dl_vlan" {
+			var vlan uint64
+			if vlan, err = strconv.ParseUint(value, 0, 16); err != nil {
+				return nil, fmt.Errorf("invalid dl_vlan '%s'", value)
+			}
+
+			if vlan == 0xffff { // No VLAN header
+				m = append(m, matchField{Name: "vlan_tci", Mask: ovs.Uint128{Lo: 0x1000}})
+			} else {
+				m = append(m, matchField{Name: "vlan_tci",
+					Value: ovs.Uint128{Lo: 0x1000 | vlan&0xfff},
+					Mask:  ovs.Uint128{Lo: 0x1fff}})
+			}
+			continue
+		}
+
+		f, err := lookupField(name)
+		if err != nil {
+			return nil, err
+		}
+
+		v, mask, err := parseFieldValue(f, value, resolve)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, matchField{Name: f.Name, Value: v, Mask: mask})
+	}
+
+	return
+}
+
+// Match reports whether the packet matches all the fields.
+func (m match) Match(p Packet) bool {
+	for _, f := range m {
+		if p.Get(f.Name).And(f.Mask) != f.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// has reports whether the match contains the field.
+func (m match) has(name string) bool {
+	for _, f := range m {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowsim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xgfone/go-ovs"
+)
+
+// Packet is the header fields and the metadata of a packet,
+// the key of which is the canonical field name, such as "nw_src".
+//
+// The missing field is regarded as zero.
+type Packet map[string]ovs.Uint128
+
+// ParsePacket parses the packet described by the fields like ofproto/trace,
+// such as "in_port=1,tcp,nw_src=10.0.0.1,nw_dst=10.0.0.2,tp_dst=80".
+func ParsePacket(s string) (Packet, error) {
+	p := make(Packet, 16)
+	for _, arg := range ovs.SplitActions(s) {
+		if protoFields, ok := protocols[arg]; ok {
+			for _, f := range protoFields {
+				p.Set(f.Name, f.Value)
+			}
+			continue
+		}
+
+		name, value, _ := strings.Cut(arg, "=")
+		f, err := lookupField(name)
+		if err != nil {
+			return nil, err
+		}
+
+		// The ct_state flags, such as "+trk+est", set the given flags
+		// and clear all the others.
+		ctFlags := f.Kind == kindCTState && strings.HasPrefix(value, "+") && !strings.Contains(value, "-")
+
+		v, mask, err := parseFieldValue(f, value, nil)
+		if err != nil {
+			return nil, err
+		} else if mask != ovs.Uint128Ones(f.Bits) && !ctFlags {
+			return nil, fmt.Errorf("the packet field '%s' must not have a mask", name)
+		}
+		p.Set(f.Name, v)
+	}
+	return p, nil
+}
+
+// Clone returns a copy of the packet.
+func (p Packet) Clone() Packet {
+	c := make(Packet, len(p))
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+// Get returns the value of the field.
+func (p Packet) Get(name string) ovs.Uint128 {
+	f, ok := fields[name]
+	if !ok {
+		return p[name]
+	}
+
+	switch {
+	case strings.HasPrefix(f.Name, "xxreg"):
+		n := int(f.Name[5] - '0')
+		return p.Get(fmt.Sprintf("xreg%d", 2*n)).Lsh(64).Or(p.Get(fmt.Sprintf("xreg%d", 2*n+1)))
+	case strings.HasPrefix(f.Name, "xreg"):
+		n := int(f.Name[4] - '0')
+		return p[fmt.Sprintf("reg%d", 2*n)].Lsh(32).Or(p[fmt.Sprintf("reg%d", 2*n+1)])
+	default:
+		return p[f.Name]
+	}
+}
+
+// Set sets the value of the field.
+func (p Packet) Set(name string, value ovs.Uint128) {
+	f, ok := fields[name]
+	if !ok {
+		p[name] = value
+		return
+	}
+
+	value = value.And(ovs.Uint128Ones(f.Bits))
+	switch {
+	case strings.HasPrefix(f.Name, "xxreg"):
+		n := int(f.Name[5] - '0')
+		p.Set(fmt.Sprintf("xreg%d", 2*n), value.Rsh(64))
+		p.Set(fmt.Sprintf("xreg%d", 2*n+1), value)
+	case strings.HasPrefix(f.Name, "xreg"):
+		n := int(f.Name[4] - '0')
+		p.Set(fmt.Sprintf("reg%d", 2*n), value.Rsh(32))
+		p.Set(fmt.Sprintf("reg%d", 2*n+1), value)
+	case value.IsZero():
+		delete(p, f.Name)
+	default:
+		p[f.Name] = value
+	}
+}
+
+// String formats the packet as the fields sorted by the name,
+// such as "dl_type=0x800,in_port=1,nw_src=10.0.0.1".
+func (p Packet) String() string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]string, len(names))
+	for i, name := range names {
+		if f, ok := fields[name]; ok {
+			items[i] = fmt.Sprintf("%s=%s", name, formatFieldValue(f, p[name]))
+		} else {
+			items[i] = fmt.Sprintf("%s=%s", name, p[name])
+		}
+	}
+	return strings.Join(items, ",")
+}
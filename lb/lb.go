@@ -0,0 +1,254 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lb compiles the L4 load balancers, which balance the connections
+// to VIP:PORT across the backends, into the OpenFlow select groups
+// and the flows based on ct(nat).
+//
+// All the load balancers share one conntrack zone, and the packets flow as:
+//
+//	VIP:   PROTO,dst=VIP:PORT             -> ct(zone,nat) -> LB
+//	       tcp, udp, tcp6 or udp6         -> ct(zone,nat) -> LB
+//	       others                         -> Next
+//	LB:    new to VIP:PORT                -> group:ID
+//	       others to VIP:PORT             -> drop
+//	       others                         -> Next
+//	Group: bucket=weight:W,actions=ct(commit,zone,nat(dst=BACKEND:PORT)) -> Next
+//
+// The select group only picks the backend of a new connection. The later
+// packets and the replies are translated by the conntrack entry, which is
+// looked up without knowing the backend, so they no longer match VIP:PORT
+// and go to Next. Therefore, adding or removing a backend never disturbs
+// the established connections.
+package lb
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/xgfone/go-ovs"
+)
+
+// Protocols of the load balancer.
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// Tables is the OpenFlow tables used by the load balancer pipeline.
+type Tables struct {
+	VIP uint8
+	LB  uint8
+
+	// Next is the table to which the translated packets go.
+	Next uint8
+}
+
+// DefaultTables is the default tables of the load balancer pipeline.
+var DefaultTables = Tables{VIP: 50, LB: 51, Next: 60}
+
+// DefaultCookieBase is the default base of the cookies of the flows.
+const DefaultCookieBase uint64 = 0x4c42 << 48
+
+// Backend is a backend of the load balancer.
+type Backend struct {
+	IP     netip.Addr
+	Port   uint16
+	Weight int // If 0, use the default weight of the select group, that's, 100.
+}
+
+// LoadBalancer is a L4 load balancer.
+type LoadBalancer struct {
+	ID       uint32 // Must not be 0, which is also the id of the select group.
+	Protocol string // ProtoTCP or ProtoUDP
+	VIP      netip.Addr
+	Port     uint16
+	Backends []Backend
+}
+
+// AddBackend adds the backend, or updates it if it has existed.
+func (lb *LoadBalancer) AddBackend(backend Backend) {
+	for i, b := range lb.Backends {
+		if b.IP == backend.IP && b.Port == backend.Port {
+			lb.Backends[i] = backend
+			return
+		}
+	}
+	lb.Backends = append(lb.Backends, backend)
+}
+
+// RemoveBackend removes the backend by the ip and port, which only stops
+// balancing the new connections to it.
+func (lb *LoadBalancer) RemoveBackend(ip netip.Addr, port uint16) {
+	for i, b := range lb.Backends {
+		if b.IP == ip && b.Port == port {
+			lb.Backends = append(lb.Backends[:i:i], lb.Backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// match returns the match of the packets to VIP:PORT.
+func (lb LoadBalancer) match() string {
+	if lb.VIP.Is6() {
+		return fmt.Sprintf("%s6,ipv6_dst=%s,tp_dst=%d", lb.Protocol, lb.VIP, lb.Port)
+	}
+	return fmt.Sprintf("%s,nw_dst=%s,tp_dst=%d", lb.Protocol, lb.VIP, lb.Port)
+}
+
+func (lb LoadBalancer) validate() error {
+	switch {
+	case lb.ID == 0:
+		return fmt.Errorf("the load balancer id must not be 0")
+	case lb.Protocol != ProtoTCP && lb.Protocol != ProtoUDP:
+		return fmt.Errorf("invalid protocol '%s'", lb.Protocol)
+	case !lb.VIP.IsValid() || lb.VIP.Zone() != "" || lb.VIP.Is4In6():
+		return fmt.Errorf("invalid vip '%s'", lb.VIP)
+	case lb.Port == 0:
+		return fmt.Errorf("missing the vip port")
+	}
+
+	for _, b := range lb.Backends {
+		if !b.IP.IsValid() || b.IP.Is4() != lb.VIP.Is4() || b.Port == 0 || b.Weight < 0 {
+			return fmt.Errorf("invalid backend %s", netip.AddrPortFrom(b.IP, b.Port))
+		}
+	}
+	return nil
+}
+
+// Compiler is used to compile the load balancers into the groups and flows.
+type Compiler struct {
+	Tables Tables
+
+	// Zone is the conntrack zone shared by all the load balancers, which
+	// must not be 0 and must not be used by the other users of conntrack,
+	// such as the NAT gateways or the security groups.
+	Zone uint16
+
+	// CookieBase tags the flows of the load balancers, the lower 32 bits
+	// of which must be 0 and hold the load balancer id. The shared flows
+	// of the VIP and LB tables, returned by BaseFlows, use CookieBase itself.
+	CookieBase uint64
+}
+
+// NewCompiler returns a new compiler with the default tables and cookie base,
+// the conntrack zone of which must be set before compiling.
+func NewCompiler() *Compiler {
+	return &Compiler{Tables: DefaultTables, CookieBase: DefaultCookieBase}
+}
+
+// Cookie returns the cookie of the flows of the load balancer.
+func (c *Compiler) Cookie(lb LoadBalancer) uint64 {
+	return c.CookieBase | uint64(lb.ID)
+}
+
+func (c *Compiler) validate() error {
+	if c.Zone == 0 {
+		return fmt.Errorf("the conntrack zone of the load balancers must not be 0")
+	} else if uint32(c.CookieBase) != 0 {
+		return fmt.Errorf("the lower 32 bits of the cookie base 0x%x must be 0", c.CookieBase)
+	}
+	return nil
+}
+
+// BaseFlows returns the flows which send all the TCP and UDP packets through
+// the conntrack zone, so that the replies and the later packets of the load
+// balanced connections are translated whatever the backends are, and let
+// the other packets pass to the Next table untouched.
+func (c *Compiler) BaseFlows() []string {
+	t := c.Tables
+	next := fmt.Sprintf("goto_table:%d", t.Next)
+	ct := ovs.CT().Zone(c.Zone).Table(t.LB).NAT(ovs.CTNAT{}).String()
+	flow := func(table uint8, priority int, match, actions string) string {
+		return ovs.Flow{Cookie: c.CookieBase, Table: table, Priority: priority,
+			Match: match, Actions: actions}.String()
+	}
+
+	return []string{
+		flow(t.VIP, 10, "tcp", ct),
+		flow(t.VIP, 10, "udp", ct),
+		flow(t.VIP, 10, "tcp6", ct),
+		flow(t.VIP, 10, "udp6", ct),
+		flow(t.VIP, 0, "", next),
+		flow(t.LB, 0, "", next),
+	}
+}
+
+// Compile compiles the load balancer into the select group and the flows
+// to balance the new connections to VIP:PORT, which rely on BaseFlows
+// to translate the established connections.
+func (c *Compiler) Compile(lb LoadBalancer) (group ovs.Group, flows []string, err error) {
+	if err = c.validate(); err != nil {
+		return
+	} else if err = lb.validate(); err != nil {
+		err = fmt.Errorf("load balancer %d: %v", lb.ID, err)
+		return
+	}
+
+	t := c.Tables
+	cookie := c.Cookie(lb)
+	add := func(table uint8, priority int, match, actions string) {
+		flows = append(flows, ovs.Flow{Cookie: cookie, Table: table, Priority: priority,
+			Match: match, Actions: actions}.String())
+	}
+
+	group = ovs.Group{ID: lb.ID, Type: ovs.GroupTypeSelect, Buckets: make([]ovs.GroupBucket, 0, len(lb.Backends))}
+	for _, b := range lb.Backends {
+		dnat := ovs.CT().Commit().Zone(c.Zone).Table(t.Next).
+			NAT(ovs.CTNAT{Dst: true, IPMin: b.IP, PortMin: b.Port})
+		group.Buckets = append(group.Buckets, ovs.GroupBucket{Weight: b.Weight, Actions: dnat.String()})
+	}
+
+	match := lb.match()
+	add(t.VIP, 100, match, ovs.CT().Zone(c.Zone).Table(t.LB).NAT(ovs.CTNAT{}).String())
+	add(t.LB, 100, ovs.JoinMatches(ovs.CTState(ovs.CTStateTrk|ovs.CTStateNew, ovs.CTStateInv), match),
+		fmt.Sprintf("group:%d", lb.ID))
+	add(t.LB, 90, match, ovs.DROP)
+	return
+}
+
+// InstallBase installs the shared flows of BaseFlows on the bridge, which
+// should be done before updating any load balancer.
+func (c *Compiler) InstallBase(bridge string) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.CookieBase, c.BaseFlows()...)
+}
+
+// Update creates or modifies the select group of the load balancer
+// by mod-group --may-create, then replaces its flows in one OpenFlow bundle.
+//
+// The group is modified before and outside of the bundle, so the changed
+// backends take effect for the new connections at once, and the group stays
+// modified if replacing the flows fails, in which case Update should be
+// retried. The established connections are not affected, which are
+// translated by conntrack instead of the group.
+func (c *Compiler) Update(bridge string, lb LoadBalancer) error {
+	group, flows, err := c.Compile(lb)
+	if err != nil {
+		return err
+	} else if err = ovs.ModGroups(bridge, group); err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.Cookie(lb), flows...)
+}
+
+// Remove removes the flows and the select group of the load balancer.
+func (c *Compiler) Remove(bridge string, lb LoadBalancer) error {
+	if err := ovs.DelFlowsByCookie(bridge, c.Cookie(lb)); err != nil {
+		return err
+	}
+	return ovs.DelGroups(bridge, lb.ID)
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lb
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/xgfone/go-ovs/flowsim"
+)
+
+func TestCompile(t *testing.T) {
+	lb := LoadBalancer{
+		ID:       1,
+		Protocol: ProtoTCP,
+		VIP:      netip.MustParseAddr("10.0.0.100"),
+		Port:     80,
+		Backends: []Backend{
+			{IP: netip.MustParseAddr("10.0.0.1"), Port: 8080, Weight: 200},
+			{IP: netip.MustParseAddr("10.0.0.2"), Port: 8080},
+		},
+	}
+
+	c := NewCompiler()
+	c.Zone = 100
+	group, flows, err := c.Compile(lb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "group_id=1,type=select," +
+		"bucket=weight:200,actions=ct(commit,zone=100,table=60,nat(dst=10.0.0.1:8080))," +
+		"bucket=actions=ct(commit,zone=100,table=60,nat(dst=10.0.0.2:8080))"
+	if s := group.String(); s != expect {
+		t.Errorf("expect group '%s', but got '%s'", expect, s)
+	}
+
+	expects := []string{
+		"cookie=0x4c42000000000001,table=50,priority=100,tcp,nw_dst=10.0.0.100,tp_dst=80,actions=ct(zone=100,table=51,nat)",
+		"cookie=0x4c42000000000001,table=51,priority=100,ct_state=+trk+new-inv,tcp,nw_dst=10.0.0.100,tp_dst=80,actions=group:1",
+		"cookie=0x4c42000000000001,table=51,priority=90,tcp,nw_dst=10.0.0.100,tp_dst=80,actions=drop",
+	}
+	if len(flows) != len(expects) {
+		t.Fatalf("expect %d flows, but got %d: %v", len(expects), len(flows), flows)
+	}
+	for i, flow := range flows {
+		if flow != expects[i] {
+			t.Errorf("expect flow '%s', but got '%s'", expects[i], flow)
+		}
+	}
+
+	// flowsim neither simulates the group nor translates the addresses,
+	// so the new connections stop at the group, and the replies are
+	// traced by their conntrack state.
+	e := flowsim.NewEvaluator()
+	flows = append(flows, "table=0,priority=0,actions=goto_table:50", "table=60,priority=0,actions=output:2")
+	if err = e.AddFlows(append(c.BaseFlows(), flows...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Name   string
+		Packet string
+		Group  bool
+		Port   int // 0 means dropped
+	}{
+		{"new", "in_port=1,tcp,nw_dst=10.0.0.100,tp_dst=80", true, 0},
+		{"reply", "in_port=3,ct_state=+trk+est,tcp,nw_src=10.0.0.1,nw_dst=10.0.0.50,tp_src=8080", false, 2},
+		{"invalid", "in_port=1,ct_state=+trk+inv,tcp,nw_dst=10.0.0.100,tp_dst=80", false, 0},
+		{"other port", "in_port=1,tcp,nw_dst=10.0.0.100,tp_dst=443", false, 2},
+		{"other protocol", "in_port=1,icmp,nw_dst=10.0.0.100", false, 2},
+	} {
+		result, err := e.Trace(tc.Packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var group bool
+		for _, action := range result.Actions {
+			group = group || action == "group:1"
+		}
+
+		switch {
+		case group != tc.Group:
+			t.Errorf("%s: expect group=%v, but got actions %v", tc.Name, tc.Group, result.Actions)
+		case tc.Port == 0 && !result.Dropped():
+			t.Errorf("%s: expect no output, but got %+v", tc.Name, result.Outputs)
+		case tc.Port != 0 && (len(result.Outputs) != 1 || result.Outputs[0].Port != tc.Port):
+			t.Errorf("%s: expect the output port %d, but got %+v", tc.Name, tc.Port, result.Outputs)
+		}
+	}
+
+	lb6 := LoadBalancer{ID: 2, Protocol: ProtoUDP, VIP: netip.MustParseAddr("fd00::100"), Port: 53,
+		Backends: []Backend{{IP: netip.MustParseAddr("fd00::1"), Port: 53}}}
+	group, flows, err = c.Compile(lb6)
+	if err != nil {
+		t.Fatal(err)
+	} else if s := group.Buckets[0].Actions; s != "ct(commit,zone=100,table=60,nat(dst=[fd00::1]:53))" {
+		t.Errorf("unexpected bucket actions '%s'", s)
+	} else if expect := "cookie=0x4c42000000000002,table=50,priority=100,udp6,ipv6_dst=fd00::100,tp_dst=53," +
+		"actions=ct(zone=100,table=51,nat)"; flows[0] != expect {
+		t.Errorf("expect flow '%s', but got '%s'", expect, flows[0])
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	vip := netip.MustParseAddr("10.0.0.100")
+	for name, lb := range map[string]LoadBalancer{
+		"the id 0":       {Protocol: ProtoTCP, VIP: vip, Port: 80},
+		"the protocol":   {ID: 1, Protocol: "sctp", VIP: vip, Port: 80},
+		"the port 0":     {ID: 1, Protocol: ProtoTCP, VIP: vip},
+		"the mapped vip": {ID: 1, Protocol: ProtoTCP, VIP: netip.MustParseAddr("::ffff:10.0.0.100"), Port: 80},
+		"the IPv6 backend": {ID: 1, Protocol: ProtoTCP, VIP: vip, Port: 80,
+			Backends: []Backend{{IP: netip.MustParseAddr("fd00::1"), Port: 80}}},
+		"the negative weight": {ID: 1, Protocol: ProtoTCP, VIP: vip, Port: 80,
+			Backends: []Backend{{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Weight: -1}}},
+	} {
+		c := NewCompiler()
+		c.Zone = 100
+		if _, _, err := c.Compile(lb); err == nil {
+			t.Errorf("expect an error for %s", name)
+		}
+	}
+
+	lb := LoadBalancer{ID: 1, Protocol: ProtoTCP, VIP: vip, Port: 80}
+	if _, _, err := NewCompiler().Compile(lb); err == nil {
+		t.Error("expect an error for the zone 0")
+	}
+}
+
+func TestUpdateBackends(t *testing.T) {
+	b1 := netip.MustParseAddr("10.0.0.1")
+	b2 := netip.MustParseAddr("10.0.0.2")
+	b3 := netip.MustParseAddr("10.0.0.3")
+	lb := LoadBalancer{ID: 1, Protocol: ProtoTCP, VIP: netip.MustParseAddr("10.0.0.100"), Port: 80}
+
+	c := NewCompiler()
+	c.Zone = 100
+	bucket := func(ip netip.Addr, weight string) string {
+		return "bucket=" + weight + "actions=ct(commit,zone=100,table=60,nat(dst=" + ip.String() + ":8080))"
+	}
+
+	var flows []string
+	for i, step := range []struct {
+		Update func()
+		Group  string
+	}{
+		{func() {}, "group_id=1,type=select"},
+		{func() {
+			lb.AddBackend(Backend{IP: b1, Port: 8080, Weight: 50})
+			lb.AddBackend(Backend{IP: b2, Port: 8080})
+		}, "group_id=1,type=select," + bucket(b1, "weight:50,") + "," + bucket(b2, "")},
+		{func() { // Update the weight of the existing backend.
+			lb.AddBackend(Backend{IP: b1, Port: 8080, Weight: 200})
+			lb.AddBackend(Backend{IP: b3, Port: 8080})
+		}, "group_id=1,type=select," + bucket(b1, "weight:200,") + "," + bucket(b2, "") + "," + bucket(b3, "")},
+		{func() {
+			lb.RemoveBackend(b2, 8080)
+			lb.RemoveBackend(b2, 9090) // No such backend
+		}, "group_id=1,type=select," + bucket(b1, "weight:200,") + "," + bucket(b3, "")},
+		{func() {
+			lb.RemoveBackend(b1, 8080)
+			lb.RemoveBackend(b3, 8080)
+		}, "group_id=1,type=select"},
+	} {
+		step.Update()
+		group, stepFlows, err := c.Compile(lb)
+		if err != nil {
+			t.Fatal(err)
+		} else if s := group.String(); s != step.Group {
+			t.Errorf("%d: expect group '%s', but got '%s'", i, step.Group, s)
+		}
+
+		// Only the group changes with the backends.
+		if flows == nil {
+			flows = stepFlows
+		} else if !reflect.DeepEqual(flows, stepFlows) {
+			t.Errorf("%d: expect the unchanged flows %v, but got %v", i, flows, stepFlows)
+		}
+	}
+
+	// RemoveBackend does not modify the backends shared with the old value.
+	lb.Backends = []Backend{{IP: b1, Port: 8080}, {IP: b2, Port: 8080}}
+	old := lb
+	lb.RemoveBackend(b1, 8080)
+	if len(old.Backends) != 2 || old.Backends[0].IP != b1 || old.Backends[1].IP != b2 {
+		t.Errorf("unexpected old backends %v", old.Backends)
+	}
+}
+
+func TestBaseFlows(t *testing.T) {
+	c := NewCompiler()
+	c.Zone = 100
+
+	expects := []string{
+		"cookie=0x4c42000000000000,table=50,priority=10,tcp,actions=ct(zone=100,table=51,nat)",
+		"cookie=0x4c42000000000000,table=50,priority=10,udp,actions=ct(zone=100,table=51,nat)",
+		"cookie=0x4c42000000000000,table=50,priority=10,tcp6,actions=ct(zone=100,table=51,nat)",
+		"cookie=0x4c42000000000000,table=50,priority=10,udp6,actions=ct(zone=100,table=51,nat)",
+		"cookie=0x4c42000000000000,table=50,priority=0,actions=goto_table:60",
+		"cookie=0x4c42000000000000,table=51,priority=0,actions=goto_table:60",
+	}
+
+	flows := c.BaseFlows()
+	if len(flows) != len(expects) {
+		t.Fatalf("expect %d flows, but got %d: %v", len(expects), len(flows), flows)
+	}
+	for i, flow := range flows {
+		if flow != expects[i] {
+			t.Errorf("expect flow '%s', but got '%s'", expects[i], flow)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nat compiles the IPv4 floating IPs and SNAT pools of the external
+// gateways into the OpenFlow pipeline based on ct(nat).
+//
+// The flows of a gateway are spread over four tables:
+//
+//	Ingress:    in_port=EXTERNAL,ARP for the external IPs -> reply to IN_PORT
+//	            in_port=EXTERNAL,nw_dst=FIP  -> ct(commit,zone,nat(dst=INTERNAL)) -> IngressNAT
+//	            in_port=EXTERNAL,nw_dst=POOL -> ct(zone,nat) -> IngressNAT
+//	IngressNAT: ct_zone=ZONE,new with dnat, established or related -> IngressNext
+//	            ct_zone=ZONE,others          -> drop
+//	Egress:     MATCH,nw_src=INTERNAL        -> ct(commit,zone,nat(src=FIP)) -> EgressNAT
+//	            MATCH,nw_src=POOL CIDR       -> ct(commit,zone,nat(src=IPs:PORTs)) -> EgressNAT
+//	EgressNAT:  ct_zone=ZONE,not invalid     -> dl_src=EXTERNAL MAC,dl_dst=GATEWAY MAC -> EXTERNAL
+//	            ct_zone=ZONE,others          -> drop
+//
+// The packets from the external network should be sent to the Ingress table,
+// and those from the internal networks to the external network should be
+// sent to the Egress table, such as by the default route of the router.
+//
+// Each gateway owns the conntrack zone and the cookie of its flows, so adding
+// a floating IP to one gateway replaces only the flows of that gateway.
+// The Egress table is shared by all the gateways, so the gateways whose
+// internal networks may overlap must be told apart by Gateway.Match, such as
+// the metadata or register set by the router for the packets of each gateway.
+package nat
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/xgfone/go-ovs"
+)
+
+// Tables is the OpenFlow tables used by the NAT pipeline.
+type Tables struct {
+	Ingress    uint8
+	IngressNAT uint8
+	Egress     uint8
+	EgressNAT  uint8
+
+	// IngressNext is the table to which the translated packets from
+	// the external network go, such as the routing table of the router.
+	IngressNext uint8
+}
+
+// DefaultTables is the default tables of the NAT pipeline.
+var DefaultTables = Tables{
+	Ingress:     40,
+	IngressNAT:  41,
+	Egress:      42,
+	EgressNAT:   43,
+	IngressNext: 30,
+}
+
+// DefaultCookieBase is the default base of the cookies of the flows.
+const DefaultCookieBase uint64 = 0x4e41 << 48
+
+// FloatingIP is the one-to-one NAT between the internal IP
+// and the external IP.
+type FloatingIP struct {
+	InternalIP netip.Addr
+	ExternalIP netip.Addr
+}
+
+// SNATPool is the many-to-one source NAT of the internal CIDR,
+// which translates the source to an address in [ExternalIPMin, ExternalIPMax]
+// and a port in [PortMin, PortMax].
+type SNATPool struct {
+	Internal      netip.Prefix
+	ExternalIPMin netip.Addr
+	ExternalIPMax netip.Addr // Optional
+	PortMin       uint16     // Optional
+	PortMax       uint16     // Optional
+}
+
+func (p SNATPool) externalIPMax() netip.Addr {
+	if p.ExternalIPMax.IsValid() {
+		return p.ExternalIPMax
+	}
+	return p.ExternalIPMin
+}
+
+// Gateway is an external gateway, which is attached to the external
+// network by ExternalPort.
+type Gateway struct {
+	ID uint32 // Must not be 0
+
+	// Zone is the conntrack zone of the NAT connections, which must not
+	// be 0 and must not be shared with the other users of conntrack,
+	// such as the load balancers or the security groups.
+	Zone uint16
+
+	// Match is the extra match of the packets from the internal networks
+	// to the gateway, such as "metadata=0x1" or "reg6=1", which scopes
+	// the Egress flows of the gateway. It is optional only if no other
+	// gateway uses the same internal addresses.
+	Match string
+
+	ExternalPort string // The OpenFlow port name or number, such as "1" or "patch-ex"
+	ExternalMAC  string // Optional, the MAC of the external IPs to answer ARP.
+	GatewayMAC   string // The MAC of the next hop in the external network
+
+	FloatingIPs []FloatingIP
+	SNATPools   []SNATPool
+}
+
+// Compiler is used to compile the gateways into the flows.
+type Compiler struct {
+	Tables Tables
+
+	// CookieBase is the upper 32 bits of the cookies of the NAT flows,
+	// the lower 32 bits of which must be 0 and are filled with the gateway id.
+	// The default drops of the four tables use CookieBase itself.
+	CookieBase uint64
+}
+
+// NewCompiler returns a new compiler with the default tables and cookie base.
+func NewCompiler() *Compiler {
+	return &Compiler{Tables: DefaultTables, CookieBase: DefaultCookieBase}
+}
+
+// GatewayCookie returns the cookie of the flows of the gateway.
+func (c *Compiler) GatewayCookie(gw Gateway) uint64 {
+	return c.CookieBase | uint64(gw.ID)
+}
+
+// BaseFlows returns the drops of the four NAT tables, so the packets
+// which neither hit a floating IP nor a SNAT pool never leak out
+// of the external port without being translated.
+func (c *Compiler) BaseFlows() []string {
+	t := c.Tables
+	return []string{
+		ovs.Flow{Cookie: c.CookieBase, Table: t.Ingress, Actions: ovs.DROP}.String(),
+		ovs.Flow{Cookie: c.CookieBase, Table: t.IngressNAT, Actions: ovs.DROP}.String(),
+		ovs.Flow{Cookie: c.CookieBase, Table: t.Egress, Actions: ovs.DROP}.String(),
+		ovs.Flow{Cookie: c.CookieBase, Table: t.EgressNAT, Actions: ovs.DROP}.String(),
+	}
+}
+
+// Compile compiles the floating IPs, the SNAT pools and the ARP responder
+// of the gateway into the flows with the cookie of the gateway, which rely
+// on the drops of BaseFlows for the packets of no gateway.
+func (c *Compiler) Compile(gw Gateway) (flows []string, err error) {
+	if uint32(c.CookieBase) != 0 {
+		return nil, fmt.Errorf("the lower 32 bits of the cookie base 0x%x must be 0", c.CookieBase)
+	} else if err = gw.validate(); err != nil {
+		return nil, fmt.Errorf("gateway %d: %v", gw.ID, err)
+	}
+
+	t := c.Tables
+	cookie := c.GatewayCookie(gw)
+	inPort := "in_port=" + gw.ExternalPort
+	ctZone := ovs.CTZone(gw.Zone)
+	add := func(table uint8, priority int, match, actions string) {
+		flows = append(flows, ovs.Flow{Cookie: cookie, Table: table, Priority: priority,
+			Match: match, Actions: actions}.String())
+	}
+
+	// The external IPs to be answered by ARP and un-NATed.
+	var externals []string
+
+	// Floating IP
+	for _, fip := range gw.FloatingIPs {
+		externals = append(externals, "nw_dst="+fip.ExternalIP.String())
+
+		dnat := ovs.CT().Commit().Zone(gw.Zone).Table(t.IngressNAT).
+			NAT(ovs.CTNAT{Dst: true, IPMin: fip.InternalIP})
+		add(t.Ingress, 100, fmt.Sprintf("%s,ip,nw_dst=%s", inPort, fip.ExternalIP), dnat.String())
+
+		snat := ovs.CT().Commit().Zone(gw.Zone).Table(t.EgressNAT).
+			NAT(ovs.CTNAT{Src: true, IPMin: fip.ExternalIP})
+		add(t.Egress, 100, ovs.JoinMatches(gw.Match, "ip", "nw_src="+fip.InternalIP.String()), snat.String())
+	}
+
+	// SNAT Pool
+	for _, pool := range gw.SNATPools {
+		matches, err := ovs.IPRangeMatches("nw_dst", pool.ExternalIPMin, pool.externalIPMax())
+		if err != nil {
+			return nil, fmt.Errorf("gateway %d: %v", gw.ID, err)
+		}
+		externals = append(externals, matches...)
+
+		// Only un-NAT the replies, and the new connections are dropped
+		// in the IngressNAT table.
+		unnat := ovs.CT().Zone(gw.Zone).Table(t.IngressNAT).NAT(ovs.CTNAT{}).String()
+		for _, match := range matches {
+			add(t.Ingress, 90, fmt.Sprintf("%s,ip,%s", inPort, match), unnat)
+		}
+
+		prefix := pool.Internal.Masked()
+		snat := ovs.CT().Commit().Zone(gw.Zone).Table(t.EgressNAT).NAT(ovs.CTNAT{
+			Src:     true,
+			IPMin:   pool.ExternalIPMin,
+			IPMax:   pool.ExternalIPMax,
+			PortMin: pool.PortMin,
+			PortMax: pool.PortMax,
+		})
+		add(t.Egress, 50+prefix.Bits(), ovs.JoinMatches(gw.Match, "ip", "nw_src="+prefix.String()), snat.String())
+	}
+
+	// ARP Responder for the external IPs, which swaps the sender and target.
+	if gw.ExternalMAC != "" {
+		actions := strings.Join([]string{
+			"move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]",
+			ovs.SetFieldAction(gw.ExternalMAC, "eth_src"),
+			ovs.LoadAction(2, "NXM_OF_ARP_OP[]"),
+			"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]",
+			ovs.SetFieldAction(gw.ExternalMAC, "arp_sha"),
+			"move:NXM_OF_ARP_TPA[]->NXM_NX_REG0[]",
+			"move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[]",
+			"move:NXM_NX_REG0[]->NXM_OF_ARP_SPA[]",
+			"in_port",
+		}, ",")
+
+		for _, external := range externals {
+			match := strings.Replace(external, "nw_dst=", "arp_tpa=", 1)
+			add(t.Ingress, 110, fmt.Sprintf("%s,arp,arp_op=1,%s", inPort, match), actions)
+		}
+	}
+
+	// Ingress NAT
+	next := fmt.Sprintf("goto_table:%d", t.IngressNext)
+	add(t.IngressNAT, 100, ovs.JoinMatches(ctZone, ovs.CTState(ovs.CTStateTrk|ovs.CTStateNew|ovs.CTStateDNAT, ovs.CTStateInv), "ip"), next)
+	add(t.IngressNAT, 100, ovs.JoinMatches(ctZone, ovs.CTState(ovs.CTStateTrk|ovs.CTStateEst, ovs.CTStateInv), "ip"), next)
+	add(t.IngressNAT, 100, ovs.JoinMatches(ctZone, ovs.CTState(ovs.CTStateTrk|ovs.CTStateRel, ovs.CTStateInv), "ip"), next)
+	add(t.IngressNAT, 10, ctZone, ovs.DROP)
+
+	// Egress NAT
+	output := fmt.Sprintf("%s,output:%s", ovs.SetFieldAction(gw.GatewayMAC, "eth_dst"), gw.ExternalPort)
+	if gw.ExternalMAC != "" {
+		output = ovs.SetFieldAction(gw.ExternalMAC, "eth_src") + "," + output
+	}
+	add(t.EgressNAT, 100, ovs.JoinMatches(ctZone, ovs.CTState(ovs.CTStateTrk, ovs.CTStateInv), "ip"), output)
+	add(t.EgressNAT, 10, ctZone, ovs.DROP)
+
+	return
+}
+
+func (g *Gateway) validate() (err error) {
+	if g.ID == 0 {
+		return fmt.Errorf("the gateway id must not be 0")
+	} else if g.Zone == 0 {
+		return fmt.Errorf("the conntrack zone must not be 0")
+	} else if g.ExternalPort == "" {
+		return fmt.Errorf("missing the external port")
+	}
+
+	if g.GatewayMAC, err = ovs.ParseMAC(g.GatewayMAC); err != nil {
+		return
+	}
+	if g.ExternalMAC != "" {
+		if g.ExternalMAC, err = ovs.ParseMAC(g.ExternalMAC); err != nil {
+			return
+		}
+	}
+
+	for _, fip := range g.FloatingIPs {
+		if !fip.InternalIP.Is4() || !fip.ExternalIP.Is4() {
+			return fmt.Errorf("invalid floating ip %s -> %s", fip.ExternalIP, fip.InternalIP)
+		}
+	}
+
+	for _, pool := range g.SNATPools {
+		switch {
+		case !pool.Internal.Addr().Is4():
+			return fmt.Errorf("invalid snat pool cidr '%s'", pool.Internal)
+		case !pool.ExternalIPMin.Is4() || !pool.externalIPMax().Is4() ||
+			pool.externalIPMax().Less(pool.ExternalIPMin):
+			return fmt.Errorf("invalid snat pool ip range [%s, %s]", pool.ExternalIPMin, pool.ExternalIPMax)
+		case pool.PortMax > 0 && pool.PortMax < pool.PortMin:
+			return fmt.Errorf("invalid snat pool port range [%d, %d]", pool.PortMin, pool.PortMax)
+		}
+	}
+
+	return
+}
+
+// InstallBase installs the drops of BaseFlows on the bridge, which should
+// be done before updating any gateway.
+func (c *Compiler) InstallBase(bridge string) error {
+	return ovs.ReplaceFlowsByCookie(bridge, c.CookieBase, c.BaseFlows()...)
+}
+
+// UpdateGateway compiles the gateway and replaces the flows with its cookie
+// in one OpenFlow bundle, such as after adding or removing a floating IP.
+//
+// Only the flows are replaced, and the conntrack entries in the zone
+// of the gateway are kept. So an established connection of a removed
+// floating IP keeps its old translation if its packets still hit
+// a SNAT pool of the gateway, until it is flushed by ovs.FlushConntrackTuple
+// or ovs.FlushConntrack with the zone.
+func (c *Compiler) UpdateGateway(bridge string, gw Gateway) error {
+	flows, err := c.Compile(gw)
+	if err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.GatewayCookie(gw), flows...)
+}
+
+// RemoveGateway removes all the flows of the gateway from the bridge.
+func (c *Compiler) RemoveGateway(bridge string, gw Gateway) error {
+	return ovs.DelFlowsByCookie(bridge, c.GatewayCookie(gw))
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/xgfone/go-ovs/flowsim"
+)
+
+// The internal network is behind the port 10, and the external network
+// is behind the port 1.
+var pipelineFlows = []string{
+	"table=0,priority=10,in_port=1,actions=goto_table:40",
+	"table=0,priority=0,actions=goto_table:42",
+	"table=30,priority=0,actions=output:10",
+}
+
+func TestCompile(t *testing.T) {
+	c := NewCompiler()
+	flows, err := c.Compile(Gateway{
+		ID:           1,
+		Zone:         10,
+		ExternalPort: "1",
+		ExternalMAC:  "fa:16:3e:00:00:01",
+		GatewayMAC:   "00:00:00:00:00:fe",
+		FloatingIPs: []FloatingIP{
+			{InternalIP: netip.MustParseAddr("10.0.0.5"), ExternalIP: netip.MustParseAddr("172.16.0.5")},
+		},
+		SNATPools: []SNATPool{{
+			Internal:      netip.MustParsePrefix("10.0.0.0/24"),
+			ExternalIPMin: netip.MustParseAddr("172.16.0.10"),
+			ExternalIPMax: netip.MustParseAddr("172.16.0.11"),
+			PortMin:       10000,
+			PortMax:       20000,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := flowsim.NewEvaluator()
+	if err = e.AddFlows(append(append(c.BaseFlows(), pipelineFlows...), flows...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	trace := func(packet string) flowsim.Result {
+		result, err := e.Trace(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// flowsim does not translate the addresses, so only the path
+	// of the packets is checked, and the conntrack state of the replies
+	// and the DNATed connections is given by the packets.
+	for _, tc := range []struct {
+		Name   string
+		Packet string
+		Port   int // 0 means dropped
+		Action string
+	}{
+		{"FIP egress", "in_port=10,tcp,nw_src=10.0.0.5,nw_dst=8.8.8.8,tp_dst=80", 1,
+			"ct(commit,zone=10,table=43,nat(src=172.16.0.5))"},
+		{"FIP reply", "in_port=1,ct_state=+trk+est,tcp,nw_src=8.8.8.8,nw_dst=172.16.0.5,tp_src=80", 10,
+			"ct(commit,zone=10,table=41,nat(dst=10.0.0.5))"},
+		{"FIP ingress", "in_port=1,ct_state=+trk+new+dnat,tcp,nw_src=8.8.8.8,nw_dst=172.16.0.5,tp_dst=22", 10,
+			"ct(commit,zone=10,table=41,nat(dst=10.0.0.5))"},
+		{"SNAT egress", "in_port=10,tcp,nw_src=10.0.0.6,nw_dst=8.8.8.8,tp_dst=80", 1,
+			"ct(commit,zone=10,table=43,nat(src=172.16.0.10-172.16.0.11:10000-20000))"},
+		{"SNAT reply", "in_port=1,ct_state=+trk+est,tcp,nw_src=8.8.8.8,nw_dst=172.16.0.11,tp_src=80", 10,
+			"ct(zone=10,table=41,nat)"},
+		{"SNAT ingress", "in_port=1,tcp,nw_src=8.8.8.8,nw_dst=172.16.0.11,tp_dst=22", 0,
+			"ct(zone=10,table=41,nat)"},
+		{"no gateway", "in_port=10,tcp,nw_src=10.0.1.5,nw_dst=8.8.8.8,tp_dst=80", 0, ""},
+	} {
+		result := trace(tc.Packet)
+		switch {
+		case tc.Port == 0 && !result.Dropped():
+			t.Errorf("%s: expect the packet to be dropped, but got %+v", tc.Name, result.Outputs)
+		case tc.Port != 0 && (len(result.Outputs) != 1 || result.Outputs[0].Port != tc.Port):
+			t.Errorf("%s: expect the output port %d, but got %+v", tc.Name, tc.Port, result.Outputs)
+		case tc.Action != "" && !hasAction(result, tc.Action):
+			t.Errorf("%s: missing the action '%s' in %v", tc.Name, tc.Action, result.Actions)
+		}
+	}
+
+	// The egress packets leave with the external MAC to the gateway MAC.
+	p := trace("in_port=10,tcp,nw_src=10.0.0.5,nw_dst=8.8.8.8,tp_dst=80").Outputs[0].Packet
+	if src, dst := p.Get("dl_src").String(), p.Get("dl_dst").String(); src != "0xfa163e000001" || dst != "0xfe" {
+		t.Errorf("unexpected dl_src '%s' and dl_dst '%s'", src, dst)
+	}
+
+	// ARP for the external IP in the SNAT pool
+	result := trace("in_port=1,arp,arp_op=1,dl_src=00:00:00:00:00:fe," +
+		"arp_sha=00:00:00:00:00:fe,arp_spa=172.16.0.1,arp_tpa=172.16.0.11")
+	if len(result.Outputs) != 1 || result.Outputs[0].Port != 1 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	}
+
+	p = result.Outputs[0].Packet
+	for field, expect := range map[string]string{
+		"arp_op":  "0x2",
+		"arp_spa": "0xac10000b",
+		"arp_tpa": "0xac100001",
+		"arp_sha": "0xfa163e000001",
+		"arp_tha": "0xfe",
+	} {
+		if v := p.Get(field).String(); v != expect {
+			t.Errorf("expect %s '%s', but got '%s'", field, expect, v)
+		}
+	}
+}
+
+func hasAction(result flowsim.Result, action string) bool {
+	for _, a := range result.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompileError(t *testing.T) {
+	pool := SNATPool{
+		Internal:      netip.MustParsePrefix("10.0.0.0/24"),
+		ExternalIPMin: netip.MustParseAddr("172.16.0.10"),
+	}
+
+	for name, gw := range map[string]Gateway{
+		"the gateway id 0": {Zone: 10, ExternalPort: "1", GatewayMAC: "00:00:00:00:00:fe"},
+		"the zone 0":       {ID: 1, ExternalPort: "1", GatewayMAC: "00:00:00:00:00:fe"},
+		"no external port": {ID: 1, Zone: 10, GatewayMAC: "00:00:00:00:00:fe"},
+		"no gateway mac":   {ID: 1, Zone: 10, ExternalPort: "1"},
+		"the IPv6 floating ip": {ID: 1, Zone: 10, ExternalPort: "1", GatewayMAC: "00:00:00:00:00:fe",
+			FloatingIPs: []FloatingIP{{InternalIP: netip.MustParseAddr("fd00::5"),
+				ExternalIP: netip.MustParseAddr("172.16.0.5")}}},
+		"the reversed ip range": {ID: 1, Zone: 10, ExternalPort: "1", GatewayMAC: "00:00:00:00:00:fe",
+			SNATPools: []SNATPool{{Internal: pool.Internal, ExternalIPMin: pool.ExternalIPMin,
+				ExternalIPMax: netip.MustParseAddr("172.16.0.9")}}},
+		"the reversed port range": {ID: 1, Zone: 10, ExternalPort: "1", GatewayMAC: "00:00:00:00:00:fe",
+			SNATPools: []SNATPool{{Internal: pool.Internal, ExternalIPMin: pool.ExternalIPMin,
+				PortMin: 200, PortMax: 100}}},
+	} {
+		if _, err := NewCompiler().Compile(gw); err == nil {
+			t.Errorf("expect an error for %s", name)
+		}
+	}
+}
+
+func TestCompileGatewaysWithOverlappedInternal(t *testing.T) {
+	gw1 := Gateway{
+		ID:           1,
+		Zone:         10,
+		Match:        "metadata=0x1",
+		ExternalPort: "1",
+		GatewayMAC:   "00:00:00:00:00:fe",
+		SNATPools: []SNATPool{{
+			Internal:      netip.MustParsePrefix("10.0.0.0/24"),
+			ExternalIPMin: netip.MustParseAddr("172.16.0.10"),
+		}},
+	}
+	gw2 := Gateway{
+		ID:           2,
+		Zone:         20,
+		Match:        "metadata=0x2",
+		ExternalPort: "2",
+		GatewayMAC:   "00:00:00:00:01:fe",
+		SNATPools: []SNATPool{{
+			Internal:      netip.MustParsePrefix("10.0.0.0/24"),
+			ExternalIPMin: netip.MustParseAddr("172.17.0.10"),
+		}},
+	}
+
+	c := NewCompiler()
+	e := flowsim.NewEvaluator()
+	if err := e.AddFlows(append(c.BaseFlows(), pipelineFlows...)...); err != nil {
+		t.Fatal(err)
+	}
+	for _, gw := range []Gateway{gw1, gw2} {
+		flows, err := c.Compile(gw)
+		if err != nil {
+			t.Fatal(err)
+		} else if err = e.AddFlows(flows...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for metadata, port := range map[string]int{"0x1": 1, "0x2": 2} {
+		result, err := e.Trace("in_port=10,metadata=" + metadata + ",tcp,nw_src=10.0.0.5,nw_dst=8.8.8.8,tp_dst=53")
+		if err != nil {
+			t.Fatal(err)
+		} else if len(result.Outputs) != 1 || result.Outputs[0].Port != port {
+			t.Errorf("metadata=%s: expect the output port %d, but got %+v", metadata, port, result.Outputs)
+		}
+	}
+
+	if result, err := e.Trace("in_port=10,tcp,nw_src=10.0.0.5,nw_dst=8.8.8.8,tp_dst=53"); err != nil {
+		t.Fatal(err)
+	} else if !result.Dropped() {
+		t.Errorf("expect the packet of no gateway to be dropped, but got %+v", result.Outputs)
+	}
+}
@@ -62,18 +62,127 @@ func SetInterfaceUp(iface string) (err error) {
 	return exec.Execute(context.Background(), IPCmd, "link", "set", iface, "up")
 }
 
+// Fail modes of the bridge.
+const (
+	FailModeSecure     = "secure"
+	FailModeStandalone = "standalone"
+)
+
+// Datapath types of the bridge.
+const (
+	DatapathTypeSystem = "system"
+	DatapathTypeNetdev = "netdev"
+)
+
+// BridgeOptions is the options of the bridge.
+//
+// For UpdateBridge, the zero value of the field means not to change it.
+type BridgeOptions struct {
+	FailMode     string // FailModeSecure or FailModeStandalone
+	DatapathType string // DatapathTypeSystem or DatapathTypeNetdev
+
+	STPEnable           *bool
+	RSTPEnable          *bool
+	McastSnoopingEnable *bool
+
+	DatapathID            string // 16 hex digits, such as "0000000000000001"
+	HWAddr                string // Such as "00:11:22:33:44:55"
+	FlowEvictionThreshold int
+
+	Protocols []string // Such as "OpenFlow10", "OpenFlow13"
+}
+
+func (o BridgeOptions) args(bridge string) (args []string, err error) {
+	switch o.FailMode {
+	case "", FailModeSecure, FailModeStandalone:
+	default:
+		return nil, fmt.Errorf("invalid bridge fail mode '%s'", o.FailMode)
+	}
+
+	switch o.DatapathType {
+	case "", DatapathTypeSystem, DatapathTypeNetdev:
+	default:
+		return nil, fmt.Errorf("invalid bridge datapath type '%s'", o.DatapathType)
+	}
+
+	if o.DatapathID != "" {
+		if _, err = strconv.ParseUint(o.DatapathID, 16, 64); err != nil || len(o.DatapathID) != 16 {
+			return nil, fmt.Errorf("invalid bridge datapath id '%s'", o.DatapathID)
+		}
+	}
+
+	hwaddr := o.HWAddr
+	if hwaddr != "" {
+		if hwaddr = normalizeMac(hwaddr); hwaddr == "" {
+			return nil, fmt.Errorf("invalid bridge hwaddr '%s'", o.HWAddr)
+		}
+	}
+
+	for _, protocol := range o.Protocols {
+		switch protocol {
+		case "OpenFlow10", "OpenFlow11", "OpenFlow12", "OpenFlow13", "OpenFlow14", "OpenFlow15":
+		default:
+			return nil, fmt.Errorf("invalid bridge protocol '%s'", protocol)
+		}
+	}
+
+	if o.FailMode != "" {
+		args = append(args, "fail_mode="+o.FailMode)
+	}
+	if o.DatapathType != "" {
+		args = append(args, "datapath_type="+o.DatapathType)
+	}
+	if o.STPEnable != nil {
+		args = append(args, fmt.Sprintf("stp_enable=%t", *o.STPEnable))
+	}
+	if o.RSTPEnable != nil {
+		args = append(args, fmt.Sprintf("rstp_enable=%t", *o.RSTPEnable))
+	}
+	if o.McastSnoopingEnable != nil {
+		args = append(args, fmt.Sprintf("mcast_snooping_enable=%t", *o.McastSnoopingEnable))
+	}
+	if o.DatapathID != "" {
+		args = append(args, fmt.Sprintf("other_config:datapath-id=%s", o.DatapathID))
+	}
+	if hwaddr != "" {
+		args = append(args, fmt.Sprintf("other_config:hwaddr=%q", hwaddr))
+	}
+	if o.FlowEvictionThreshold > 0 {
+		args = append(args, fmt.Sprintf("other_config:flow-eviction-threshold=%d", o.FlowEvictionThreshold))
+	}
+	if len(o.Protocols) > 0 {
+		args = append(args, "protocols="+dbSet(o.Protocols))
+	}
+
+	if len(args) > 0 {
+		args = append([]string{"--", "set", "bridge", bridge}, args...)
+	}
+	return
+}
+
 // CreateBridge creates a new bridge named name if not exist.
 //
 // If secureFailMode is true, set the fail mode of the bridge to "secure".
 func CreateBridge(name string, secureFailMode ...bool) (err error) {
+	var opts BridgeOptions
 	if len(secureFailMode) > 0 && secureFailMode[0] {
-		err = exec.Execute(context.Background(), VsctlCmd,
-			"--may-exist", "add-br", name,
-			"--", "set-fail-mode", name, "secure")
-	} else {
-		err = exec.Execute(context.Background(), VsctlCmd, "--may-exist", "add-br", name)
+		opts.FailMode = FailModeSecure
 	}
+	return CreateBridgeWithOptions(name, opts)
+}
 
+// CreateBridgeWithOptions creates a new bridge named name with the options
+// if not exist, and sets the bridge up.
+//
+// If the bridge has existed, the options will be set.
+func CreateBridgeWithOptions(name string, opts BridgeOptions) (err error) {
+	args, err := opts.args(name)
+	if err != nil {
+		return
+	}
+
+	args = append([]string{"--may-exist", "add-br", name}, args...)
+	err = exec.Execute(context.Background(), VsctlCmd, args...)
 	if err == nil {
 		err = exec.Execute(context.Background(), IPCmd, "link", "set", name, "up")
 	}
@@ -81,6 +190,66 @@ func CreateBridge(name string, secureFailMode ...bool) (err error) {
 	return
 }
 
+// UpdateBridge updates the options of the bridge named name.
+func UpdateBridge(name string, opts BridgeOptions) (err error) {
+	args, err := opts.args(name)
+	if err != nil || len(args) == 0 {
+		return
+	}
+	return exec.Execute(context.Background(), VsctlCmd, args...)
+}
+
+// GetBridge returns the effective options of the bridge named name.
+func GetBridge(name string) (opts BridgeOptions, err error) {
+	bridges, err := listDBRecords("Bridge", []string{"fail_mode", "datapath_type",
+		"datapath_id", "stp_enable", "rstp_enable", "mcast_snooping_enable",
+		"other_config", "protocols"}, name)
+	if err != nil {
+		return
+	} else if len(bridges) == 0 {
+		return opts, fmt.Errorf("no bridge named '%s'", name)
+	}
+
+	r := bridges[0]
+	stp := dbBool(r["stp_enable"])
+	rstp := dbBool(r["rstp_enable"])
+	mcast := dbBool(r["mcast_snooping_enable"])
+	config := dbMap(r["other_config"])
+	opts = BridgeOptions{
+		FailMode:            dbString(r["fail_mode"]),
+		DatapathType:        dbString(r["datapath_type"]),
+		DatapathID:          dbString(r["datapath_id"]),
+		STPEnable:           &stp,
+		RSTPEnable:          &rstp,
+		McastSnoopingEnable: &mcast,
+		HWAddr:              config["hwaddr"],
+		Protocols:           dbStrings(r["protocols"]),
+	}
+
+	if opts.FailMode == "" {
+		opts.FailMode = FailModeStandalone
+	}
+	if opts.DatapathType == "" {
+		opts.DatapathType = DatapathTypeSystem
+	}
+	if opts.DatapathID == "" {
+		opts.DatapathID = config["datapath-id"]
+	}
+	if v := config["flow-eviction-threshold"]; v != "" {
+		opts.FlowEvictionThreshold, _ = strconv.Atoi(v)
+	}
+
+	// The effective hwaddr is the mac address of the bridge local interface.
+	ifaces, err := listDBRecords("Interface", []string{"mac_in_use"}, name)
+	if err == nil && len(ifaces) > 0 {
+		if mac := dbString(ifaces[0]["mac_in_use"]); mac != "" {
+			opts.HWAddr = mac
+		}
+	}
+
+	return
+}
+
 // DeleteBridge deletes the bridge named name.
 func DeleteBridge(name string) (err error) {
 	return exec.Execute(context.Background(), VsctlCmd, "--if-exists", "del-br", name)
@@ -153,6 +322,23 @@ func MustCreateBridge(name string, secureFailMode ...bool) {
 	}
 }
 
+// MustCreateBridgeWithOptions is the same as CreateBridgeWithOptions,
+// but exit the program if failing.
+func MustCreateBridgeWithOptions(name string, opts BridgeOptions) {
+	if err := CreateBridgeWithOptions(name, opts); err != nil {
+		log.Printf("failed to create bridge: bridge=%s, err=%v", name, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustUpdateBridge is the same as UpdateBridge, but exit the program if failing.
+func MustUpdateBridge(name string, opts BridgeOptions) {
+	if err := UpdateBridge(name, opts); err != nil {
+		log.Printf("failed to update bridge: bridge=%s, err=%v", name, err)
+		atexit.Exit(1)
+	}
+}
+
 // MustDeleteBridge is the same as DeleteBridge, but exit the program if failing.
 func MustDeleteBridge(name string) {
 	if err := DeleteBridge(name); err != nil {
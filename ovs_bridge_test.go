@@ -0,0 +1,55 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBridgeOptionsArgs(t *testing.T) {
+	stp := true
+	args, err := BridgeOptions{
+		FailMode:   FailModeSecure,
+		STPEnable:  &stp,
+		DatapathID: "0000000000000001",
+		HWAddr:     "0:11:22:33:44:5",
+		Protocols:  []string{"OpenFlow10", "OpenFlow13"},
+	}.args("br0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `-- set bridge br0 fail_mode=secure stp_enable=true ` +
+		`other_config:datapath-id=0000000000000001 other_config:hwaddr="00:11:22:33:44:05" ` +
+		`protocols=[OpenFlow10,OpenFlow13]`
+	if s := strings.Join(args, " "); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+
+	if args, _ = (BridgeOptions{}).args("br0"); len(args) != 0 {
+		t.Errorf("expect no args, but got %v", args)
+	}
+
+	if _, err = (BridgeOptions{FailMode: "unknown"}).args("br0"); err == nil {
+		t.Errorf("expect an error for the invalid fail mode")
+	}
+	if _, err = (BridgeOptions{DatapathType: "netdve"}).args("br0"); err == nil {
+		t.Errorf("expect an error for the invalid datapath type")
+	}
+	if _, err = (BridgeOptions{DatapathID: "1"}).args("br0"); err == nil {
+		t.Errorf("expect an error for the invalid datapath id")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Strategies to generate the flows of the conjunctive match.
+const (
+	// StrategyAuto uses the strategy generating the fewer flows.
+	StrategyAuto = iota
+
+	// StrategyConjunction always uses the conjunction action
+	// if there are at least two dimensions.
+	StrategyConjunction
+
+	// StrategyCrossProduct always uses the cross product of the dimensions.
+	StrategyCrossProduct
+)
+
+// Conjunction is used to generate the flows matching a packet that
+// matches one of the alternatives in every dimension, such as
+//
+//	Dimensions: [][]string{
+//	    {"nw_src=10.0.0.0/24", "nw_src=10.0.1.0/24"},
+//	    PortRuleMasking(1000, 1999), // with the prefix "tp_dst="
+//	}
+//
+// which uses the conjunction action "conjunction(id, k/n)" to avoid
+// the cross product explosion of the flows.
+type Conjunction struct {
+	ID       uint32 // The conjunction id, which must be unique in the table.
+	Cookie   uint64
+	Table    uint8
+	Priority int
+
+	// Match is the common match of all the flows, such as "tcp".
+	Match string
+
+	// Dimensions is the list of the dimensions, each of which is a list
+	// of the alternative matches.
+	//
+	// The dimension containing an empty alternative matches any packet,
+	// so it will be ignored.
+	Dimensions [][]string
+
+	// Actions is the actions of the packet matching all the dimensions.
+	Actions string
+
+	// Strategy is one of StrategyAuto, StrategyConjunction
+	// and StrategyCrossProduct, which is StrategyAuto by default.
+	Strategy int
+}
+
+// ConjunctionResult is the result of the generated flows of the conjunction.
+type ConjunctionResult struct {
+	Flows []string
+
+	// Conjunctive reports whether Flows uses the conjunction action.
+	Conjunctive bool
+
+	// ConjunctionFlows and CrossProductFlows are the flow counts
+	// of the two strategies.
+	ConjunctionFlows  int
+	CrossProductFlows int
+}
+
+// Flows generates the flows of the conjunctive match.
+func (c Conjunction) Flows() (result ConjunctionResult, err error) {
+	if c.Actions == "" {
+		return result, errors.New("the conjunction actions must not be empty")
+	} else if c.Strategy < StrategyAuto || c.Strategy > StrategyCrossProduct {
+		return result, fmt.Errorf("invalid conjunction strategy %d", c.Strategy)
+	}
+
+	// Fold the dimensions matching any packet or only one alternative.
+	match := c.Match
+	dims := make([][]string, 0, len(c.Dimensions))
+	for i, dim := range c.Dimensions {
+		switch {
+		case len(dim) == 0:
+			return result, fmt.Errorf("the conjunction dimension %d has no alternatives", i)
+		case containsString(dim, ""):
+		case len(dim) == 1:
+			match = joinMatch(match, dim[0])
+		default:
+			dims = append(dims, dedupStrings(dim))
+		}
+	}
+
+	result.CrossProductFlows = 1
+	if len(dims) > 1 {
+		result.ConjunctionFlows = 1
+	}
+	for _, dim := range dims {
+		result.CrossProductFlows *= len(dim)
+		if len(dims) > 1 {
+			result.ConjunctionFlows += len(dim)
+		}
+	}
+
+	switch {
+	case len(dims) < 2:
+	case c.Strategy == StrategyConjunction:
+		result.Conjunctive = true
+	case c.Strategy == StrategyAuto:
+		result.Conjunctive = result.ConjunctionFlows < result.CrossProductFlows
+	}
+
+	flow := Flow{Cookie: c.Cookie, Table: c.Table, Priority: c.Priority}
+	if result.Conjunctive {
+		result.Flows = make([]string, 0, result.ConjunctionFlows)
+		for k, dim := range dims {
+			flow.Actions = fmt.Sprintf("conjunction(%d,%d/%d)", c.ID, k+1, len(dims))
+			for _, alt := range dim {
+				flow.Match = joinMatch(match, alt)
+				result.Flows = append(result.Flows, flow.String())
+			}
+		}
+
+		flow.Match = joinMatch(match, fmt.Sprintf("conj_id=%d", c.ID))
+		flow.Actions = c.Actions
+		result.Flows = append(result.Flows, flow.String())
+		return
+	}
+
+	flow.Actions = c.Actions
+	result.Flows = make([]string, 0, result.CrossProductFlows)
+	crossProduct(dims, match, func(match string) {
+		flow.Match = match
+		result.Flows = append(result.Flows, flow.String())
+	})
+
+	return
+}
+
+func crossProduct(dims [][]string, match string, emit func(string)) {
+	if len(dims) == 0 {
+		emit(match)
+		return
+	}
+
+	for _, alt := range dims[0] {
+		crossProduct(dims[1:], joinMatch(match, alt), emit)
+	}
+}
+
+func joinMatch(match1, match2 string) string {
+	switch {
+	case match1 == "":
+		return match2
+	case match2 == "":
+		return match1
+	default:
+		return strings.Join([]string{match1, match2}, ",")
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, _s := range ss {
+		if _s == s {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupStrings(ss []string) []string {
+	seen := make(map[string]struct{}, len(ss))
+	results := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			results = append(results, s)
+		}
+	}
+	return results
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import "fmt"
+
+func ExampleConjunction() {
+	ports := PortRuleMasking(1000, 1999)
+	for i, port := range ports {
+		ports[i] = "tp_dst=" + port
+	}
+
+	result, err := Conjunction{
+		ID:       10,
+		Table:    1,
+		Priority: 100,
+		Match:    "tcp",
+		Dimensions: [][]string{
+			{"nw_src=10.0.0.0/24", "nw_src=10.0.1.0/24", "nw_src=10.0.2.0/24"},
+			ports,
+			{"nw_dst=192.168.0.1"},
+		},
+		Actions: "output:1",
+	}.Flows()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(result.Conjunctive, result.ConjunctionFlows, result.CrossProductFlows)
+	fmt.Println(result.Flows[0])
+	fmt.Println(result.Flows[3])
+	fmt.Println(result.Flows[len(result.Flows)-1])
+
+	result, _ = Conjunction{
+		Table:    1,
+		Priority: 100,
+		Match:    "tcp",
+		Dimensions: [][]string{
+			{"nw_src=10.0.0.0/24", "nw_src=10.0.1.0/24"},
+			{"tp_dst=80", "tp_dst=443"},
+		},
+		Actions: "output:1",
+	}.Flows()
+	fmt.Println(result.Conjunctive, result.ConjunctionFlows, result.CrossProductFlows)
+	for _, flow := range result.Flows {
+		fmt.Println(flow)
+	}
+
+	// Output:
+	// true 11 21
+	// table=1,priority=100,tcp,nw_dst=192.168.0.1,nw_src=10.0.0.0/24,actions=conjunction(10,1/2)
+	// table=1,priority=100,tcp,nw_dst=192.168.0.1,tp_dst=0x03f0/0xfff0,actions=conjunction(10,2/2)
+	// table=1,priority=100,tcp,nw_dst=192.168.0.1,conj_id=10,actions=output:1
+	// false 5 4
+	// table=1,priority=100,tcp,nw_src=10.0.0.0/24,tp_dst=80,actions=output:1
+	// table=1,priority=100,tcp,nw_src=10.0.0.0/24,tp_dst=443,actions=output:1
+	// table=1,priority=100,tcp,nw_src=10.0.1.0/24,tp_dst=80,actions=output:1
+	// table=1,priority=100,tcp,nw_src=10.0.1.0/24,tp_dst=443,actions=output:1
+}
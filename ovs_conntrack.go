@@ -0,0 +1,345 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-exec"
+)
+
+// ConntrackTuple is the tuple of the connection in one direction.
+//
+// For ICMP, SrcPort and DstPort are 0, and ICMPID, ICMPType and ICMPCode
+// are used instead.
+type ConntrackTuple struct {
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+
+	ICMPID   uint16
+	ICMPType uint8
+	ICMPCode uint8
+}
+
+// ConntrackEntry is an entry of the connection tracking table.
+type ConntrackEntry struct {
+	Proto   string // Such as "tcp", "udp", "icmp", "icmpv6", etc.
+	Orig    ConntrackTuple
+	Reply   ConntrackTuple
+	Zone    int
+	Mark    uint32
+	Labels  Uint128
+	State   string // The protocol state, such as "ESTABLISHED" for TCP.
+	Timeout int    // The timeout in seconds, which is only returned with stats.
+}
+
+// ConntrackFilter is used to filter the conntrack entries.
+//
+// The zero value of the field matches any.
+type ConntrackFilter struct {
+	Proto   string
+	Src     netip.Addr // The source address of the original direction
+	Dst     netip.Addr // The destination address of the original direction
+	SrcPort uint16     // The source port of the original direction
+	DstPort uint16     // The destination port of the original direction
+	Mark    uint32
+}
+
+// Match reports whether the conntrack entry matches the filter.
+func (f ConntrackFilter) Match(e ConntrackEntry) bool {
+	switch {
+	case f.Proto != "" && f.Proto != e.Proto:
+	case f.Src.IsValid() && f.Src != e.Orig.Src:
+	case f.Dst.IsValid() && f.Dst != e.Orig.Dst:
+	case f.SrcPort != 0 && f.SrcPort != e.Orig.SrcPort:
+	case f.DstPort != 0 && f.DstPort != e.Orig.DstPort:
+	case f.Mark != 0 && f.Mark != e.Mark:
+	default:
+		return true
+	}
+	return false
+}
+
+// DumpConntrack dumps the entries of the connection tracking table
+// in the zone, which are filtered by the filters.
+//
+// If zone is negative, dump the entries in all the zones.
+// If no filters, return all the entries. Or, return the entries matching
+// any of the filters.
+func DumpConntrack(zone int, filters ...ConntrackFilter) (entries []ConntrackEntry, err error) {
+	args := []string{"dpctl/dump-conntrack", "-s"}
+	if zone >= 0 {
+		args = append(args, fmt.Sprintf("zone=%d", zone))
+	}
+
+	out, err := exec.Output(context.Background(), AppctlCmd, args...)
+	if err != nil {
+		return
+	}
+
+	all, err := parseConntrackEntries(out)
+	if err != nil || len(filters) == 0 {
+		return all, err
+	}
+
+	entries = make([]ConntrackEntry, 0, len(all))
+	for _, e := range all {
+		for _, f := range filters {
+			if f.Match(e) {
+				entries = append(entries, e)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// FlushConntrack flushes all the entries of the connection tracking table
+// in the zone.
+//
+// If zone is negative, flush the entries in all the zones.
+func FlushConntrack(zone int) (err error) {
+	args := []string{"dpctl/flush-conntrack"}
+	if zone >= 0 {
+		args = append(args, fmt.Sprintf("zone=%d", zone))
+	}
+	return exec.Execute(context.Background(), AppctlCmd, args...)
+}
+
+// FlushConntrackTuple flushes the entry of the connection in the zone,
+// the original tuple of which is orig with the IP protocol number proto,
+// such as TCP or UDP.
+func FlushConntrackTuple(zone uint16, proto uint8, orig ConntrackTuple) (err error) {
+	if !orig.Src.IsValid() || !orig.Dst.IsValid() {
+		return fmt.Errorf("the source and destination addresses of the tuple must be set")
+	} else if orig.Src.Is4() != orig.Dst.Is4() {
+		return fmt.Errorf("the source and destination addresses of the tuple are not the same family")
+	}
+
+	tuple := make([]string, 0, 8)
+	if orig.Src.Is4() {
+		tuple = append(tuple, "ct_nw_src="+orig.Src.String(), "ct_nw_dst="+orig.Dst.String())
+	} else {
+		tuple = append(tuple, "ct_ipv6_src="+orig.Src.String(), "ct_ipv6_dst="+orig.Dst.String())
+	}
+	tuple = append(tuple, fmt.Sprintf("ct_nw_proto=%d", proto))
+
+	if proto == ICMP || proto == ICMPv6 {
+		tuple = append(tuple,
+			fmt.Sprintf("icmp_id=%d", orig.ICMPID),
+			fmt.Sprintf("icmp_type=%d", orig.ICMPType),
+			fmt.Sprintf("icmp_code=%d", orig.ICMPCode))
+	} else {
+		if orig.SrcPort > 0 {
+			tuple = append(tuple, fmt.Sprintf("ct_tp_src=%d", orig.SrcPort))
+		}
+		if orig.DstPort > 0 {
+			tuple = append(tuple, fmt.Sprintf("ct_tp_dst=%d", orig.DstPort))
+		}
+	}
+
+	return exec.Execute(context.Background(), AppctlCmd, "dpctl/flush-conntrack",
+		fmt.Sprintf("zone=%d", zone), strings.Join(tuple, ","))
+}
+
+// ConntrackLimit is the limit of the connections in a zone.
+type ConntrackLimit struct {
+	Zone  int // -1 represents the default limit of all the zones.
+	Limit int
+	Count int // Only used by GetConntrackLimits.
+}
+
+// SetConntrackLimits sets the limits of the connections in the zones.
+//
+// If Zone is negative, set the default limit.
+func SetConntrackLimits(limits ...ConntrackLimit) (err error) {
+	if len(limits) == 0 {
+		return
+	}
+
+	args := make([]string, 0, len(limits)+1)
+	args = append(args, "dpctl/ct-set-limits")
+	for _, limit := range limits {
+		if limit.Zone < 0 {
+			args = append(args, fmt.Sprintf("default=%d", limit.Limit))
+		} else {
+			args = append(args, fmt.Sprintf("zone=%d,limit=%d", limit.Zone, limit.Limit))
+		}
+	}
+	return exec.Execute(context.Background(), AppctlCmd, args...)
+}
+
+// DelConntrackLimits deletes the limits of the connections in the zones.
+func DelConntrackLimits(zones ...uint16) (err error) {
+	if len(zones) == 0 {
+		return
+	}
+
+	_zones := make([]string, len(zones))
+	for i, zone := range zones {
+		_zones[i] = strconv.FormatUint(uint64(zone), 10)
+	}
+	return exec.Execute(context.Background(), AppctlCmd, "dpctl/ct-del-limits",
+		"zone="+strings.Join(_zones, ","))
+}
+
+// GetConntrackLimits returns the limits of the connections in the zones.
+//
+// If no zones, return the default limit and the limits of all the zones
+// which have been set.
+func GetConntrackLimits(zones ...uint16) (limits []ConntrackLimit, err error) {
+	args := []string{"dpctl/ct-get-limits"}
+	if len(zones) > 0 {
+		_zones := make([]string, len(zones))
+		for i, zone := range zones {
+			_zones[i] = strconv.FormatUint(uint64(zone), 10)
+		}
+		args = append(args, "zone="+strings.Join(_zones, ","))
+	}
+
+	out, err := exec.Output(context.Background(), AppctlCmd, args...)
+	if err == nil {
+		limits, err = parseConntrackLimits(out)
+	}
+	return
+}
+
+// parseConntrackLimits parses the output of "ovs-appctl dpctl/ct-get-limits".
+//
+//	default limit=0
+//	zone=1,limit=10,count=0
+func parseConntrackLimits(out string) (limits []ConntrackLimit, err error) {
+	for _, line := range splitLines(out) {
+		limit := ConntrackLimit{Zone: -1}
+		if strings.HasPrefix(line, "default ") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "default "))
+		}
+
+		for _, arg := range splitArgs(line, ',') {
+			key, value := splitKeyValue(arg)
+			var v int
+			if v, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid conntrack limit line '%s'", line)
+			}
+
+			switch key {
+			case "zone":
+				limit.Zone = v
+			case "limit":
+				limit.Limit = v
+			case "count":
+				limit.Count = v
+			}
+		}
+		limits = append(limits, limit)
+	}
+	return
+}
+
+// parseConntrackEntries parses the output of "ovs-appctl dpctl/dump-conntrack".
+//
+//	tcp,orig=(src=10.0.0.1,dst=10.0.0.2,sport=34567,dport=80),reply=(src=10.0.0.2,dst=10.0.0.1,sport=80,dport=34567),zone=5,mark=1,protoinfo=(state=ESTABLISHED)
+func parseConntrackEntries(out string) (entries []ConntrackEntry, err error) {
+	lines := splitLines(out)
+	entries = make([]ConntrackEntry, 0, len(lines))
+	for _, line := range lines {
+		entry, err := parseConntrackEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func parseConntrackEntry(line string) (entry ConntrackEntry, err error) {
+	args := splitArgs(line, ',')
+	if len(args) == 0 {
+		return entry, fmt.Errorf("invalid conntrack entry '%s'", line)
+	}
+
+	entry.Proto = args[0]
+	for _, arg := range args[1:] {
+		key, value := splitKeyValue(arg)
+		switch key {
+		case "orig":
+			entry.Orig, err = parseConntrackTuple(value)
+		case "reply":
+			entry.Reply, err = parseConntrackTuple(value)
+		case "zone":
+			entry.Zone, err = strconv.Atoi(value)
+		case "mark":
+			var v uint64
+			v, err = strconv.ParseUint(value, 0, 32)
+			entry.Mark = uint32(v)
+		case "labels":
+			entry.Labels, err = ParseUint128(value)
+		case "timeout":
+			entry.Timeout, err = strconv.Atoi(value)
+		case "protoinfo":
+			for _, info := range splitArgs(trimParens(value), ',') {
+				if k, v := splitKeyValue(info); k == "state" {
+					entry.State = v
+				}
+			}
+		}
+
+		if err != nil {
+			return entry, fmt.Errorf("invalid conntrack entry '%s': %v", line, err)
+		}
+	}
+
+	return
+}
+
+func parseConntrackTuple(s string) (tuple ConntrackTuple, err error) {
+	for _, arg := range splitArgs(trimParens(s), ',') {
+		var v uint64
+		key, value := splitKeyValue(arg)
+		switch key {
+		case "src":
+			tuple.Src, err = netip.ParseAddr(value)
+		case "dst":
+			tuple.Dst, err = netip.ParseAddr(value)
+		case "sport":
+			v, err = strconv.ParseUint(value, 10, 16)
+			tuple.SrcPort = uint16(v)
+		case "dport":
+			v, err = strconv.ParseUint(value, 10, 16)
+			tuple.DstPort = uint16(v)
+		case "id":
+			v, err = strconv.ParseUint(value, 10, 16)
+			tuple.ICMPID = uint16(v)
+		case "type":
+			v, err = strconv.ParseUint(value, 10, 8)
+			tuple.ICMPType = uint8(v)
+		case "code":
+			v, err = strconv.ParseUint(value, 10, 8)
+			tuple.ICMPCode = uint8(v)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+	return
+}
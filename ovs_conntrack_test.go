@@ -0,0 +1,86 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseConntrackEntries(t *testing.T) {
+	out := `tcp,orig=(src=10.0.0.1,dst=10.0.0.2,sport=34567,dport=80),reply=(src=10.0.0.2,dst=10.0.0.1,sport=80,dport=34567),zone=5,mark=1,labels=0x10000000000000002,timeout=431999,protoinfo=(state=ESTABLISHED)
+icmp,orig=(src=10.0.0.1,dst=10.0.0.2,id=1234,type=8,code=0),reply=(src=10.0.0.2,dst=10.0.0.1,id=1234,type=0,code=0),zone=1
+`
+
+	entries, err := parseConntrackEntries(out)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Fatalf("expect 2 entries, but got %d", len(entries))
+	}
+
+	expect := ConntrackEntry{
+		Proto: "tcp",
+		Orig: ConntrackTuple{
+			Src:     netip.MustParseAddr("10.0.0.1"),
+			Dst:     netip.MustParseAddr("10.0.0.2"),
+			SrcPort: 34567,
+			DstPort: 80,
+		},
+		Reply: ConntrackTuple{
+			Src:     netip.MustParseAddr("10.0.0.2"),
+			Dst:     netip.MustParseAddr("10.0.0.1"),
+			SrcPort: 80,
+			DstPort: 34567,
+		},
+		Zone:    5,
+		Mark:    1,
+		Labels:  Uint128{Hi: 1, Lo: 2},
+		State:   "ESTABLISHED",
+		Timeout: 431999,
+	}
+	if entries[0] != expect {
+		t.Errorf("expect %+v, but got %+v", expect, entries[0])
+	}
+
+	if e := entries[1]; e.Proto != "icmp" || e.Zone != 1 || e.Orig.ICMPID != 1234 ||
+		e.Orig.ICMPType != 8 || e.Reply.ICMPType != 0 {
+		t.Errorf("unexpected icmp entry %+v", e)
+	}
+
+	if !(ConntrackFilter{Proto: "tcp", DstPort: 80}).Match(entries[0]) {
+		t.Errorf("expect the tcp entry to match the filter")
+	}
+	if (ConntrackFilter{Proto: "tcp"}).Match(entries[1]) {
+		t.Errorf("unexpect the icmp entry to match the filter")
+	}
+}
+
+func TestParseConntrackLimits(t *testing.T) {
+	limits, err := parseConntrackLimits("default limit=100\nzone=1,limit=10,count=3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []ConntrackLimit{{Zone: -1, Limit: 100}, {Zone: 1, Limit: 10, Count: 3}}
+	if len(limits) != len(expects) {
+		t.Fatalf("expect %d limits, but got %d", len(expects), len(limits))
+	}
+	for i, limit := range limits {
+		if limit != expects[i] {
+			t.Errorf("%d: expect %+v, but got %+v", i, expects[i], limit)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-atexit"
+	"github.com/xgfone/go-exec"
+)
+
+// Connection modes of the controller.
+const (
+	ConnectionModeInBand    = "in-band"
+	ConnectionModeOutOfBand = "out-of-band"
+)
+
+// ControllerOptions is the options of the OpenFlow controllers.
+type ControllerOptions struct {
+	ConnectionMode  string // ConnectionModeInBand or ConnectionModeOutOfBand
+	InactivityProbe int    // The inactivity probe interval in milliseconds.
+	MaxBackoff      int    // The max backoff in milliseconds.
+}
+
+func (o ControllerOptions) columns() (columns []string) {
+	if o.ConnectionMode != "" {
+		columns = append(columns, "connection_mode="+o.ConnectionMode)
+	}
+	if o.InactivityProbe > 0 {
+		columns = append(columns, fmt.Sprintf("inactivity_probe=%d", o.InactivityProbe))
+	}
+	if o.MaxBackoff > 0 {
+		columns = append(columns, fmt.Sprintf("max_backoff=%d", o.MaxBackoff))
+	}
+	return
+}
+
+// ControllerStatus is the status of an OpenFlow controller of the bridge.
+type ControllerStatus struct {
+	Target      string
+	IsConnected bool
+	Role        string // "other", "master" or "slave"
+	State       string // Such as "ACTIVE", "BACKOFF", "CONNECTING", etc.
+	LastError   string
+}
+
+// SetController sets the OpenFlow controllers of the bridge,
+// which replaces the old ones, such as "tcp:10.0.0.1:6653".
+func SetController(bridge string, targets ...string) (err error) {
+	return SetControllerWithOptions(bridge, ControllerOptions{}, targets...)
+}
+
+// SetControllerWithOptions is the same as SetController, but also sets
+// the options of all the controllers in the same transaction.
+//
+// Notice: the old controllers are destroyed, so their connections are
+// re-established. Use UpdateController to only change the options.
+func SetControllerWithOptions(bridge string, opts ControllerOptions, targets ...string) (err error) {
+	args, err := setControllerArgs(bridge, opts, targets)
+	if err != nil {
+		return
+	}
+	return exec.Execute(context.Background(), VsctlCmd, args...)
+}
+
+func (o ControllerOptions) validate() error {
+	switch o.ConnectionMode {
+	case "", ConnectionModeInBand, ConnectionModeOutOfBand:
+		return nil
+	default:
+		return fmt.Errorf("invalid controller connection mode '%s'", o.ConnectionMode)
+	}
+}
+
+// setControllerArgs returns the arguments of ovs-vsctl to create
+// the controllers with the options and to set them into the bridge.
+func setControllerArgs(bridge string, opts ControllerOptions, targets []string) (args []string, err error) {
+	if len(targets) == 0 {
+		return nil, errors.New("the controller targets must not be empty")
+	} else if err = opts.validate(); err != nil {
+		return
+	}
+
+	columns := opts.columns()
+	ids := make([]string, len(targets))
+	args = make([]string, 0, len(targets)*(len(columns)+6)+5)
+	for i, target := range targets {
+		ids[i] = fmt.Sprintf("@c%d", i)
+		args = append(args, "--", "--id="+ids[i], "create", "controller",
+			"target="+strconv.Quote(target))
+		args = append(args, columns...)
+	}
+	args = append(args, "--", "set", "bridge", bridge, "controller="+dbSet(ids))
+	return
+}
+
+// UpdateController updates the options of all the existing OpenFlow
+// controllers of the bridge in place, which does not destroy the controllers
+// and therefore keeps their connections.
+//
+// The zero fields of the options are not changed.
+func UpdateController(bridge string, opts ControllerOptions) (err error) {
+	if err = opts.validate(); err != nil {
+		return
+	}
+
+	bridges, err := listDBRecords("Bridge", []string{"controller"}, bridge)
+	if err != nil {
+		return
+	} else if len(bridges) == 0 {
+		return fmt.Errorf("no bridge '%s'", bridge)
+	}
+
+	if args := updateControllerArgs(dbStrings(bridges[0]["controller"]), opts); len(args) > 0 {
+		err = exec.Execute(context.Background(), VsctlCmd, args...)
+	}
+	return
+}
+
+// updateControllerArgs returns the arguments of ovs-vsctl to set
+// the options of the controllers identified by the uuids.
+func updateControllerArgs(uuids []string, opts ControllerOptions) (args []string) {
+	columns := opts.columns()
+	if len(uuids) == 0 || len(columns) == 0 {
+		return nil
+	}
+
+	args = make([]string, 0, len(uuids)*(len(columns)+4))
+	for _, uuid := range uuids {
+		args = append(args, "--", "set", "controller", uuid)
+		args = append(args, columns...)
+	}
+	return
+}
+
+// GetController returns the targets of the OpenFlow controllers of the bridge.
+func GetController(bridge string) (targets []string, err error) {
+	out, err := exec.Output(context.Background(), VsctlCmd, "get-controller", bridge)
+	if err == nil {
+		targets = splitLines(out)
+	}
+	return
+}
+
+// DelController deletes all the OpenFlow controllers of the bridge.
+func DelController(bridge string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "del-controller", bridge)
+}
+
+// GetControllerStatus returns the status of all the OpenFlow controllers
+// of the bridge.
+func GetControllerStatus(bridge string) (status []ControllerStatus, err error) {
+	bridges, err := listDBRecords("Bridge", []string{"controller"}, bridge)
+	if err != nil || len(bridges) == 0 {
+		return
+	}
+
+	uuids := dbStrings(bridges[0]["controller"])
+	if len(uuids) == 0 {
+		return
+	}
+
+	records, err := listDBRecords("Controller", []string{"target",
+		"is_connected", "role", "status"}, uuids...)
+	if err != nil {
+		return
+	}
+
+	status = parseControllerStatus(records)
+	return
+}
+
+// parseControllerStatus parses the records of the Controller table
+// into the status of the controllers.
+func parseControllerStatus(records []dbRecord) (status []ControllerStatus) {
+	status = make([]ControllerStatus, len(records))
+	for i, r := range records {
+		s := dbMap(r["status"])
+		status[i] = ControllerStatus{
+			Target:      dbString(r["target"]),
+			IsConnected: dbBool(r["is_connected"]),
+			Role:        dbString(r["role"]),
+			State:       s["state"],
+			LastError:   s["last_error"],
+		}
+	}
+	return
+}
+
+// SetManager sets the OVSDB managers, which replaces the old ones,
+// such as "ptcp:6640".
+func SetManager(targets ...string) (err error) {
+	if len(targets) == 0 {
+		return errors.New("the manager targets must not be empty")
+	}
+
+	args := append([]string{"set-manager"}, targets...)
+	return exec.Execute(context.Background(), VsctlCmd, args...)
+}
+
+// GetManager returns the targets of the OVSDB managers.
+func GetManager() (targets []string, err error) {
+	out, err := exec.Output(context.Background(), VsctlCmd, "get-manager")
+	if err == nil {
+		targets = splitLines(out)
+	}
+	return
+}
+
+// DelManager deletes all the OVSDB managers.
+func DelManager() (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "del-manager")
+}
+
+// MustSetController is the same as SetController, but exit the program if failing.
+func MustSetController(bridge string, targets ...string) {
+	if err := SetController(bridge, targets...); err != nil {
+		log.Printf("fail to set the controller: bridge=%s, targets=%v, err=%v", bridge, targets, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustDelController is the same as DelController, but exit the program if failing.
+func MustDelController(bridge string) {
+	if err := DelController(bridge); err != nil {
+		log.Printf("fail to delete the controller: bridge=%s, err=%v", bridge, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustSetManager is the same as SetManager, but exit the program if failing.
+func MustSetManager(targets ...string) {
+	if err := SetManager(targets...); err != nil {
+		log.Printf("fail to set the manager: targets=%v, err=%v", targets, err)
+		atexit.Exit(1)
+	}
+}
+
+// splitLines splits the output into the non-empty lines.
+func splitLines(out string) []string {
+	lines := strings.Split(out, "\n")
+	results := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			results = append(results, line)
+		}
+	}
+	return results
+}
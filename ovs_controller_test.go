@@ -0,0 +1,107 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestControllerOptionsColumns(t *testing.T) {
+	opts := ControllerOptions{ConnectionMode: ConnectionModeOutOfBand, InactivityProbe: 5000, MaxBackoff: 8000}
+	expect := []string{"connection_mode=out-of-band", "inactivity_probe=5000", "max_backoff=8000"}
+	if columns := opts.columns(); !reflect.DeepEqual(columns, expect) {
+		t.Errorf("expect columns %v, but got %v", expect, columns)
+	}
+
+	if columns := (ControllerOptions{}).columns(); len(columns) != 0 {
+		t.Errorf("expect no columns, but got %v", columns)
+	}
+}
+
+func TestSetControllerArgs(t *testing.T) {
+	opts := ControllerOptions{ConnectionMode: ConnectionModeInBand, MaxBackoff: 1000}
+	args, err := setControllerArgs("br0", opts, []string{"tcp:10.0.0.1:6653", "tcp:10.0.0.2:6653"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"--", "--id=@c0", "create", "controller", `target="tcp:10.0.0.1:6653"`,
+		"connection_mode=in-band", "max_backoff=1000",
+		"--", "--id=@c1", "create", "controller", `target="tcp:10.0.0.2:6653"`,
+		"connection_mode=in-band", "max_backoff=1000",
+		"--", "set", "bridge", "br0", "controller=[@c0,@c1]",
+	}
+	if !reflect.DeepEqual(args, expect) {
+		t.Errorf("expect args %v, but got %v", expect, args)
+	}
+
+	if _, err = setControllerArgs("br0", ControllerOptions{}, nil); err == nil {
+		t.Errorf("expect an error for the empty targets")
+	}
+	if _, err = setControllerArgs("br0", ControllerOptions{ConnectionMode: "unknown"}, []string{"tcp:10.0.0.1:6653"}); err == nil {
+		t.Errorf("expect an error for the invalid connection mode")
+	}
+}
+
+func TestUpdateControllerArgs(t *testing.T) {
+	uuids := []string{"1f0c9a55-0d6e-4b8e-9a55-4c3a5c6d7e8f", "2a1b3c4d-5e6f-4a8b-9c0d-1e2f3a4b5c6d"}
+	args := updateControllerArgs(uuids, ControllerOptions{InactivityProbe: 3000})
+	expect := []string{
+		"--", "set", "controller", uuids[0], "inactivity_probe=3000",
+		"--", "set", "controller", uuids[1], "inactivity_probe=3000",
+	}
+	if !reflect.DeepEqual(args, expect) {
+		t.Errorf("expect args %v, but got %v", expect, args)
+	}
+
+	if args = updateControllerArgs(uuids, ControllerOptions{}); len(args) != 0 {
+		t.Errorf("expect no args for the empty options, but got %v", args)
+	}
+	if args = updateControllerArgs(nil, ControllerOptions{MaxBackoff: 1000}); len(args) != 0 {
+		t.Errorf("expect no args for no controllers, but got %v", args)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	lines := splitLines("tcp:10.0.0.1:6653\n\n  tcp:10.0.0.2:6653  \n")
+	expect := []string{"tcp:10.0.0.1:6653", "tcp:10.0.0.2:6653"}
+	if !reflect.DeepEqual(lines, expect) {
+		t.Errorf("expect lines %v, but got %v", expect, lines)
+	}
+
+	if lines = splitLines(""); len(lines) != 0 {
+		t.Errorf("expect no lines, but got %v", lines)
+	}
+}
+
+func TestParseControllerStatus(t *testing.T) {
+	records, err := parseDBRecords(`{"data":[` +
+		`["tcp:10.0.0.1:6653",true,"master",["map",[["sec_since_connect","10"],["state","ACTIVE"]]]],` +
+		`["tcp:10.0.0.2:6653",false,["set",[]],["map",[["last_error","Connection refused"],["state","BACKOFF"]]]]],` +
+		`"headings":["target","is_connected","role","status"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []ControllerStatus{
+		{Target: "tcp:10.0.0.1:6653", IsConnected: true, Role: "master", State: "ACTIVE"},
+		{Target: "tcp:10.0.0.2:6653", State: "BACKOFF", LastError: "Connection refused"},
+	}
+	if status := parseControllerStatus(records); !reflect.DeepEqual(status, expect) {
+		t.Errorf("expect status %+v, but got %+v", expect, status)
+	}
+}
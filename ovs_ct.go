@@ -0,0 +1,297 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// CTAction is the builder of the conntrack action "ct(...)".
+//
+// Example:
+//
+//	CT().Commit().ZoneField("NXM_NX_REG6[0..15]").Table(72).
+//		Exec(LoadAction(1, "NXM_NX_CT_MARK[]")).String()
+//	// => "ct(commit,zone=NXM_NX_REG6[0..15],table=72,exec(load:0x1->NXM_NX_CT_MARK[]))"
+type CTAction struct {
+	commit bool
+	force  bool
+	zone   string
+	table  int
+	alg    string
+	nat    *CTNAT
+	exec   []string
+}
+
+// CT returns a new builder of the conntrack action.
+func CT() CTAction { return CTAction{table: -1} }
+
+// Commit commits the connection to the connection tracking module.
+func (c CTAction) Commit() CTAction { c.commit = true; return c }
+
+// Force commits the connection in the original direction, which implies Commit.
+func (c CTAction) Force() CTAction { c.commit = true; c.force = true; return c }
+
+// Zone sets the conntrack zone to the constant value.
+func (c CTAction) Zone(zone uint16) CTAction { c.zone = fmt.Sprint(zone); return c }
+
+// ZoneField sets the conntrack zone from the field, such as "NXM_NX_REG6[0..15]".
+func (c CTAction) ZoneField(field string) CTAction { c.zone = field; return c }
+
+// Table recirculates the packet to the table after conntrack.
+func (c CTAction) Table(table uint8) CTAction { c.table = int(table); return c }
+
+// Alg sets the application layer gateway, such as "ftp" or "tftp".
+func (c CTAction) Alg(alg string) CTAction { c.alg = alg; return c }
+
+// NAT applies the network address translation.
+//
+// It panics if nat is invalid. See CTNAT.Validate.
+func (c CTAction) NAT(nat CTNAT) CTAction {
+	if err := nat.Validate(); err != nil {
+		panic(err)
+	}
+	c.nat = &nat
+	return c
+}
+
+// Exec appends the actions, which only set the ct_mark or ct_label field,
+// executed within the context of the connection.
+func (c CTAction) Exec(actions ...string) CTAction {
+	c.exec = append(append([]string(nil), c.exec...), actions...)
+	return c
+}
+
+// String returns the string representation of the ct action.
+func (c CTAction) String() string {
+	args := make([]string, 0, 6)
+	if c.commit {
+		args = append(args, "commit")
+	}
+	if c.force {
+		args = append(args, "force")
+	}
+	if c.zone != "" {
+		args = append(args, "zone="+c.zone)
+	}
+	if c.table >= 0 {
+		args = append(args, fmt.Sprintf("table=%d", c.table))
+	}
+	if c.alg != "" {
+		args = append(args, "alg="+c.alg)
+	}
+	if c.nat != nil {
+		args = append(args, c.nat.String())
+	}
+	if len(c.exec) > 0 {
+		args = append(args, fmt.Sprintf("exec(%s)", strings.Join(c.exec, ",")))
+	}
+
+	if len(args) == 0 {
+		return "ct"
+	}
+	return fmt.Sprintf("ct(%s)", strings.Join(args, ","))
+}
+
+// CTNAT is the nat argument of the conntrack action.
+//
+// If neither Src nor Dst is true, it only applies the existing nat
+// of the connection, that's, "nat", and the other fields must be empty.
+// Src and Dst must not be both true, and the ports require IPMin.
+type CTNAT struct {
+	Src bool // For SNAT
+	Dst bool // For DNAT
+
+	IPMin   netip.Addr
+	IPMax   netip.Addr // Optional
+	PortMin uint16     // Optional
+	PortMax uint16     // Optional
+
+	Random     bool
+	Hash       bool
+	Persistent bool
+}
+
+// Validate checks whether the nat argument is valid.
+func (n CTNAT) Validate() error {
+	switch {
+	case n.Src && n.Dst:
+		return errors.New("the nat must not be both src and dst")
+
+	case !n.Src && !n.Dst:
+		if n.IPMin.IsValid() || n.IPMax.IsValid() || n.PortMin > 0 || n.PortMax > 0 ||
+			n.Random || n.Hash || n.Persistent {
+			return errors.New("the nat without src or dst must not have the address, port or flags")
+		}
+
+	case n.IPMax.IsValid() && (!n.IPMin.IsValid() || n.IPMax.Is4() != n.IPMin.Is4() || n.IPMax.Less(n.IPMin)):
+		return fmt.Errorf("invalid nat address range [%s, %s]", n.IPMin, n.IPMax)
+
+	case (n.PortMin > 0 || n.PortMax > 0) && !n.IPMin.IsValid():
+		return errors.New("the nat port range requires the address")
+
+	case n.PortMax > 0 && n.PortMax < n.PortMin, n.PortMax > 0 && n.PortMin == 0:
+		return fmt.Errorf("invalid nat port range [%d, %d]", n.PortMin, n.PortMax)
+	}
+	return nil
+}
+
+// String returns the string representation of the nat argument.
+//
+// It panics if the nat argument is invalid. See Validate.
+func (n CTNAT) String() string {
+	if err := n.Validate(); err != nil {
+		panic(err)
+	}
+
+	var b strings.Builder
+	b.WriteString("nat")
+	if !n.Src && !n.Dst {
+		return b.String()
+	}
+
+	b.WriteByte('(')
+	if n.Src {
+		b.WriteString("src")
+	} else {
+		b.WriteString("dst")
+	}
+
+	if n.IPMin.IsValid() {
+		b.WriteByte('=')
+		b.WriteString(natAddr(n.IPMin))
+		if n.IPMax.IsValid() && n.IPMax != n.IPMin {
+			b.WriteByte('-')
+			b.WriteString(natAddr(n.IPMax))
+		}
+
+		if n.PortMin > 0 {
+			fmt.Fprintf(&b, ":%d", n.PortMin)
+			if n.PortMax > n.PortMin {
+				fmt.Fprintf(&b, "-%d", n.PortMax)
+			}
+		}
+	}
+
+	if n.Random {
+		b.WriteString(",random")
+	}
+	if n.Hash {
+		b.WriteString(",hash")
+	}
+	if n.Persistent {
+		b.WriteString(",persistent")
+	}
+
+	b.WriteByte(')')
+	return b.String()
+}
+
+func natAddr(ip netip.Addr) string {
+	if ip.Is6() && !ip.Is4In6() {
+		return "[" + ip.String() + "]"
+	}
+	return ip.Unmap().String()
+}
+
+// CTStateFlag is the flag of the conntrack state, which is used by the match
+// field ct_state.
+type CTStateFlag uint16
+
+// Pre-define some conntrack state flags.
+const (
+	CTStateNew CTStateFlag = 1 << iota
+	CTStateEst
+	CTStateRel
+	CTStateRpl
+	CTStateInv
+	CTStateTrk
+	CTStateSNAT
+	CTStateDNAT
+)
+
+var ctStateFlags = []struct {
+	Flag CTStateFlag
+	Name string
+}{
+	{CTStateTrk, "trk"},
+	{CTStateNew, "new"},
+	{CTStateEst, "est"},
+	{CTStateRel, "rel"},
+	{CTStateRpl, "rpl"},
+	{CTStateInv, "inv"},
+	{CTStateSNAT, "snat"},
+	{CTStateDNAT, "dnat"},
+}
+
+// CTState returns the match of ct_state, which requires that the flags
+// in set must be set and the flags in unset must be unset.
+//
+// Example:
+//
+//	CTState(CTStateTrk|CTStateEst, CTStateRel|CTStateInv)
+//	// => "ct_state=+trk+est-rel-inv"
+func CTState(set, unset CTStateFlag) string {
+	var b strings.Builder
+	b.WriteString("ct_state=")
+	for _, f := range ctStateFlags {
+		if set&f.Flag != 0 {
+			b.WriteByte('+')
+			b.WriteString(f.Name)
+		} else if unset&f.Flag != 0 {
+			b.WriteByte('-')
+			b.WriteString(f.Name)
+		}
+	}
+	return b.String()
+}
+
+// CTMark returns the match of ct_mark.
+//
+// If mask is equal to 0 or 0xffffffff, it is an exact match.
+func CTMark(value, mask uint32) string {
+	if mask == 0 || mask == 0xffffffff {
+		return fmt.Sprintf("ct_mark=0x%x", value)
+	}
+	return fmt.Sprintf("ct_mark=0x%x/0x%x", value, mask)
+}
+
+// CTLabel returns the match of ct_label.
+//
+// If mask is equal to 0 or all ones, it is an exact match.
+func CTLabel(value, mask Uint128) string {
+	if mask.IsZero() || (mask.Hi == ^uint64(0) && mask.Lo == ^uint64(0)) {
+		return "ct_label=" + value.String()
+	}
+	return fmt.Sprintf("ct_label=%s/%s", value, mask)
+}
+
+// CTZone returns the match of ct_zone.
+func CTZone(zone uint16) string { return fmt.Sprintf("ct_zone=%d", zone) }
+
+// LoadAction returns the action to load the value into the field,
+// such as "load:0x1->NXM_NX_CT_MARK[]".
+func LoadAction(value uint64, field string) string {
+	return fmt.Sprintf("load:0x%x->%s", value, field)
+}
+
+// SetFieldAction returns the action to set the field to the value,
+// such as "set_field:0x1->ct_mark".
+func SetFieldAction(value, field string) string {
+	return fmt.Sprintf("set_field:%s->%s", value, field)
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func ExampleCT() {
+	fmt.Println(CT())
+	fmt.Println(CT().Table(72).ZoneField("NXM_NX_REG6[0..15]"))
+	fmt.Println(CT().Commit().ZoneField("NXM_NX_REG6[0..15]").Table(72).
+		Exec(LoadAction(1, "NXM_NX_CT_MARK[]")))
+	fmt.Println(CT().Commit().Zone(10).NAT(CTNAT{
+		Src:     true,
+		IPMin:   netip.MustParseAddr("10.0.0.1"),
+		IPMax:   netip.MustParseAddr("10.0.0.5"),
+		PortMin: 1000,
+		PortMax: 2000,
+		Random:  true,
+	}))
+	fmt.Println(CT().Zone(10).NAT(CTNAT{Dst: true, IPMin: netip.MustParseAddr("fd00::1")}))
+	fmt.Println(CT().Zone(10).Table(1).NAT(CTNAT{}))
+	fmt.Println(CT().Force().Alg("ftp"))
+
+	// Output:
+	// ct
+	// ct(zone=NXM_NX_REG6[0..15],table=72)
+	// ct(commit,zone=NXM_NX_REG6[0..15],table=72,exec(load:0x1->NXM_NX_CT_MARK[]))
+	// ct(commit,zone=10,nat(src=10.0.0.1-10.0.0.5:1000-2000,random))
+	// ct(zone=10,nat(dst=[fd00::1]))
+	// ct(zone=10,table=1,nat)
+	// ct(commit,force,alg=ftp)
+}
+
+func TestCTNATValidate(t *testing.T) {
+	ip1, ip2 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	for _, nat := range []CTNAT{
+		{Src: true, Dst: true},
+		{IPMin: ip1},
+		{Src: true, PortMin: 1000, PortMax: 2000},
+		{Src: true, IPMax: ip2},
+		{Src: true, IPMin: ip2, IPMax: ip1},
+		{Src: true, IPMin: ip1, IPMax: netip.MustParseAddr("fd00::1")},
+		{Dst: true, IPMin: ip1, PortMin: 2000, PortMax: 1000},
+		{Dst: true, IPMin: ip1, PortMax: 1000},
+	} {
+		if err := nat.Validate(); err == nil {
+			t.Errorf("expect an error for %+v", nat)
+		}
+	}
+
+	for _, nat := range []CTNAT{{}, {Src: true}, {Dst: true},
+		{Src: true, IPMin: ip1, IPMax: ip2, PortMin: 1000, PortMax: 2000}} {
+		if err := nat.Validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %v", nat, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expect a panic for the invalid nat")
+		}
+	}()
+	CT().NAT(CTNAT{Src: true, PortMin: 1000})
+}
+
+func ExampleCTState() {
+	fmt.Println(CTState(CTStateTrk|CTStateNew, 0))
+	fmt.Println(CTState(CTStateTrk|CTStateEst, CTStateRel|CTStateInv))
+	fmt.Println(CTMark(1, 0))
+	fmt.Println(CTMark(1, 1))
+	fmt.Println(CTLabel(Uint128{Lo: 1}, Uint128{}))
+	fmt.Println(CTLabel(Uint128{Hi: 1}, Uint128{Hi: 1}))
+
+	// Output:
+	// ct_state=+trk+new
+	// ct_state=+trk+est-rel-inv
+	// ct_mark=0x1
+	// ct_mark=0x1/0x1
+	// ct_label=0x1
+	// ct_label=0x10000000000000000/0x10000000000000000
+}
@@ -0,0 +1,263 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-exec"
+)
+
+// dbRecord is a record of the OVSDB table, the key of which is the column name.
+type dbRecord map[string]interface{}
+
+// listDBRecords lists the given columns of the records in the OVSDB table.
+//
+// If records is empty, list all the records in the table.
+func listDBRecords(table string, columns []string, records ...string) ([]dbRecord, error) {
+	args := make([]string, 0, len(records)+4)
+	args = append(args, "--format=json")
+	if len(columns) > 0 {
+		args = append(args, "--columns="+strings.Join(columns, ","))
+	}
+	args = append(args, "list", table)
+	args = append(args, records...)
+
+	out, err := exec.Output(context.Background(), VsctlCmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseDBRecords(out)
+}
+
+// parseDBRecords parses the output of "ovs-vsctl --format=json list".
+func parseDBRecords(out string) (records []dbRecord, err error) {
+	if out = strings.TrimSpace(out); out == "" {
+		return
+	}
+
+	var table struct {
+		Headings []string        `json:"headings"`
+		Data     [][]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal([]byte(out), &table); err != nil {
+		return nil, fmt.Errorf("invalid ovsdb json output: %v", err)
+	}
+
+	records = make([]dbRecord, len(table.Data))
+	for i, row := range table.Data {
+		if len(row) != len(table.Headings) {
+			return nil, fmt.Errorf("the ovsdb row has %d columns, but expect %d",
+				len(row), len(table.Headings))
+		}
+
+		record := make(dbRecord, len(row))
+		for j, value := range row {
+			record[table.Headings[j]] = decodeDBValue(value)
+		}
+		records[i] = record
+	}
+
+	return
+}
+
+// decodeDBValue decodes the value in the OVSDB JSON notation,
+// which converts the uuid to string, the set to []interface{},
+// and the map to map[string]interface{}.
+func decodeDBValue(v interface{}) interface{} {
+	vs, ok := v.([]interface{})
+	if !ok || len(vs) != 2 {
+		return v
+	}
+
+	switch vs[0] {
+	case "uuid", "named-uuid":
+		return vs[1]
+
+	case "set":
+		elems, _ := vs[1].([]interface{})
+		set := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			set[i] = decodeDBValue(elem)
+		}
+		return set
+
+	case "map":
+		pairs, _ := vs[1].([]interface{})
+		m := make(map[string]interface{}, len(pairs))
+		for _, pair := range pairs {
+			if kv, ok := pair.([]interface{}); ok && len(kv) == 2 {
+				m[dbString(decodeDBValue(kv[0]))] = decodeDBValue(kv[1])
+			}
+		}
+		return m
+
+	default:
+		return v
+	}
+}
+
+// dbString converts the OVSDB atom to string.
+//
+// Return "" if v is an empty set.
+func dbString(v interface{}) string {
+	switch _v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return _v
+	case bool:
+		return strconv.FormatBool(_v)
+	case float64:
+		return strconv.FormatFloat(_v, 'f', -1, 64)
+	case []interface{}:
+		if len(_v) == 0 {
+			return ""
+		}
+		return dbString(_v[0])
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// dbInt converts the OVSDB atom to int.
+//
+// Return 0 if v is an empty set or not an integer.
+func dbInt(v interface{}) int {
+	switch _v := v.(type) {
+	case float64:
+		return int(_v)
+	case string:
+		i, _ := strconv.ParseInt(_v, 0, 64)
+		return int(i)
+	case []interface{}:
+		if len(_v) == 0 {
+			return 0
+		}
+		return dbInt(_v[0])
+	default:
+		return 0
+	}
+}
+
+// dbBool converts the OVSDB atom to bool.
+func dbBool(v interface{}) bool {
+	switch _v := v.(type) {
+	case bool:
+		return _v
+	case string:
+		b, _ := strconv.ParseBool(_v)
+		return b
+	case []interface{}:
+		if len(_v) == 0 {
+			return false
+		}
+		return dbBool(_v[0])
+	default:
+		return false
+	}
+}
+
+// dbStrings converts the OVSDB set to []string.
+func dbStrings(v interface{}) []string {
+	switch _v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		ss := make([]string, len(_v))
+		for i, e := range _v {
+			ss[i] = dbString(e)
+		}
+		return ss
+	default:
+		return []string{dbString(v)}
+	}
+}
+
+// dbInts converts the OVSDB set to []int.
+func dbInts(v interface{}) []int {
+	switch _v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		is := make([]int, len(_v))
+		for i, e := range _v {
+			is[i] = dbInt(e)
+		}
+		return is
+	default:
+		return []int{dbInt(v)}
+	}
+}
+
+// dbMap converts the OVSDB map to map[string]string.
+func dbMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	sm := make(map[string]string, len(m))
+	for k, v := range m {
+		sm[k] = dbString(v)
+	}
+	return sm
+}
+
+// dbIntMap converts the OVSDB map to map[string]int.
+func dbIntMap(v interface{}) map[string]int {
+	m, _ := v.(map[string]interface{})
+	im := make(map[string]int, len(m))
+	for k, v := range m {
+		im[k] = dbInt(v)
+	}
+	return im
+}
+
+// dbSet formats the values as the set argument of ovs-vsctl.
+func dbSet(values []string) string {
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// dbMapArg formats the map as the map argument of ovs-vsctl,
+// the keys of which are sorted.
+func dbMapArg(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, strconv.Quote(m[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// getPortNames returns the mapping from the uuid of the port to its name.
+func getPortNames() (map[string]string, error) {
+	records, err := listDBRecords("Port", []string{"_uuid", "name"})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(records))
+	for _, r := range records {
+		names[dbString(r["_uuid"])] = dbString(r["name"])
+	}
+	return names, nil
+}
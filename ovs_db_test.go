@@ -0,0 +1,64 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDBRecords(t *testing.T) {
+	out := `{"data":[[["uuid","5d6f4e1c-0000-0000-0000-000000000001"],"m0",` +
+		`["set",[["uuid","p1"],["uuid","p2"]]],["set",[]],` +
+		`["map",[["tx_bytes",1024],["tx_packets",8]]],100,true]],` +
+		`"headings":["_uuid","name","select_src_port","output_port","statistics","output_vlan","select_all"]}`
+
+	records, err := parseDBRecords(out)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 {
+		t.Fatalf("expect 1 record, but got %d", len(records))
+	}
+
+	r := records[0]
+	if v := dbString(r["_uuid"]); v != "5d6f4e1c-0000-0000-0000-000000000001" {
+		t.Errorf("unexpected uuid '%s'", v)
+	}
+	if v := dbString(r["name"]); v != "m0" {
+		t.Errorf("unexpected name '%s'", v)
+	}
+	if v := dbStrings(r["select_src_port"]); !reflect.DeepEqual(v, []string{"p1", "p2"}) {
+		t.Errorf("unexpected select_src_port %v", v)
+	}
+	if v := dbString(r["output_port"]); v != "" {
+		t.Errorf("unexpected output_port '%s'", v)
+	}
+	if v := dbIntMap(r["statistics"]); !reflect.DeepEqual(v, map[string]int{"tx_bytes": 1024, "tx_packets": 8}) {
+		t.Errorf("unexpected statistics %v", v)
+	}
+	if v := dbInt(r["output_vlan"]); v != 100 {
+		t.Errorf("unexpected output_vlan %d", v)
+	}
+	if !dbBool(r["select_all"]) {
+		t.Errorf("unexpected select_all false")
+	}
+}
+
+func TestDBMapArg(t *testing.T) {
+	arg := dbMapArg(map[string]string{"b": "2", "a": "x y"})
+	if expect := `{a="x y",b="2"}`; arg != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, arg)
+	}
+}
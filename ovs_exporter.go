@@ -0,0 +1,247 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xgfone/go-exec"
+)
+
+// SFlow represents the sFlow exporter configuration of the bridge.
+type SFlow struct {
+	Targets  []string // Such as "10.0.0.1:6343"
+	Agent    string   // The interface name or IP address, which is optional.
+	Sampling int      // The sampling rate, that's, 1 of N packets.
+	Header   int      // The header size in bytes.
+	Polling  int      // The polling interval in seconds.
+}
+
+func (s SFlow) columns() []string {
+	columns := []string{"targets=" + quoteSet(s.Targets)}
+	if s.Agent != "" {
+		columns = append(columns, "agent="+strconv.Quote(s.Agent))
+	}
+	if s.Sampling > 0 {
+		columns = append(columns, fmt.Sprintf("sampling=%d", s.Sampling))
+	}
+	if s.Header > 0 {
+		columns = append(columns, fmt.Sprintf("header=%d", s.Header))
+	}
+	if s.Polling > 0 {
+		columns = append(columns, fmt.Sprintf("polling=%d", s.Polling))
+	}
+	return columns
+}
+
+// NetFlow represents the NetFlow exporter configuration of the bridge.
+type NetFlow struct {
+	Targets       []string // Such as "10.0.0.1:2055"
+	ActiveTimeout int      // The active timeout in seconds.
+	EngineID      int
+	EngineType    int
+}
+
+func (n NetFlow) columns() []string {
+	columns := []string{"targets=" + quoteSet(n.Targets)}
+	if n.ActiveTimeout != 0 {
+		columns = append(columns, fmt.Sprintf("active_timeout=%d", n.ActiveTimeout))
+	}
+	if n.EngineID > 0 {
+		columns = append(columns, fmt.Sprintf("engine_id=%d", n.EngineID))
+	}
+	if n.EngineType > 0 {
+		columns = append(columns, fmt.Sprintf("engine_type=%d", n.EngineType))
+	}
+	return columns
+}
+
+// IPFIX represents the IPFIX exporter configuration of the bridge.
+type IPFIX struct {
+	Targets            []string // Such as "10.0.0.1:4739"
+	Sampling           int      // The sampling rate, that's, 1 of N packets.
+	ObsDomainID        int
+	ObsPointID         int
+	CacheActiveTimeout int // The cache active timeout in seconds.
+	CacheMaxFlows      int
+}
+
+func (i IPFIX) columns() []string {
+	columns := []string{"targets=" + quoteSet(i.Targets)}
+	if i.Sampling > 0 {
+		columns = append(columns, fmt.Sprintf("sampling=%d", i.Sampling))
+	}
+	if i.ObsDomainID > 0 {
+		columns = append(columns, fmt.Sprintf("obs_domain_id=%d", i.ObsDomainID))
+	}
+	if i.ObsPointID > 0 {
+		columns = append(columns, fmt.Sprintf("obs_point_id=%d", i.ObsPointID))
+	}
+	if i.CacheActiveTimeout > 0 {
+		columns = append(columns, fmt.Sprintf("cache_active_timeout=%d", i.CacheActiveTimeout))
+	}
+	if i.CacheMaxFlows > 0 {
+		columns = append(columns, fmt.Sprintf("cache_max_flows=%d", i.CacheMaxFlows))
+	}
+	return columns
+}
+
+// SetSFlow creates the sFlow exporter and attaches it to the bridge
+// in a transaction, which replaces the old one.
+func SetSFlow(bridge string, sflow SFlow) (err error) {
+	return attachBridgeRecord(bridge, "sflow", "sflow", sflow.Targets, sflow.columns())
+}
+
+// DelSFlow detaches the sFlow exporter from the bridge.
+func DelSFlow(bridge string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "clear", "bridge", bridge, "sflow")
+}
+
+// GetSFlow returns the sFlow exporter configuration of the bridge.
+//
+// If the bridge has no sFlow exporter, ok is false.
+func GetSFlow(bridge string) (sflow SFlow, ok bool, err error) {
+	r, ok, err := getBridgeRecord(bridge, "sflow", "sFlow",
+		"targets", "agent", "sampling", "header", "polling")
+	if ok {
+		sflow = parseSFlow(r)
+	}
+	return
+}
+
+func parseSFlow(r dbRecord) SFlow {
+	return SFlow{
+		Targets:  dbStrings(r["targets"]),
+		Agent:    dbString(r["agent"]),
+		Sampling: dbInt(r["sampling"]),
+		Header:   dbInt(r["header"]),
+		Polling:  dbInt(r["polling"]),
+	}
+}
+
+// SetNetFlow creates the NetFlow exporter and attaches it to the bridge
+// in a transaction, which replaces the old one.
+func SetNetFlow(bridge string, netflow NetFlow) (err error) {
+	return attachBridgeRecord(bridge, "netflow", "netflow", netflow.Targets, netflow.columns())
+}
+
+// DelNetFlow detaches the NetFlow exporter from the bridge.
+func DelNetFlow(bridge string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "clear", "bridge", bridge, "netflow")
+}
+
+// GetNetFlow returns the NetFlow exporter configuration of the bridge.
+//
+// If the bridge has no NetFlow exporter, ok is false.
+func GetNetFlow(bridge string) (netflow NetFlow, ok bool, err error) {
+	r, ok, err := getBridgeRecord(bridge, "netflow", "NetFlow",
+		"targets", "active_timeout", "engine_id", "engine_type")
+	if ok {
+		netflow = parseNetFlow(r)
+	}
+	return
+}
+
+func parseNetFlow(r dbRecord) NetFlow {
+	return NetFlow{
+		Targets:       dbStrings(r["targets"]),
+		ActiveTimeout: dbInt(r["active_timeout"]),
+		EngineID:      dbInt(r["engine_id"]),
+		EngineType:    dbInt(r["engine_type"]),
+	}
+}
+
+// SetIPFIX creates the IPFIX exporter and attaches it to the bridge
+// in a transaction, which replaces the old one.
+func SetIPFIX(bridge string, ipfix IPFIX) (err error) {
+	return attachBridgeRecord(bridge, "ipfix", "ipfix", ipfix.Targets, ipfix.columns())
+}
+
+// DelIPFIX detaches the IPFIX exporter from the bridge.
+func DelIPFIX(bridge string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "clear", "bridge", bridge, "ipfix")
+}
+
+// GetIPFIX returns the IPFIX exporter configuration of the bridge.
+//
+// If the bridge has no IPFIX exporter, ok is false.
+func GetIPFIX(bridge string) (ipfix IPFIX, ok bool, err error) {
+	r, ok, err := getBridgeRecord(bridge, "ipfix", "IPFIX", "targets", "sampling",
+		"obs_domain_id", "obs_point_id", "cache_active_timeout", "cache_max_flows")
+	if ok {
+		ipfix = parseIPFIX(r)
+	}
+	return
+}
+
+func parseIPFIX(r dbRecord) IPFIX {
+	return IPFIX{
+		Targets:            dbStrings(r["targets"]),
+		Sampling:           dbInt(r["sampling"]),
+		ObsDomainID:        dbInt(r["obs_domain_id"]),
+		ObsPointID:         dbInt(r["obs_point_id"]),
+		CacheActiveTimeout: dbInt(r["cache_active_timeout"]),
+		CacheMaxFlows:      dbInt(r["cache_max_flows"]),
+	}
+}
+
+// attachBridgeRecord creates a record in the table and sets it as the value
+// of the column of the bridge in a transaction.
+func attachBridgeRecord(bridge, column, table string, targets, columns []string) error {
+	if len(targets) == 0 {
+		return errors.New("the targets of the " + column + " exporter must not be empty")
+	}
+
+	args := make([]string, 0, len(columns)+10)
+	args = append(args, "--", "--id=@r", "create", table)
+	args = append(args, columns...)
+	args = append(args, "--", "set", "bridge", bridge, column+"=@r")
+	return exec.Execute(context.Background(), VsctlCmd, args...)
+}
+
+// getBridgeRecord returns the record in the table referred by the column
+// of the bridge.
+func getBridgeRecord(bridge, column, table string, columns ...string) (
+	record dbRecord, ok bool, err error) {
+	bridges, err := listDBRecords("Bridge", []string{column}, bridge)
+	if err != nil || len(bridges) == 0 {
+		return
+	}
+
+	uuid := dbString(bridges[0][column])
+	if uuid == "" {
+		return
+	}
+
+	records, err := listDBRecords(table, columns, uuid)
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	return records[0], true, nil
+}
+
+// quoteSet formats the strings as the set argument of ovs-vsctl,
+// each element of which is quoted.
+func quoteSet(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return dbSet(quoted)
+}
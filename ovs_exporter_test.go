@@ -0,0 +1,89 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuoteSet(t *testing.T) {
+	if s := quoteSet([]string{"10.0.0.1:6343", "10.0.0.2:6343"}); s != `["10.0.0.1:6343","10.0.0.2:6343"]` {
+		t.Errorf("unexpected set '%s'", s)
+	}
+	if s := quoteSet(nil); s != "[]" {
+		t.Errorf("unexpected empty set '%s'", s)
+	}
+}
+
+func TestExporterColumns(t *testing.T) {
+	sflow := SFlow{Targets: []string{"10.0.0.1:6343"}, Agent: "eth0", Sampling: 64, Header: 128, Polling: 10}
+	expect := []string{`targets=["10.0.0.1:6343"]`, `agent="eth0"`, "sampling=64", "header=128", "polling=10"}
+	if columns := sflow.columns(); !reflect.DeepEqual(columns, expect) {
+		t.Errorf("expect sflow columns %v, but got %v", expect, columns)
+	}
+
+	sflow = SFlow{Targets: []string{"10.0.0.1:6343"}}
+	if columns := sflow.columns(); !reflect.DeepEqual(columns, []string{`targets=["10.0.0.1:6343"]`}) {
+		t.Errorf("unexpected sflow columns %v", columns)
+	}
+
+	netflow := NetFlow{Targets: []string{"10.0.0.1:2055"}, ActiveTimeout: -1, EngineID: 1, EngineType: 2}
+	expect = []string{`targets=["10.0.0.1:2055"]`, "active_timeout=-1", "engine_id=1", "engine_type=2"}
+	if columns := netflow.columns(); !reflect.DeepEqual(columns, expect) {
+		t.Errorf("expect netflow columns %v, but got %v", expect, columns)
+	}
+
+	ipfix := IPFIX{Targets: []string{"10.0.0.1:4739"}, Sampling: 100, ObsDomainID: 1,
+		ObsPointID: 2, CacheActiveTimeout: 60, CacheMaxFlows: 1000}
+	expect = []string{`targets=["10.0.0.1:4739"]`, "sampling=100", "obs_domain_id=1",
+		"obs_point_id=2", "cache_active_timeout=60", "cache_max_flows=1000"}
+	if columns := ipfix.columns(); !reflect.DeepEqual(columns, expect) {
+		t.Errorf("expect ipfix columns %v, but got %v", expect, columns)
+	}
+}
+
+func TestParseExporters(t *testing.T) {
+	records, err := parseDBRecords(`{"data":[[["set",["10.0.0.1:6343","10.0.0.2:6343"]],` +
+		`"eth0",64,["set",[]],10]],"headings":["targets","agent","sampling","header","polling"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSFlow := SFlow{Targets: []string{"10.0.0.1:6343", "10.0.0.2:6343"}, Agent: "eth0", Sampling: 64, Polling: 10}
+	if sflow := parseSFlow(records[0]); !reflect.DeepEqual(sflow, expectSFlow) {
+		t.Errorf("expect sflow %+v, but got %+v", expectSFlow, sflow)
+	}
+
+	records, err = parseDBRecords(`{"data":[["10.0.0.1:2055",60,["set",[]],["set",[]]]],` +
+		`"headings":["targets","active_timeout","engine_id","engine_type"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNetFlow := NetFlow{Targets: []string{"10.0.0.1:2055"}, ActiveTimeout: 60}
+	if netflow := parseNetFlow(records[0]); !reflect.DeepEqual(netflow, expectNetFlow) {
+		t.Errorf("expect netflow %+v, but got %+v", expectNetFlow, netflow)
+	}
+
+	records, err = parseDBRecords(`{"data":[[["set",["10.0.0.1:4739"]],100,1,2,["set",[]],1000]],` +
+		`"headings":["targets","sampling","obs_domain_id","obs_point_id","cache_active_timeout","cache_max_flows"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIPFIX := IPFIX{Targets: []string{"10.0.0.1:4739"}, Sampling: 100, ObsDomainID: 1,
+		ObsPointID: 2, CacheMaxFlows: 1000}
+	if ipfix := parseIPFIX(records[0]); !reflect.DeepEqual(ipfix, expectIPFIX) {
+		t.Errorf("expect ipfix %+v, but got %+v", expectIPFIX, ipfix)
+	}
+}
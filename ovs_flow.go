@@ -19,7 +19,8 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/netip"
+	"net"
+	"os"
 	"strconv"
 	"strings"
 
@@ -44,6 +45,133 @@ func IntToString(i int) string { return fmt.Sprintf("%d", i) }
 // with the prefix "0x".
 func IntToHexString(i int) string { return fmt.Sprintf("0x%x", i) }
 
+// Flow is an OpenFlow flow, which may be formatted as the argument of AddFlows.
+type Flow struct {
+	Cookie   uint64
+	Table    uint8
+	Priority int
+	Match    string // Such as "ip,nw_src=10.0.0.0/8"
+	Actions  string // Such as "goto_table:1"
+}
+
+// String formats the flow as the string, such as
+// "cookie=0x1,table=0,priority=100,ip,nw_src=10.0.0.0/8,actions=goto_table:1".
+//
+// If Cookie is equal to 0, it is omitted.
+func (f Flow) String() string {
+	var b strings.Builder
+	if f.Cookie != 0 {
+		fmt.Fprintf(&b, "cookie=0x%x,", f.Cookie)
+	}
+	fmt.Fprintf(&b, "table=%d,priority=%d,", f.Table, f.Priority)
+	if f.Match != "" {
+		b.WriteString(f.Match)
+		b.WriteByte(',')
+	}
+	b.WriteString("actions=")
+	b.WriteString(f.Actions)
+	return b.String()
+}
+
+// JoinMatches joins the non-empty matches with the comma, such as
+// JoinMatches("reg7=1", "", "ip,nw_dst=10.0.0.0/8") => "reg7=1,ip,nw_dst=10.0.0.0/8".
+func JoinMatches(matches ...string) string {
+	ms := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if m != "" {
+			ms = append(ms, m)
+		}
+	}
+	return strings.Join(ms, ",")
+}
+
+// ParseMAC parses the 48-bit MAC address and returns its canonical form,
+// such as "FA:16:3E:0:0:1" => "fa:16:3e:00:00:01".
+func ParseMAC(mac string) (string, error) {
+	if s := normalizeMac(mac); s != "" {
+		return s, nil
+	}
+
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil || len(hwaddr) != 6 {
+		return "", fmt.Errorf("invalid mac '%s'", mac)
+	}
+	return hwaddr.String(), nil
+}
+
+// ParseFlow parses the flow string, such as the argument of AddFlows
+// or a line of the output of "ovs-ofctl dump-flows", like
+// " cookie=0x1, duration=5.1s, table=0, n_packets=0, n_bytes=0, priority=100,ip actions=drop".
+//
+// The statistics fields, such as duration, n_packets, n_bytes, idle_age
+// and hard_age, are discarded. If the flow has no priority, it is 32768.
+func ParseFlow(s string) (flow Flow, err error) {
+	s = strings.TrimSpace(s)
+	index := findActions(s)
+	if index < 0 {
+		return flow, fmt.Errorf("missing the actions in the flow '%s'", s)
+	}
+
+	flow.Priority = 32768
+	flow.Actions = strings.TrimSpace(s[index+len("actions="):])
+	matches := make([]string, 0, 8)
+	for _, field := range splitArgs(strings.Replace(s[:index], ", ", ",", -1), ',') {
+		key, value := splitKeyValue(field)
+		switch key {
+		case "cookie":
+			if flow.Cookie, err = strconv.ParseUint(strings.SplitN(value, "/", 2)[0], 0, 64); err != nil {
+				return flow, fmt.Errorf("invalid cookie in the flow '%s'", s)
+			}
+
+		case "table":
+			var table uint64
+			if table, err = strconv.ParseUint(value, 10, 8); err != nil {
+				return flow, fmt.Errorf("invalid table in the flow '%s'", s)
+			}
+			flow.Table = uint8(table)
+
+		case "priority":
+			if flow.Priority, err = strconv.Atoi(value); err != nil {
+				return flow, fmt.Errorf("invalid priority in the flow '%s'", s)
+			}
+
+		case "duration", "n_packets", "n_bytes", "idle_age", "hard_age",
+			"packet_count", "byte_count":
+
+		default:
+			matches = append(matches, field)
+		}
+	}
+
+	flow.Match = strings.Join(matches, ",")
+	return
+}
+
+// findActions returns the index of "actions=" not enclosed
+// in the parentheses, or -1 if not found.
+func findActions(s string) int {
+	var depth int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case 'a':
+			if depth == 0 && strings.HasPrefix(s[i:], "actions=") &&
+				(i == 0 || s[i-1] == ',' || s[i-1] == ' ') {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// SplitActions splits the actions by the commas not enclosed
+// in the parentheses, such as "ct(commit,zone=1),output:1"
+// to ["ct(commit,zone=1)", "output:1"].
+func SplitActions(actions string) []string { return splitArgs(actions, ',') }
+
 // GetAllFlows returns the list of all the flows of the bridge.
 func GetAllFlows(bridge string, isName, isStats bool) (flows []string, err error) {
 	var out string
@@ -102,6 +230,60 @@ func DelFlowsStrict(bridge string, priority int, matches ...string) (err error)
 	return
 }
 
+// BundleFlows executes the flow modifications in a bundle atomically,
+// that's, all of them are committed, or none of them is.
+//
+// Each modification is a line of the flow file of "ovs-ofctl add-flows",
+// which starts with "add", "modify", "modify_strict", "delete"
+// or "delete_strict", such as "add table=0,priority=0,actions=drop"
+// or "delete cookie=0x1/-1".
+//
+// Notice: the bridge must support OpenFlow 1.4 or later.
+func BundleFlows(bridge string, mods ...string) (err error) {
+	if len(mods) == 0 {
+		return
+	}
+
+	file, err := os.CreateTemp("", "ovs-flows-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(strings.Join(mods, "\n") + "\n")
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	return exec.Execute(context.Background(), OfctlCmd, "--bundle", "add-flows", bridge, file.Name())
+}
+
+// ReplaceFlowsByCookie deletes all the flows with the cookie and adds
+// the new flows in a bundle atomically.
+//
+// Notice: the flows should contain the same cookie, or they cannot be
+// replaced or deleted by the cookie later.
+func ReplaceFlowsByCookie(bridge string, cookie uint64, flows ...string) (err error) {
+	mods := make([]string, 0, len(flows)+1)
+	mods = append(mods, "delete "+cookieMatch(cookie))
+	for _, flow := range flows {
+		mods = append(mods, "add "+flow)
+	}
+	return BundleFlows(bridge, mods...)
+}
+
+// DelFlowsByCookie deletes all the flows with the cookie.
+func DelFlowsByCookie(bridge string, cookie uint64) (err error) {
+	return DelFlows(bridge, cookieMatch(cookie))
+}
+
+func cookieMatch(cookie uint64) string {
+	return fmt.Sprintf("cookie=0x%x/-1", cookie)
+}
+
 // MustAddFlow is the same as AddFlows, but the program exits if there is an error.
 func MustAddFlow(bridge, flow string) {
 	if err := AddFlows(bridge, flow); err != nil {
@@ -118,6 +300,15 @@ func MustDelFlow(bridge, match string) {
 	}
 }
 
+// MustReplaceFlowsByCookie is the same as ReplaceFlowsByCookie,
+// but the program exits if there is an error.
+func MustReplaceFlowsByCookie(bridge string, cookie uint64, flows ...string) {
+	if err := ReplaceFlowsByCookie(bridge, cookie, flows...); err != nil {
+		log.Printf("fail to replace flows: bridge=%s, cookie=0x%x, err=%v", bridge, cookie, err)
+		atexit.Exit(1)
+	}
+}
+
 // MustDelFlowStrict is the same as DelFlowsStrict, but the program exits if there is an error.
 func MustDelFlowStrict(bridge string, priority int, match string) {
 	if err := DelFlowsStrict(bridge, priority, match); err != nil {
@@ -141,13 +332,13 @@ func SendARPRequest(bridge, output, inPort, srcMac, srcIP, dstIP string,
 		return fmt.Errorf("invalid src mac '%s'", srcMac)
 	}
 
-	srcip, err := netip.ParseAddr(srcIP)
+	srcip, err := parseNeighborIP(srcIP, false)
 	if err != nil {
 		return
 	}
 	srcIP = fmt.Sprintf("%0x", srcip.As4())
 
-	dstip, err := netip.ParseAddr(dstIP)
+	dstip, err := parseNeighborIP(dstIP, false)
 	if err != nil {
 		return
 	}
@@ -178,3 +369,46 @@ func normalizeMac(mac string) string {
 
 	return strings.Join(macs, ":")
 }
+
+// splitArgs splits s by the separator sep, but ignores the separators
+// enclosed in the parentheses or brackets.
+func splitArgs(s string, sep byte) []string {
+	var args []string
+	var depth, start int
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			if arg := strings.TrimSpace(s[start:i]); arg != "" {
+				args = append(args, arg)
+			}
+			start = i + 1
+		}
+	}
+
+	if arg := strings.TrimSpace(s[start:]); arg != "" {
+		args = append(args, arg)
+	}
+	return args
+}
+
+// splitKeyValue splits "key=value" into key and value.
+//
+// If there is no "=", value is empty.
+func splitKeyValue(s string) (key, value string) {
+	if index := strings.IndexByte(s, '='); index > -1 {
+		return s[:index], s[index+1:]
+	}
+	return s, ""
+}
+
+// trimParens removes the outermost parentheses of s if exists.
+func trimParens(s string) string {
+	if len(s) >= 2 && s[0] == '(' && s[len(s)-1] == ')' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import "fmt"
+
+func ExampleParseFlow() {
+	flow, _ := ParseFlow(" cookie=0x10, duration=1.5s, table=10, n_packets=0, n_bytes=0, " +
+		"priority=100,ip,nw_dst=10.0.0.0/24 actions=ct(commit,exec(load:0x1->NXM_NX_CT_MARK[])),output:2")
+	fmt.Println(flow.Cookie, flow.Table, flow.Priority)
+	fmt.Println(flow.Match)
+	for _, action := range SplitActions(flow.Actions) {
+		fmt.Println(action)
+	}
+
+	flow, _ = ParseFlow("in_port=1,actions=drop")
+	fmt.Println(flow.Priority)
+
+	// Output:
+	// 16 10 100
+	// ip,nw_dst=10.0.0.0/24
+	// ct(commit,exec(load:0x1->NXM_NX_CT_MARK[]))
+	// output:2
+	// 32768
+}
+
+func ExampleJoinMatches() {
+	fmt.Println(JoinMatches("reg7=1", "", "ip,nw_dst=10.0.0.0/8"))
+	fmt.Println(JoinMatches("", ""))
+
+	// Output:
+	// reg7=1,ip,nw_dst=10.0.0.0/8
+	//
+}
+
+func ExampleParseMAC() {
+	fmt.Println(ParseMAC("FA:16:3E:0:0:1"))
+	fmt.Println(ParseMAC("fa-16-3e-00-00-02"))
+	fmt.Println(ParseMAC("fa:16:3e:00:00:00:01"))
+
+	// Output:
+	// fa:16:3e:00:00:01 <nil>
+	// fa:16:3e:00:00:02 <nil>
+	//  invalid mac 'fa:16:3e:00:00:00:01'
+}
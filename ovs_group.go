@@ -0,0 +1,350 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-atexit"
+	"github.com/xgfone/go-exec"
+)
+
+// Pre-define some group types.
+const (
+	GroupTypeAll          = "all"
+	GroupTypeSelect       = "select"
+	GroupTypeIndirect     = "indirect"
+	GroupTypeFastFailover = "ff"
+)
+
+// Pre-define some selection methods of the select group.
+const (
+	SelectionMethodHash   = "hash"
+	SelectionMethodDPHash = "dp_hash"
+)
+
+// GroupBucket is a bucket of the group.
+type GroupBucket struct {
+	Weight    int    // Only for the select group, and 0 means the default.
+	WatchPort string // Only for the fast failover group, such as "1" or "vm1".
+	Actions   string // Such as "output:1"
+}
+
+// String formats the bucket, such as "weight:100,actions=output:1".
+func (b GroupBucket) String() string {
+	var s strings.Builder
+	if b.Weight > 0 {
+		fmt.Fprintf(&s, "weight:%d,", b.Weight)
+	}
+	if b.WatchPort != "" {
+		fmt.Fprintf(&s, "watch_port:%s,", b.WatchPort)
+	}
+	s.WriteString("actions=")
+	s.WriteString(b.Actions)
+	return s.String()
+}
+
+// Group is an OpenFlow group.
+type Group struct {
+	ID   uint32
+	Type string // Such as GroupTypeSelect
+
+	// SelectionMethod and Fields are only used by the select group,
+	// which require OpenFlow 1.5.
+	SelectionMethod string   // Such as SelectionMethodHash
+	Fields          []string // Such as "ip_src", "ip_dst", "tcp_dst"
+
+	Buckets []GroupBucket
+}
+
+// String formats the group as the argument of "ovs-ofctl add-group", like
+// "group_id=1,type=select,selection_method=hash,fields(ip_src),bucket=actions=output:1".
+func (g Group) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "group_id=%d,type=%s", g.ID, g.Type)
+	if g.SelectionMethod != "" {
+		b.WriteString(",selection_method=")
+		b.WriteString(g.SelectionMethod)
+	}
+	if len(g.Fields) > 0 {
+		fmt.Fprintf(&b, ",fields(%s)", strings.Join(g.Fields, ","))
+	}
+	for _, bucket := range g.Buckets {
+		b.WriteString(",bucket=")
+		b.WriteString(bucket.String())
+	}
+	return b.String()
+}
+
+// protocol returns the OpenFlow versions used by the commands of the group,
+// which is OfctlProtocol15 only if the selection method or fields is set.
+func (g Group) protocol() string {
+	if g.SelectionMethod != "" || len(g.Fields) > 0 {
+		return OfctlProtocol15
+	}
+	return OfctlProtocol
+}
+
+func (g Group) validate() error {
+	switch g.Type {
+	case GroupTypeAll, GroupTypeSelect, GroupTypeIndirect, GroupTypeFastFailover:
+	default:
+		return fmt.Errorf("invalid group type '%s'", g.Type)
+	}
+
+	if g.Type == GroupTypeIndirect && len(g.Buckets) != 1 {
+		return fmt.Errorf("the indirect group must have only one bucket")
+	} else if g.Type != GroupTypeSelect && (g.SelectionMethod != "" || len(g.Fields) > 0) {
+		return fmt.Errorf("the selection method is only used by the select group")
+	}
+	return nil
+}
+
+// GroupBucketStats is the statistics of a group bucket.
+type GroupBucketStats struct {
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+// GroupStats is the statistics of a group.
+type GroupStats struct {
+	ID          uint32
+	Duration    float64 // The duration in seconds
+	RefCount    int     // The number of the flows or groups referring to the group.
+	PacketCount uint64
+	ByteCount   uint64
+	Buckets     []GroupBucketStats
+}
+
+func groupCommand(protocol, cmd, bridge string, args ...string) error {
+	args = append([]string{"-O", protocol, cmd, bridge}, args...)
+	return exec.Execute(context.Background(), OfctlCmd, args...)
+}
+
+// AddGroups adds the groups.
+func AddGroups(bridge string, groups ...Group) (err error) {
+	for _, group := range groups {
+		if err = group.validate(); err != nil {
+			return
+		} else if err = groupCommand(group.protocol(), "add-group", bridge, group.String()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ModGroups modifies the groups, which creates the group if not exist.
+func ModGroups(bridge string, groups ...Group) (err error) {
+	for _, group := range groups {
+		if err = group.validate(); err != nil {
+			return
+		} else if err = exec.Execute(context.Background(), OfctlCmd, "-O", group.protocol(),
+			"--may-create", "mod-group", bridge, group.String()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// DelGroups deletes the groups by the ids.
+//
+// If no ids, delete all the groups.
+func DelGroups(bridge string, ids ...uint32) (err error) {
+	if len(ids) == 0 {
+		return groupCommand(OfctlProtocol, "del-groups", bridge)
+	}
+
+	for _, id := range ids {
+		if err = groupCommand(OfctlProtocol, "del-groups", bridge, fmt.Sprintf("group_id=%d", id)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ListGroups returns all the groups of the bridge.
+func ListGroups(bridge string) (groups []Group, err error) {
+	out, err := exec.Output(context.Background(), OfctlCmd, "-O", OfctlProtocol, "dump-groups", bridge)
+	if err == nil {
+		groups, err = parseGroups(out)
+	}
+	return
+}
+
+// GetGroupStats returns the statistics of all the groups of the bridge.
+func GetGroupStats(bridge string) (stats []GroupStats, err error) {
+	out, err := exec.Output(context.Background(), OfctlCmd, "-O", OfctlProtocol, "dump-group-stats", bridge)
+	if err == nil {
+		stats, err = parseGroupStats(out)
+	}
+	return
+}
+
+// MustAddGroup is the same as AddGroups, but the program exits if there is an error.
+func MustAddGroup(bridge string, group Group) {
+	if err := AddGroups(bridge, group); err != nil {
+		log.Printf("fail to add group: bridge=%s, group=%s, err=%v", bridge, group, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustModGroup is the same as ModGroups, but the program exits if there is an error.
+func MustModGroup(bridge string, group Group) {
+	if err := ModGroups(bridge, group); err != nil {
+		log.Printf("fail to modify group: bridge=%s, group=%s, err=%v", bridge, group, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustDelGroup is the same as DelGroups, but the program exits if there is an error.
+func MustDelGroup(bridge string, id uint32) {
+	if err := DelGroups(bridge, id); err != nil {
+		log.Printf("fail to delete group: bridge=%s, id=%d, err=%v", bridge, id, err)
+		atexit.Exit(1)
+	}
+}
+
+// isReplyHeader reports whether the line is the header of the reply,
+// such as "OFPST_GROUP_DESC reply (OF1.5) (xid=0x2):".
+func isReplyHeader(line string) bool {
+	return strings.Contains(line, " reply ") && strings.HasSuffix(line, ":")
+}
+
+// parseGroups parses the output of "ovs-ofctl dump-groups".
+func parseGroups(out string) (groups []Group, err error) {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line == "" || isReplyHeader(line) {
+			continue
+		}
+
+		group, err := parseGroup(line)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return
+}
+
+func parseGroup(s string) (group Group, err error) {
+	parts := strings.Split(s, ",bucket=")
+	for _, arg := range splitArgs(parts[0], ',') {
+		key, value := splitKeyValue(arg)
+		switch {
+		case key == "group_id":
+			var id uint64
+			if id, err = strconv.ParseUint(value, 0, 32); err != nil {
+				return group, fmt.Errorf("invalid group '%s'", s)
+			}
+			group.ID = uint32(id)
+
+		case key == "type":
+			if value == "fast_failover" {
+				value = GroupTypeFastFailover
+			}
+			group.Type = value
+
+		case key == "selection_method":
+			group.SelectionMethod = value
+
+		case strings.HasPrefix(arg, "fields("):
+			group.Fields = splitArgs(trimParens(strings.TrimPrefix(arg, "fields")), ',')
+		}
+	}
+
+	for _, part := range parts[1:] {
+		var bucket GroupBucket
+		index := strings.Index(part, "actions=")
+		if index < 0 {
+			return group, fmt.Errorf("missing the bucket actions in the group '%s'", s)
+		}
+		bucket.Actions = part[index+len("actions="):]
+
+		for _, arg := range strings.Split(strings.TrimSuffix(part[:index], ","), ",") {
+			key, value, _ := strings.Cut(arg, ":")
+			switch key {
+			case "weight":
+				if bucket.Weight, err = strconv.Atoi(value); err != nil {
+					return group, fmt.Errorf("invalid bucket weight in the group '%s'", s)
+				}
+			case "watch_port":
+				bucket.WatchPort = value
+			}
+		}
+		group.Buckets = append(group.Buckets, bucket)
+	}
+
+	return
+}
+
+// parseGroupStats parses the output of "ovs-ofctl dump-group-stats".
+func parseGroupStats(out string) (stats []GroupStats, err error) {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line == "" || isReplyHeader(line) {
+			continue
+		}
+
+		var s GroupStats
+		var bucket *GroupBucketStats
+		for _, arg := range strings.Split(line, ",") {
+			if strings.HasPrefix(arg, "bucket") {
+				if _, arg, _ = strings.Cut(arg, ":"); arg == "" {
+					return nil, fmt.Errorf("invalid group stats '%s'", line)
+				}
+				s.Buckets = append(s.Buckets, GroupBucketStats{})
+				bucket = &s.Buckets[len(s.Buckets)-1]
+			}
+
+			key, value, _ := strings.Cut(arg, "=")
+			switch key {
+			case "group_id":
+				var id uint64
+				id, err = strconv.ParseUint(value, 0, 32)
+				s.ID = uint32(id)
+
+			case "duration":
+				s.Duration, err = strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64)
+
+			case "ref_count":
+				s.RefCount, err = strconv.Atoi(value)
+
+			case "packet_count":
+				if bucket != nil {
+					bucket.PacketCount, err = strconv.ParseUint(value, 10, 64)
+				} else {
+					s.PacketCount, err = strconv.ParseUint(value, 10, 64)
+				}
+
+			case "byte_count":
+				if bucket != nil {
+					bucket.ByteCount, err = strconv.ParseUint(value, 10, 64)
+				} else {
+					s.ByteCount, err = strconv.ParseUint(value, 10, 64)
+				}
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid group stats '%s'", line)
+			}
+		}
+
+		stats = append(stats, s)
+	}
+	return
+}
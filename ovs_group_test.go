@@ -0,0 +1,114 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupString(t *testing.T) {
+	group := Group{
+		ID:              1,
+		Type:            GroupTypeSelect,
+		SelectionMethod: SelectionMethodHash,
+		Fields:          []string{"ip_src", "tcp_dst"},
+		Buckets: []GroupBucket{
+			{Weight: 100, Actions: "output:1"},
+			{Weight: 50, Actions: "set_field:10.0.0.2->ip_dst,output:2"},
+		},
+	}
+
+	expect := "group_id=1,type=select,selection_method=hash,fields(ip_src,tcp_dst)," +
+		"bucket=weight:100,actions=output:1,bucket=weight:50,actions=set_field:10.0.0.2->ip_dst,output:2"
+	if s := group.String(); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+
+	if err := (Group{ID: 1, Type: GroupTypeIndirect}).validate(); err == nil {
+		t.Error("expect an error for the indirect group without bucket")
+	}
+}
+
+func TestGroupProtocol(t *testing.T) {
+	if p := (Group{ID: 1, Type: GroupTypeSelect}).protocol(); p != OfctlProtocol {
+		t.Errorf("expect protocol '%s', but got '%s'", OfctlProtocol, p)
+	}
+	if p := (Group{ID: 1, Type: GroupTypeSelect, SelectionMethod: SelectionMethodHash}).protocol(); p != OfctlProtocol15 {
+		t.Errorf("expect protocol '%s', but got '%s'", OfctlProtocol15, p)
+	}
+	if p := (Group{ID: 1, Type: GroupTypeSelect, Fields: []string{"ip_src"}}).protocol(); p != OfctlProtocol15 {
+		t.Errorf("expect protocol '%s', but got '%s'", OfctlProtocol15, p)
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	out := `OFPST_GROUP_DESC reply (OF1.5) (xid=0x2):
+ group_id=1,type=select,selection_method=hash,fields(ip_src,tcp_dst),bucket=bucket_id:0,weight:100,actions=output:1,bucket=bucket_id:1,weight:50,actions=set_field:10.0.0.2->ip_dst,output:2
+ group_id=2,type=ff,bucket=bucket_id:0,watch_port:1,actions=output:1
+`
+
+	groups, err := parseGroups(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []Group{
+		{
+			ID:              1,
+			Type:            GroupTypeSelect,
+			SelectionMethod: SelectionMethodHash,
+			Fields:          []string{"ip_src", "tcp_dst"},
+			Buckets: []GroupBucket{
+				{Weight: 100, Actions: "output:1"},
+				{Weight: 50, Actions: "set_field:10.0.0.2->ip_dst,output:2"},
+			},
+		},
+		{
+			ID:      2,
+			Type:    GroupTypeFastFailover,
+			Buckets: []GroupBucket{{WatchPort: "1", Actions: "output:1"}},
+		},
+	}
+	if !reflect.DeepEqual(groups, expects) {
+		t.Errorf("expect %+v, but got %+v", expects, groups)
+	}
+}
+
+func TestParseGroupStats(t *testing.T) {
+	out := `OFPST_GROUP reply (OF1.5) (xid=0x2):
+ group_id=1,duration=10.500s,ref_count=2,packet_count=30,byte_count=3000,bucket0:packet_count=10,byte_count=1000,bucket1:packet_count=20,byte_count=2000
+`
+
+	stats, err := parseGroupStats(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []GroupStats{{
+		ID:          1,
+		Duration:    10.5,
+		RefCount:    2,
+		PacketCount: 30,
+		ByteCount:   3000,
+		Buckets: []GroupBucketStats{
+			{PacketCount: 10, ByteCount: 1000},
+			{PacketCount: 20, ByteCount: 2000},
+		},
+	}}
+	if !reflect.DeepEqual(stats, expects) {
+		t.Errorf("expect %+v, but got %+v", expects, stats)
+	}
+}
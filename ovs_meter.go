@@ -0,0 +1,422 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-atexit"
+	"github.com/xgfone/go-exec"
+)
+
+// Pre-define the types of the meter band.
+const (
+	MeterBandDrop       = "drop"
+	MeterBandDSCPRemark = "dscp_remark"
+)
+
+// Pre-define the rate units of the meter.
+const (
+	MeterUnitKbps  = "kbps"
+	MeterUnitPktps = "pktps"
+)
+
+// MeterBand is a band of the meter.
+type MeterBand struct {
+	Type      string // MeterBandDrop or MeterBandDSCPRemark
+	Rate      uint32 // The rate in the unit of the meter
+	BurstSize uint32 // The burst size in kilobits or packets, 0 means no burst.
+	PrecLevel uint8  // The drop precedence level increased by dscp_remark
+}
+
+// String formats the band, such as "type=drop,rate=1000,burst_size=100".
+func (b MeterBand) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "type=%s,rate=%d", b.Type, b.Rate)
+	if b.BurstSize > 0 {
+		fmt.Fprintf(&s, ",burst_size=%d", b.BurstSize)
+	}
+	if b.Type == MeterBandDSCPRemark {
+		fmt.Fprintf(&s, ",prec_level=%d", b.PrecLevel)
+	}
+	return s.String()
+}
+
+// Meter is an OpenFlow meter, which is used by the flow action "meter:ID".
+type Meter struct {
+	ID    uint32
+	Unit  string // MeterUnitKbps or MeterUnitPktps, which is kbps by default.
+	Stats bool   // Whether to collect the statistics
+	Bands []MeterBand
+}
+
+// Burst reports whether any band of the meter has the burst size.
+func (m Meter) Burst() bool {
+	for _, band := range m.Bands {
+		if band.BurstSize > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the meter as the argument of "ovs-ofctl add-meter", like
+// "meter=1,kbps,burst,stats,bands=type=drop,rate=1000,burst_size=100".
+func (m Meter) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "meter=%d,%s", m.ID, m.unit())
+	if m.Burst() {
+		b.WriteString(",burst")
+	}
+	if m.Stats {
+		b.WriteString(",stats")
+	}
+	b.WriteString(",bands=")
+	for i, band := range m.Bands {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(band.String())
+	}
+	return b.String()
+}
+
+func (m Meter) unit() string {
+	if m.Unit == "" {
+		return MeterUnitKbps
+	}
+	return m.Unit
+}
+
+func (m Meter) validate(features MeterFeatures) error {
+	switch {
+	case features.MaxMeter == 0:
+		return errors.New("the datapath does not support the meter")
+	case m.ID == 0 || m.ID > features.MaxMeter:
+		return fmt.Errorf("the meter id must be between 1 and %d", features.MaxMeter)
+	case m.Unit != "" && m.Unit != MeterUnitKbps && m.Unit != MeterUnitPktps:
+		return fmt.Errorf("invalid meter unit '%s'", m.Unit)
+	case len(m.Bands) == 0:
+		return errors.New("the meter has no bands")
+	case features.MaxBands > 0 && len(m.Bands) > features.MaxBands:
+		return fmt.Errorf("the meter has %d bands, which exceeds %d", len(m.Bands), features.MaxBands)
+	case !containsString(features.Capabilities, m.unit()):
+		return fmt.Errorf("the datapath does not support the meter unit '%s'", m.unit())
+	case m.Burst() && !containsString(features.Capabilities, "burst"):
+		return errors.New("the datapath does not support the meter burst")
+	case m.Stats && !containsString(features.Capabilities, "stats"):
+		return errors.New("the datapath does not support the meter stats")
+	}
+
+	for _, band := range m.Bands {
+		if !containsString(features.BandTypes, band.Type) {
+			return fmt.Errorf("the datapath does not support the meter band type '%s'", band.Type)
+		} else if band.Rate == 0 {
+			return errors.New("the rate of the meter band must not be 0")
+		}
+	}
+
+	return nil
+}
+
+// MeterFeatures is the meter features supported by the datapath.
+type MeterFeatures struct {
+	MaxMeter     uint32   // 0 means that the datapath does not support the meter.
+	MaxBands     int      // The max number of the bands per meter.
+	MaxColor     int      // The max color value.
+	BandTypes    []string // Such as "drop", "dscp_remark"
+	Capabilities []string // Such as "kbps", "pktps", "burst", "stats"
+}
+
+// MeterBandStats is the statistics of a meter band.
+type MeterBandStats struct {
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+// MeterStats is the statistics of a meter.
+type MeterStats struct {
+	ID            uint32
+	FlowCount     int // The number of the flows using the meter.
+	PacketInCount uint64
+	ByteInCount   uint64
+	Duration      float64 // The duration in seconds
+	Bands         []MeterBandStats
+}
+
+func meterOutput(cmd, bridge string) (string, error) {
+	return exec.Output(context.Background(), OfctlCmd, "-O", OfctlProtocol, cmd, bridge)
+}
+
+// GetMeterFeatures returns the meter features supported by the datapath
+// of the bridge.
+func GetMeterFeatures(bridge string) (features MeterFeatures, err error) {
+	out, err := meterOutput("meter-features", bridge)
+	if err == nil {
+		features, err = parseMeterFeatures(out)
+	}
+	return
+}
+
+func setMeter(cmd, bridge string, meter Meter) (err error) {
+	features, err := GetMeterFeatures(bridge)
+	if err != nil {
+		return
+	} else if err = meter.validate(features); err != nil {
+		return
+	}
+
+	return exec.Execute(context.Background(), OfctlCmd, "-O", OfctlProtocol, cmd, bridge, meter.String())
+}
+
+// AddMeter adds the meter after checking it by the meter features
+// of the datapath.
+func AddMeter(bridge string, meter Meter) (err error) {
+	return setMeter("add-meter", bridge, meter)
+}
+
+// ModMeter modifies the meter after checking it by the meter features
+// of the datapath.
+func ModMeter(bridge string, meter Meter) (err error) {
+	return setMeter("mod-meter", bridge, meter)
+}
+
+// DelMeters deletes the meters by the ids.
+//
+// If no ids, delete all the meters.
+func DelMeters(bridge string, ids ...uint32) (err error) {
+	if len(ids) == 0 {
+		return exec.Execute(context.Background(), OfctlCmd, "-O", OfctlProtocol, "del-meters", bridge)
+	}
+
+	for _, id := range ids {
+		err = exec.Execute(context.Background(), OfctlCmd, "-O", OfctlProtocol,
+			"del-meters", bridge, fmt.Sprintf("meter=%d", id))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// DumpMeters returns all the meters of the bridge.
+func DumpMeters(bridge string) (meters []Meter, err error) {
+	out, err := meterOutput("dump-meters", bridge)
+	if err == nil {
+		meters, err = parseMeters(out)
+	}
+	return
+}
+
+// GetMeterStats returns the statistics of all the meters of the bridge.
+func GetMeterStats(bridge string) (stats []MeterStats, err error) {
+	out, err := meterOutput("meter-stats", bridge)
+	if err == nil {
+		stats, err = parseMeterStats(out)
+	}
+	return
+}
+
+// MustAddMeter is the same as AddMeter, but the program exits if there is an error.
+func MustAddMeter(bridge string, meter Meter) {
+	if err := AddMeter(bridge, meter); err != nil {
+		log.Printf("fail to add meter: bridge=%s, meter=%s, err=%v", bridge, meter, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustDelMeter is the same as DelMeters, but the program exits if there is an error.
+func MustDelMeter(bridge string, id uint32) {
+	if err := DelMeters(bridge, id); err != nil {
+		log.Printf("fail to delete meter: bridge=%s, id=%d, err=%v", bridge, id, err)
+		atexit.Exit(1)
+	}
+}
+
+// meterTokens splits the output of the meter commands into the tokens
+// separated by the whitespaces or commas, and removes the reply headers.
+func meterTokens(out string) (tokens []string) {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !isReplyHeader(line) {
+			tokens = append(tokens, strings.FieldsFunc(line, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == ','
+			})...)
+		}
+	}
+	return
+}
+
+func parseMeterUint(s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid meter value '%s'", s)
+	}
+	return v, nil
+}
+
+// parseMeters parses the output of "ovs-ofctl dump-meters", like
+//
+//	OFPST_METER_CONFIG reply (OF1.3) (xid=0x2):
+//	meter=1 kbps burst stats bands=
+//	type=drop rate=1000 burst_size=100
+func parseMeters(out string) (meters []Meter, err error) {
+	var meter *Meter
+	var band *MeterBand
+	for _, token := range meterTokens(out) {
+		key, value, _ := strings.Cut(token, "=")
+		switch key {
+		case "meter", "bands":
+		case "rate", "burst_size", "prec_level":
+			if band == nil {
+				return nil, fmt.Errorf("unexpected meter token '%s'", token)
+			}
+		default:
+			if meter == nil {
+				return nil, fmt.Errorf("unexpected meter token '%s'", token)
+			}
+		}
+
+		var v uint64
+		switch key {
+		case "meter":
+			v, err = parseMeterUint(value, 32)
+			meters = append(meters, Meter{ID: uint32(v)})
+			meter, band = &meters[len(meters)-1], nil
+		case MeterUnitKbps, MeterUnitPktps:
+			meter.Unit = key
+		case "stats":
+			meter.Stats = true
+		case "type":
+			meter.Bands = append(meter.Bands, MeterBand{Type: value})
+			band = &meter.Bands[len(meter.Bands)-1]
+		case "rate":
+			v, err = parseMeterUint(value, 32)
+			band.Rate = uint32(v)
+		case "burst_size":
+			v, err = parseMeterUint(value, 32)
+			band.BurstSize = uint32(v)
+		case "prec_level":
+			v, err = parseMeterUint(value, 8)
+			band.PrecLevel = uint8(v)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// parseMeterStats parses the output of "ovs-ofctl meter-stats", like
+//
+//	OFPST_METER reply (OF1.3) (xid=0x2):
+//	meter:1 flow_count:2 packet_in_count:10 byte_in_count:1000 duration:5.123s bands:
+//	0: packet_count:3 byte_count:300
+func parseMeterStats(out string) (stats []MeterStats, err error) {
+	var s *MeterStats
+	var band *MeterBandStats
+	for _, token := range meterTokens(out) {
+		key, value, _ := strings.Cut(token, ":")
+		if key != "meter" && s == nil {
+			return nil, fmt.Errorf("unexpected meter stats token '%s'", token)
+		}
+
+		var v uint64
+		switch key {
+		case "meter":
+			v, err = parseMeterUint(value, 32)
+			stats = append(stats, MeterStats{ID: uint32(v)})
+			s, band = &stats[len(stats)-1], nil
+		case "flow_count":
+			v, err = parseMeterUint(value, 32)
+			s.FlowCount = int(v)
+		case "packet_in_count":
+			s.PacketInCount, err = parseMeterUint(value, 64)
+		case "byte_in_count":
+			s.ByteInCount, err = parseMeterUint(value, 64)
+		case "duration":
+			if s.Duration, err = strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64); err != nil {
+				err = fmt.Errorf("invalid meter duration '%s'", value)
+			}
+		case "packet_count", "byte_count":
+			if band == nil {
+				return nil, fmt.Errorf("unexpected meter stats token '%s'", token)
+			} else if key == "packet_count" {
+				band.PacketCount, err = parseMeterUint(value, 64)
+			} else {
+				band.ByteCount, err = parseMeterUint(value, 64)
+			}
+		default:
+			if _, e := strconv.Atoi(key); e == nil && value == "" {
+				s.Bands = append(s.Bands, MeterBandStats{})
+				band = &s.Bands[len(s.Bands)-1]
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// parseMeterFeatures parses the output of "ovs-ofctl meter-features", like
+//
+//	OFPST_METER_FEATURES reply (OF1.3) (xid=0x2):
+//	max_meter:200000 max_bands:1 max_color:0
+//	band_types: drop
+//	capabilities: kbps pktps burst stats
+func parseMeterFeatures(out string) (features MeterFeatures, err error) {
+	var list *[]string
+	for _, token := range meterTokens(out) {
+		key, value, hasColon := strings.Cut(token, ":")
+		if !hasColon {
+			if list != nil {
+				*list = append(*list, token)
+			}
+			continue
+		}
+
+		var v uint64
+		list = nil
+		switch key {
+		case "max_meter":
+			v, err = parseMeterUint(value, 32)
+			features.MaxMeter = uint32(v)
+		case "max_bands":
+			v, err = parseMeterUint(value, 8)
+			features.MaxBands = int(v)
+		case "max_color":
+			v, err = parseMeterUint(value, 8)
+			features.MaxColor = int(v)
+		case "band_types":
+			list = &features.BandTypes
+		case "capabilities":
+			list = &features.Capabilities
+		}
+
+		if err != nil {
+			return
+		} else if list != nil && value != "" {
+			*list = append(*list, value)
+		}
+	}
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMeterString(t *testing.T) {
+	meter := Meter{ID: 1, Stats: true, Bands: []MeterBand{
+		{Type: MeterBandDrop, Rate: 1000, BurstSize: 100},
+		{Type: MeterBandDSCPRemark, Rate: 500, PrecLevel: 1},
+	}}
+
+	expect := "meter=1,kbps,burst,stats,bands=type=drop,rate=1000,burst_size=100," +
+		"type=dscp_remark,rate=500,prec_level=1"
+	if s := meter.String(); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+
+	features := MeterFeatures{
+		MaxMeter:     200000,
+		MaxBands:     1,
+		BandTypes:    []string{"drop"},
+		Capabilities: []string{"kbps", "pktps", "burst", "stats"},
+	}
+	if err := meter.validate(features); err == nil {
+		t.Error("expect an error for too many bands")
+	}
+
+	meter.Bands = meter.Bands[:1]
+	if err := meter.validate(features); err != nil {
+		t.Error(err)
+	}
+
+	if err := meter.validate(MeterFeatures{}); err == nil {
+		t.Error("expect an error for the datapath without meter")
+	}
+}
+
+func TestParseMeters(t *testing.T) {
+	out := `OFPST_METER_CONFIG reply (OF1.5) (xid=0x2):
+meter=1 kbps burst stats bands=
+type=drop rate=1000 burst_size=100
+
+meter=2 pktps bands=
+type=dscp_remark rate=10 prec_level=2
+`
+
+	meters, err := parseMeters(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []Meter{
+		{ID: 1, Unit: MeterUnitKbps, Stats: true, Bands: []MeterBand{
+			{Type: MeterBandDrop, Rate: 1000, BurstSize: 100},
+		}},
+		{ID: 2, Unit: MeterUnitPktps, Bands: []MeterBand{
+			{Type: MeterBandDSCPRemark, Rate: 10, PrecLevel: 2},
+		}},
+	}
+	if !reflect.DeepEqual(meters, expects) {
+		t.Errorf("expect %+v, but got %+v", expects, meters)
+	}
+}
+
+func TestParseMeterStats(t *testing.T) {
+	out := `OFPST_METER reply (OF1.5) (xid=0x2):
+meter:1 flow_count:2 packet_in_count:10 byte_in_count:1000 duration:5.500s bands:
+0: packet_count:3 byte_count:300
+1: packet_count:1 byte_count:100
+`
+
+	stats, err := parseMeterStats(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []MeterStats{{
+		ID:            1,
+		FlowCount:     2,
+		PacketInCount: 10,
+		ByteInCount:   1000,
+		Duration:      5.5,
+		Bands: []MeterBandStats{
+			{PacketCount: 3, ByteCount: 300},
+			{PacketCount: 1, ByteCount: 100},
+		},
+	}}
+	if !reflect.DeepEqual(stats, expects) {
+		t.Errorf("expect %+v, but got %+v", expects, stats)
+	}
+}
+
+func TestParseMeterFeatures(t *testing.T) {
+	out := `OFPST_METER_FEATURES reply (OF1.5) (xid=0x2):
+max_meter:200000 max_bands:1 max_color:0
+band_types: drop
+capabilities: kbps pktps burst stats
+`
+
+	features, err := parseMeterFeatures(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := MeterFeatures{
+		MaxMeter:     200000,
+		MaxBands:     1,
+		BandTypes:    []string{"drop"},
+		Capabilities: []string{"kbps", "pktps", "burst", "stats"},
+	}
+	if !reflect.DeepEqual(features, expect) {
+		t.Errorf("expect %+v, but got %+v", expect, features)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/xgfone/go-atexit"
+	"github.com/xgfone/go-exec"
+)
+
+// Mirror represents a port mirror on the bridge.
+//
+// Only one of OutputPort and OutputVLAN may be set. OutputPort is used
+// for SPAN, and OutputVLAN is used for RSPAN.
+type Mirror struct {
+	Name string
+
+	// If SelectAll is true, all the packets on the bridge are selected.
+	SelectAll      bool
+	SelectSrcPorts []string // The names of the ports
+	SelectDstPorts []string // The names of the ports
+	SelectVLANs    []int
+
+	OutputPort string // The name of the port
+	OutputVLAN int
+
+	// Statistics is only used by ListMirrors, which contains "tx_packets"
+	// and "tx_bytes".
+	Statistics map[string]int
+}
+
+func (m Mirror) validate() error {
+	if m.Name == "" {
+		return errors.New("the mirror name must not be empty")
+	}
+
+	switch {
+	case m.OutputPort == "" && m.OutputVLAN == 0:
+		return errors.New("one of the output port and vlan of the mirror must be set")
+	case m.OutputPort != "" && m.OutputVLAN != 0:
+		return errors.New("the output port and vlan of the mirror are exclusive")
+	case m.OutputVLAN < 0 || m.OutputVLAN > 4095:
+		return fmt.Errorf("invalid mirror output vlan %d", m.OutputVLAN)
+	}
+
+	for _, vlan := range m.SelectVLANs {
+		if vlan < 0 || vlan > 4095 {
+			return fmt.Errorf("invalid mirror select vlan %d", vlan)
+		}
+	}
+
+	return nil
+}
+
+// CreateMirror creates a mirror and adds it into the bridge in a transaction,
+// which resolves the port names to their uuids like ovs-vsctl.
+func CreateMirror(bridge string, mirror Mirror) (err error) {
+	args, err := createMirrorArgs(bridge, mirror)
+	if err != nil {
+		return
+	}
+	return exec.Execute(context.Background(), VsctlCmd, args...)
+}
+
+// createMirrorArgs returns the arguments of ovs-vsctl to create the mirror
+// and add it into the bridge.
+func createMirrorArgs(bridge string, mirror Mirror) (args []string, err error) {
+	if err = mirror.validate(); err != nil {
+		return
+	}
+
+	ids := make(map[string]string, 4)
+	portID := func(port string) string {
+		if id, ok := ids[port]; ok {
+			return id
+		}
+
+		id := fmt.Sprintf("@p%d", len(ids))
+		args = append(args, "--", "--id="+id, "get", "port", port)
+		ids[port] = id
+		return id
+	}
+
+	create := []string{"--", "--id=@m", "create", "mirror", "name=" + mirror.Name}
+	if mirror.SelectAll {
+		create = append(create, "select_all=true")
+	}
+	if len(mirror.SelectSrcPorts) > 0 {
+		ports := make([]string, len(mirror.SelectSrcPorts))
+		for i, port := range mirror.SelectSrcPorts {
+			ports[i] = portID(port)
+		}
+		create = append(create, "select_src_port="+dbSet(ports))
+	}
+	if len(mirror.SelectDstPorts) > 0 {
+		ports := make([]string, len(mirror.SelectDstPorts))
+		for i, port := range mirror.SelectDstPorts {
+			ports[i] = portID(port)
+		}
+		create = append(create, "select_dst_port="+dbSet(ports))
+	}
+	if len(mirror.SelectVLANs) > 0 {
+		vlans := make([]string, len(mirror.SelectVLANs))
+		for i, vlan := range mirror.SelectVLANs {
+			vlans[i] = strconv.FormatInt(int64(vlan), 10)
+		}
+		create = append(create, "select_vlan="+dbSet(vlans))
+	}
+	if mirror.OutputPort != "" {
+		create = append(create, "output_port="+portID(mirror.OutputPort))
+	} else {
+		create = append(create, fmt.Sprintf("output_vlan=%d", mirror.OutputVLAN))
+	}
+
+	args = append(args, create...)
+	args = append(args, "--", "add", "bridge", bridge, "mirrors", "@m")
+	return
+}
+
+// DeleteMirror deletes the mirror named name from the bridge.
+//
+// Notice: the mirror is destroyed by OVSDB automatically after removed
+// from the bridge.
+func DeleteMirror(bridge, name string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, deleteMirrorArgs(bridge, name)...)
+}
+
+// deleteMirrorArgs returns the arguments of ovs-vsctl to remove the mirror
+// named name from the bridge.
+func deleteMirrorArgs(bridge, name string) []string {
+	return []string{"--", "--id=@m", "get", "mirror", name,
+		"--", "remove", "bridge", bridge, "mirrors", "@m"}
+}
+
+// ClearMirrors deletes all the mirrors from the bridge.
+func ClearMirrors(bridge string) (err error) {
+	return exec.Execute(context.Background(), VsctlCmd, "clear", "bridge", bridge, "mirrors")
+}
+
+// ListMirrors returns all the mirrors with their statistics on the bridge.
+func ListMirrors(bridge string) (mirrors []Mirror, err error) {
+	bridges, err := listDBRecords("Bridge", []string{"mirrors"}, bridge)
+	if err != nil || len(bridges) == 0 {
+		return
+	}
+
+	uuids := dbStrings(bridges[0]["mirrors"])
+	if len(uuids) == 0 {
+		return
+	}
+
+	records, err := listDBRecords("Mirror", []string{"name", "select_all",
+		"select_src_port", "select_dst_port", "select_vlan",
+		"output_port", "output_vlan", "statistics"}, uuids...)
+	if err != nil {
+		return
+	}
+
+	ports, err := getPortNames()
+	if err == nil {
+		mirrors = parseMirrors(records, ports)
+	}
+	return
+}
+
+// parseMirrors parses the records of the Mirror table into the mirrors,
+// which converts the port uuids to the names by ports.
+func parseMirrors(records []dbRecord, ports map[string]string) (mirrors []Mirror) {
+	portNames := func(uuids []string) []string {
+		for i, uuid := range uuids {
+			uuids[i] = ports[uuid]
+		}
+		return uuids
+	}
+
+	mirrors = make([]Mirror, len(records))
+	for i, r := range records {
+		mirrors[i] = Mirror{
+			Name:           dbString(r["name"]),
+			SelectAll:      dbBool(r["select_all"]),
+			SelectSrcPorts: portNames(dbStrings(r["select_src_port"])),
+			SelectDstPorts: portNames(dbStrings(r["select_dst_port"])),
+			SelectVLANs:    dbInts(r["select_vlan"]),
+			OutputPort:     ports[dbString(r["output_port"])],
+			OutputVLAN:     dbInt(r["output_vlan"]),
+			Statistics:     dbIntMap(r["statistics"]),
+		}
+	}
+	return
+}
+
+// MustCreateMirror is the same as CreateMirror, but exit the program if failing.
+func MustCreateMirror(bridge string, mirror Mirror) {
+	if err := CreateMirror(bridge, mirror); err != nil {
+		log.Printf("fail to create the mirror: bridge=%s, mirror=%s, err=%v", bridge, mirror.Name, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustDeleteMirror is the same as DeleteMirror, but exit the program if failing.
+func MustDeleteMirror(bridge, name string) {
+	if err := DeleteMirror(bridge, name); err != nil {
+		log.Printf("fail to delete the mirror: bridge=%s, mirror=%s, err=%v", bridge, name, err)
+		atexit.Exit(1)
+	}
+}
+
+// MustListMirrors is the same as ListMirrors, but exit the program if failing.
+func MustListMirrors(bridge string) []Mirror {
+	mirrors, err := ListMirrors(bridge)
+	if err != nil {
+		log.Printf("fail to list the mirrors: bridge=%s, err=%v", bridge, err)
+		atexit.Exit(1)
+	}
+	return mirrors
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateMirrorArgs(t *testing.T) {
+	args, err := createMirrorArgs("br0", Mirror{
+		Name:           "m0",
+		SelectSrcPorts: []string{"vm1", "vm2"},
+		SelectDstPorts: []string{"vm1"},
+		SelectVLANs:    []int{10, 20},
+		OutputPort:     "span0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `-- --id=@p0 get port vm1 -- --id=@p1 get port vm2 -- --id=@p2 get port span0 ` +
+		`-- --id=@m create mirror name=m0 select_src_port=[@p0,@p1] select_dst_port=[@p0] ` +
+		`select_vlan=[10,20] output_port=@p2 -- add bridge br0 mirrors @m`
+	if s := strings.Join(args, " "); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+
+	args, err = createMirrorArgs("br0", Mirror{Name: "m1", SelectAll: true, OutputVLAN: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = `-- --id=@m create mirror name=m1 select_all=true output_vlan=100 -- add bridge br0 mirrors @m`
+	if s := strings.Join(args, " "); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+
+	for _, mirror := range []Mirror{
+		{OutputPort: "span0"},
+		{Name: "m0"},
+		{Name: "m0", OutputPort: "span0", OutputVLAN: 100},
+		{Name: "m0", OutputVLAN: 4096},
+		{Name: "m0", OutputPort: "span0", SelectVLANs: []int{-1}},
+	} {
+		if _, err = createMirrorArgs("br0", mirror); err == nil {
+			t.Errorf("expect an error for the mirror %+v", mirror)
+		}
+	}
+}
+
+func TestDeleteMirrorArgs(t *testing.T) {
+	expect := "-- --id=@m get mirror m0 -- remove bridge br0 mirrors @m"
+	if s := strings.Join(deleteMirrorArgs("br0", "m0"), " "); s != expect {
+		t.Errorf("expect '%s', but got '%s'", expect, s)
+	}
+}
+
+func TestParseMirrors(t *testing.T) {
+	records, err := parseDBRecords(`{"data":[["m0",false,` +
+		`["set",[["uuid","1f0c9a55-0000-0000-0000-000000000001"],["uuid","1f0c9a55-0000-0000-0000-000000000002"]]],` +
+		`["uuid","1f0c9a55-0000-0000-0000-000000000001"],["set",[10,20]],` +
+		`["uuid","1f0c9a55-0000-0000-0000-000000000003"],["set",[]],` +
+		`["map",[["tx_bytes",1024],["tx_packets",8]]]]],` +
+		`"headings":["name","select_all","select_src_port","select_dst_port","select_vlan",` +
+		`"output_port","output_vlan","statistics"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports := map[string]string{
+		"1f0c9a55-0000-0000-0000-000000000001": "vm1",
+		"1f0c9a55-0000-0000-0000-000000000002": "vm2",
+		"1f0c9a55-0000-0000-0000-000000000003": "span0",
+	}
+	expect := []Mirror{{
+		Name:           "m0",
+		SelectSrcPorts: []string{"vm1", "vm2"},
+		SelectDstPorts: []string{"vm1"},
+		SelectVLANs:    []int{10, 20},
+		OutputPort:     "span0",
+		Statistics:     map[string]int{"tx_bytes": 1024, "tx_packets": 8},
+	}}
+	if mirrors := parseMirrors(records, ports); !reflect.DeepEqual(mirrors, expect) {
+		t.Errorf("expect mirrors %+v, but got %+v", expect, mirrors)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pre-define the flow events reported by the flow monitor.
+const (
+	FlowEventInitial  = "INITIAL"
+	FlowEventAdded    = "ADDED"
+	FlowEventDeleted  = "DELETED"
+	FlowEventModified = "MODIFIED"
+)
+
+// WatchRestartInterval is the interval to restart the monitor subprocess
+// after it exits unexpectedly.
+var WatchRestartInterval = time.Second
+
+// FlowWatchFilter is the filter of the flows to be watched.
+type FlowWatchFilter struct {
+	// Match is used to filter the flows, which may contain "table=N" and
+	// "out_port=PORT", such as "table=0,ip" or "out_port=1".
+	//
+	// If empty, watch all the flows.
+	Match string
+
+	// If true, the existing flows are reported as FlowEventInitial
+	// when the monitor starts or restarts.
+	Initial bool
+}
+
+func (f FlowWatchFilter) spec() string {
+	specs := make([]string, 0, 2)
+	if !f.Initial {
+		specs = append(specs, "!initial")
+	}
+	if f.Match != "" {
+		specs = append(specs, f.Match)
+	}
+	return "watch:" + strings.Join(specs, ",")
+}
+
+// FlowEvent is the event of a flow reported by the flow monitor.
+type FlowEvent struct {
+	Event  string // Such as FlowEventAdded
+	Reason string // The reason of FlowEventDeleted, such as "delete", "idle", "hard"
+	Flow   Flow
+}
+
+// WatchFlows runs "ovs-ofctl monitor BRIDGE watch:" in the background
+// and reports the flow events by the returned channel.
+//
+// If the monitor subprocess exits unexpectedly, it will be restarted
+// after WatchRestartInterval. When ctx is done, the subprocess is killed
+// and the channel is closed.
+func WatchFlows(ctx context.Context, bridge string, filter FlowWatchFilter) (<-chan FlowEvent, error) {
+	events := make(chan FlowEvent, 64)
+	handle := func(line string) {
+		event, ok, err := parseFlowEvent(line)
+		if err != nil {
+			log.Printf("fail to parse the flow event: bridge=%s, err=%v", bridge, err)
+		} else if ok {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	args := []string{"monitor", bridge, filter.spec()}
+	if err := runMonitor(ctx, bridge, args, handle, func() { close(events) }); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// runMonitor runs the long-running ovs-ofctl command with the arguments
+// in the background, and passes each line of the output to handle.
+//
+// If the subprocess exits unexpectedly, it will be restarted after
+// WatchRestartInterval. When ctx is done, the subprocess is killed
+// and done is called.
+func runMonitor(ctx context.Context, bridge string, args []string,
+	handle func(line string), done func()) error {
+	cmd, scanner, err := startMonitor(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer done()
+		for {
+			for scanner.Scan() {
+				handle(scanner.Text())
+			}
+
+			err := cmd.Wait()
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				log.Printf("the %s monitor exits and restarts: bridge=%s, err=%v", OfctlCmd, bridge, err)
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(WatchRestartInterval):
+				}
+
+				if cmd, scanner, err = startMonitor(ctx, args); err == nil {
+					break
+				}
+				log.Printf("fail to restart the %s monitor: bridge=%s, err=%v", OfctlCmd, bridge, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func startMonitor(ctx context.Context, args []string) (*exec.Cmd, *bufio.Scanner, error) {
+	cmd := exec.CommandContext(ctx, OfctlCmd, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	} else if err = cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	return cmd, scanner, nil
+}
+
+// parseFlowEvent parses a line of the output of "ovs-ofctl monitor", like
+//
+//	event=ADDED table=0 cookie=0x10 priority=100,ip actions=drop
+//	event=DELETED reason=delete table=0 cookie=0x10 priority=100,ip actions=drop
+//
+// If the line is not a flow event, return false.
+func parseFlowEvent(line string) (event FlowEvent, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "event=") {
+		return
+	}
+
+loop:
+	for line != "" {
+		token, rest, _ := strings.Cut(line, " ")
+		key, value := splitKeyValue(token)
+		switch key {
+		case "event":
+			event.Event = value
+		case "reason":
+			event.Reason = value
+		case "table":
+			if table, err := strconv.ParseUint(value, 10, 8); err == nil {
+				event.Flow.Table = uint8(table)
+			}
+		case "cookie":
+			if event.Flow.Cookie, err = strconv.ParseUint(value, 0, 64); err != nil {
+				return event, false, fmt.Errorf("invalid cookie in the flow event '%s'", line)
+			}
+		case "idle_timeout", "hard_timeout":
+		default:
+			break loop
+		}
+		line = strings.TrimSpace(rest)
+	}
+
+	switch event.Event {
+	case FlowEventInitial, FlowEventAdded, FlowEventDeleted, FlowEventModified:
+	default: // Such as ABBREV
+		return event, false, nil
+	}
+
+	if findActions(line) < 0 {
+		line += " actions="
+	}
+
+	flow, err := ParseFlow(line)
+	if err != nil {
+		return
+	}
+
+	event.Flow.Priority = flow.Priority
+	event.Flow.Match = flow.Match
+	event.Flow.Actions = flow.Actions
+	return event, true, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import "testing"
+
+func TestParseFlowEvent(t *testing.T) {
+	tests := []struct {
+		Line  string
+		OK    bool
+		Event FlowEvent
+	}{
+		{Line: "NXST_FLOW_MONITOR reply (xid=0x0):"},
+		{Line: " event=ABBREV xid=0x1"},
+		{
+			Line: " event=ADDED table=10 cookie=0x10 priority=100,ip,nw_src=10.0.0.1 actions=ct(commit),output:1",
+			OK:   true,
+			Event: FlowEvent{Event: FlowEventAdded, Flow: Flow{Cookie: 0x10, Table: 10, Priority: 100,
+				Match: "ip,nw_src=10.0.0.1", Actions: "ct(commit),output:1"}},
+		},
+		{
+			Line: " event=DELETED reason=idle table=0 cookie=0 idle_timeout=10 in_port=1 actions=drop",
+			OK:   true,
+			Event: FlowEvent{Event: FlowEventDeleted, Reason: "idle", Flow: Flow{Priority: 32768,
+				Match: "in_port=1", Actions: "drop"}},
+		},
+		{
+			Line:  " event=MODIFIED table=1 cookie=0x1 priority=0",
+			OK:    true,
+			Event: FlowEvent{Event: FlowEventModified, Flow: Flow{Cookie: 1, Table: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		event, ok, err := parseFlowEvent(test.Line)
+		if err != nil {
+			t.Errorf("%s: %v", test.Line, err)
+		} else if ok != test.OK {
+			t.Errorf("%s: expect ok=%v, but got %v", test.Line, test.OK, ok)
+		} else if ok && event != test.Event {
+			t.Errorf("%s: expect %+v, but got %+v", test.Line, test.Event, event)
+		}
+	}
+
+	if s := (FlowWatchFilter{Match: "table=0,ip"}).spec(); s != "watch:!initial,table=0,ip" {
+		t.Errorf("unexpected watch spec '%s'", s)
+	}
+}
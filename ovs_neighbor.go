@@ -0,0 +1,194 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/xgfone/go-ovs/packet"
+)
+
+// NAFlag is the flag of the IPv6 neighbor advertisement.
+type NAFlag uint8
+
+// Pre-define the flags of the IPv6 neighbor advertisement.
+const (
+	NARouter NAFlag = 1 << iota
+	NASolicited
+	NAOverride
+)
+
+// SendGratuitousARP sends the gratuitous ARP request by the ovs bridge,
+// the sender and target IP of which are both srcIP, to announce
+// that srcIP is at srcMac.
+//
+// vlanID may be 0, which won't add the VLAN header into the packet.
+// If there are more than one VLAN IDs, they are the stacked VLAN tags
+// from the outermost to the innermost.
+func SendGratuitousARP(bridge, output, inPort, srcMac, srcIP string, vlanID ...uint16) (err error) {
+	mac, ip, err := parseNeighborSource(srcMac, srcIP, false)
+	if err != nil {
+		return
+	}
+
+	return sendNeighborPacket(bridge, output, inPort, packet.BroadcastMAC, mac, vlanID,
+		packet.ARP{Op: packet.ARPRequest, SenderMAC: mac, SenderIP: ip,
+			TargetMAC: packet.BroadcastMAC, TargetIP: ip})
+}
+
+// SendARPReply sends the ARP reply to dstMac and dstIP by the ovs bridge,
+// which tells that srcIP is at srcMac.
+//
+// vlanID is the same as SendGratuitousARP.
+func SendARPReply(bridge, output, inPort, srcMac, srcIP, dstMac, dstIP string,
+	vlanID ...uint16) (err error) {
+
+	smac, sip, err := parseNeighborSource(srcMac, srcIP, false)
+	if err != nil {
+		return
+	}
+
+	dmac, dip, err := parseNeighborSource(dstMac, dstIP, false)
+	if err != nil {
+		return
+	}
+
+	return sendNeighborPacket(bridge, output, inPort, dmac, smac, vlanID,
+		packet.ARP{Op: packet.ARPReply, SenderMAC: smac, SenderIP: sip,
+			TargetMAC: dmac, TargetIP: dip})
+}
+
+// SendNeighborSolicitation sends the IPv6 neighbor solicitation for dstIP
+// to its solicited-node multicast address by the ovs bridge.
+//
+// vlanID is the same as SendGratuitousARP.
+func SendNeighborSolicitation(bridge, output, inPort, srcMac, srcIP, dstIP string,
+	vlanID ...uint16) (err error) {
+
+	mac, sip, err := parseNeighborSource(srcMac, srcIP, true)
+	if err != nil {
+		return
+	}
+
+	target, err := parseNeighborIP(dstIP, true)
+	if err != nil {
+		return
+	}
+
+	// The solicited-node multicast address is ff02::1:ffXX:XXXX.
+	t := target.As16()
+	dip := netip.AddrFrom16([16]byte{0: 0xff, 1: 0x02, 11: 0x01, 12: 0xff, 13: t[13], 14: t[14], 15: t[15]})
+
+	return sendNeighborPacket(bridge, output, inPort, ipv6MulticastMAC(dip), mac, vlanID,
+		packet.IPv6{HopLimit: 255, Src: sip, Dst: dip},
+		packet.ICMPv6{},
+		packet.NeighborSolicitation{Target: target, SourceMAC: mac})
+}
+
+// SendNeighborAdvertisement sends the unsolicited IPv6 neighbor advertisement
+// to the all-nodes multicast address ff02::1 by the ovs bridge, which
+// announces that srcIP is at srcMac, such as the failover.
+//
+// flags is the combination of NARouter, NASolicited and NAOverride.
+// vlanID is the same as SendGratuitousARP.
+func SendNeighborAdvertisement(bridge, output, inPort, srcMac, srcIP string,
+	flags NAFlag, vlanID ...uint16) (err error) {
+
+	mac, ip, err := parseNeighborSource(srcMac, srcIP, true)
+	if err != nil {
+		return
+	}
+
+	dip := netip.IPv6LinkLocalAllNodes()
+	return sendNeighborPacket(bridge, output, inPort, ipv6MulticastMAC(dip), mac, vlanID,
+		packet.IPv6{HopLimit: 255, Src: ip, Dst: dip},
+		packet.ICMPv6{},
+		packet.NeighborAdvertisement{
+			Router:    flags&NARouter != 0,
+			Solicited: flags&NASolicited != 0,
+			Override:  flags&NAOverride != 0,
+			Target:    ip,
+			TargetMAC: mac,
+		})
+}
+
+func sendNeighborPacket(bridge, output, inPort string, dstMac, srcMac net.HardwareAddr,
+	vlanIDs []uint16, layers ...packet.Layer) (err error) {
+
+	eth := packet.Ethernet{Dst: dstMac, Src: srcMac}
+	if eth.VLANs, err = vlanTags(vlanIDs); err != nil {
+		return
+	}
+
+	data, err := packet.Encode(append([]packet.Layer{eth}, layers...)...)
+	if err != nil {
+		return
+	}
+
+	return SendPacketOut(bridge, PacketOut{InPort: inPort, Packet: data, Actions: []string{output}})
+}
+
+// vlanTags converts the VLAN ids to the VLAN tags, which ignores the VLAN id 0.
+//
+// If there are more than one tags, the outer tags use the 802.1ad TPID.
+func vlanTags(ids []uint16) (vlans []packet.VLAN, err error) {
+	for _, id := range ids {
+		if id > 4095 {
+			return nil, fmt.Errorf("invalid vlan id %d", id)
+		} else if id > 0 {
+			vlans = append(vlans, packet.VLAN{TPID: packet.EtherTypeQinQ, ID: id})
+		}
+	}
+
+	if len(vlans) > 0 {
+		vlans[len(vlans)-1].TPID = packet.EtherTypeVLAN
+	}
+	return
+}
+
+func parseNeighborSource(mac, ip string, isIPv6 bool) (hwaddr net.HardwareAddr, addr netip.Addr, err error) {
+	if hwaddr, err = net.ParseMAC(normalizeMac(mac)); err != nil || len(hwaddr) != 6 {
+		err = fmt.Errorf("invalid mac '%s'", mac)
+		return
+	}
+
+	addr, err = parseNeighborIP(ip, isIPv6)
+	return
+}
+
+func parseNeighborIP(ip string, isIPv6 bool) (addr netip.Addr, err error) {
+	if addr, err = netip.ParseAddr(ip); err != nil {
+		return
+	}
+
+	switch {
+	case addr.Zone() != "":
+		err = fmt.Errorf("the ip '%s' must not have the zone", ip)
+	case isIPv6 && (!addr.Is6() || addr.Is4In6()):
+		err = fmt.Errorf("the neighbor discovery requires an IPv6 address, but got '%s'", ip)
+	case !isIPv6 && !addr.Is4():
+		err = fmt.Errorf("the ARP requires an IPv4 address, but got '%s'", ip)
+	}
+	return
+}
+
+// ipv6MulticastMAC returns the MAC address of the IPv6 multicast address,
+// that's, 33:33 and the low 32 bits of the address.
+func ipv6MulticastMAC(ip netip.Addr) net.HardwareAddr {
+	b := ip.As16()
+	return net.HardwareAddr{0x33, 0x33, b[12], b[13], b[14], b[15]}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/xgfone/go-ovs/packet"
+)
+
+func TestNeighborValidation(t *testing.T) {
+	const mac = "0:0:0:0:0:1"
+	if err := SendARPRequest("br0", "output:1", "LOCAL", "0:0:0:0:1", "10.0.0.1", "10.0.0.2"); err == nil {
+		t.Error("expect an error for the invalid mac")
+	}
+	if err := SendARPRequest("br0", "output:1", "LOCAL", mac, "fd00::1", "10.0.0.2"); err == nil {
+		t.Error("expect an error for ARP with the IPv6 source")
+	}
+	if err := SendARPRequest("br0", "output:1", "LOCAL", mac, "10.0.0.1", "fd00::2"); err == nil {
+		t.Error("expect an error for ARP with the IPv6 destination")
+	}
+	if err := SendARPReply("br0", "output:1", "LOCAL", mac, "10.0.0.1", mac, "::ffff:10.0.0.2"); err == nil {
+		t.Error("expect an error for ARP with IPv4-mapped IPv6")
+	}
+	if err := SendGratuitousARP("br0", "output:1", "LOCAL", "00:00:00:00:00:01:02", "10.0.0.1"); err == nil {
+		t.Error("expect an error for the invalid mac")
+	}
+	if err := SendNeighborSolicitation("br0", "output:1", "LOCAL", mac, "fe80::1", "10.0.0.2"); err == nil {
+		t.Error("expect an error for ND with IPv4")
+	}
+	if err := SendNeighborAdvertisement("br0", "output:1", "LOCAL", mac, "10.0.0.1", NAOverride); err == nil {
+		t.Error("expect an error for ND with IPv4")
+	}
+	if err := SendGratuitousARP("br0", "output:1", "LOCAL", mac, "10.0.0.1", 4096); err == nil {
+		t.Error("expect an error for the invalid vlan id")
+	}
+}
+
+func TestVLANTags(t *testing.T) {
+	vlans, err := vlanTags([]uint16{100, 0, 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []packet.VLAN{
+		{TPID: packet.EtherTypeQinQ, ID: 100},
+		{TPID: packet.EtherTypeVLAN, ID: 200},
+	}
+	if !reflect.DeepEqual(vlans, expect) {
+		t.Errorf("expect %+v, but got %+v", expect, vlans)
+	}
+
+	mac := ipv6MulticastMAC(netip.MustParseAddr("ff02::1:ff00:2"))
+	if s := mac.String(); s != "33:33:ff:00:00:02" {
+		t.Errorf("unexpected multicast mac '%s'", s)
+	}
+}
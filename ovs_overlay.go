@@ -0,0 +1,314 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+	"reflect"
+	"sort"
+
+	"github.com/xgfone/go-exec"
+)
+
+// Pre-define some tunnel types of the overlay.
+const (
+	TunnelTypeVxLAN  = "vxlan"
+	TunnelTypeGeneve = "geneve"
+	TunnelTypeGRE    = "gre"
+)
+
+// DefaultOverlayPrefix is the default prefix of the tunnel port names of the overlay.
+const DefaultOverlayPrefix = "ovl"
+
+// OverlayFlowPeer is the remote IP of the flow-based tunnel port,
+// which is also its key in the result of Overlay.Ports.
+const OverlayFlowPeer = "flow"
+
+const (
+	overlayIDKey     = "overlay"
+	overlayBridgeKey = "overlay_bridge"
+	overlayRemoteKey = "overlay_remote_ip"
+)
+
+// Overlay manages the full-mesh tunnel ports from the local IP to the peers
+// in the bridge, which are tagged by the prefix and the bridge
+// in the external ids of the interfaces.
+//
+// In the point-to-point mode, there is one tunnel port per peer, the name of
+// which is "PREFIX-HASH", such as "ovl-1a2b3c4d", and HASH is the FNV-32a
+// hash of the bridge and the remote IP. In the flow-based mode,
+// there is only one tunnel port named "PREFIX-flow" with remote_ip=flow,
+// and the flows should set the field tun_dst to the remote IP.
+type Overlay struct {
+	Bridge    string
+	LocalIP   string
+	Type      string // TunnelTypeVxLAN by default
+	Prefix    string // At most 6 characters, DefaultOverlayPrefix by default.
+	FlowBased bool
+
+	// Options is the extra options of the tunnel interfaces,
+	// such as "dst_port" or "df_default".
+	Options map[string]string
+}
+
+func (o Overlay) prefix() string {
+	if o.Prefix == "" {
+		return DefaultOverlayPrefix
+	}
+	return o.Prefix
+}
+
+func (o Overlay) tunnelType() string {
+	if o.Type == "" {
+		return TunnelTypeVxLAN
+	}
+	return o.Type
+}
+
+// PortName returns the name of the tunnel port to the remote IP in the bridge,
+// which fits IFNAMSIZ.
+func (o Overlay) PortName(remoteIP string) string {
+	if addr, err := netip.ParseAddr(remoteIP); err == nil {
+		remoteIP = addr.String()
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(o.Bridge))
+	h.Write([]byte{0})
+	h.Write([]byte(remoteIP))
+	return fmt.Sprintf("%s-%08x", o.prefix(), h.Sum32())
+}
+
+// FlowPortName returns the name of the flow-based tunnel port.
+//
+// Notice: the name does not contain the bridge, so the flow-based overlays
+// in the different bridges must use the different prefixes.
+func (o Overlay) FlowPortName() string { return o.prefix() + "-flow" }
+
+func (o Overlay) validate(remoteIPs []string) (peers []string, err error) {
+	if o.Bridge == "" {
+		return nil, fmt.Errorf("missing the bridge of the overlay")
+	} else if len(o.prefix()) > 6 {
+		return nil, fmt.Errorf("the overlay prefix '%s' is too long", o.prefix())
+	}
+
+	local, err := netip.ParseAddr(o.LocalIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local ip '%s'", o.LocalIP)
+	}
+
+	seen := make(map[string]struct{}, len(remoteIPs))
+	peers = make([]string, 0, len(remoteIPs))
+	for _, ip := range remoteIPs {
+		remote, err := netip.ParseAddr(ip)
+		if err != nil || remote.Is4() != local.Is4() {
+			return nil, fmt.Errorf("invalid remote ip '%s'", ip)
+		} else if remote == local {
+			continue
+		} else if _, ok := seen[remote.String()]; !ok {
+			seen[remote.String()] = struct{}{}
+			peers = append(peers, remote.String())
+		}
+	}
+	return
+}
+
+// wantedPorts returns the mapping from the names of the tunnel ports
+// to the remote IPs of the peers.
+func (o Overlay) wantedPorts(peers []string) (wanted map[string]string, err error) {
+	wanted = make(map[string]string, len(peers))
+	if o.FlowBased {
+		wanted[o.FlowPortName()] = OverlayFlowPeer
+		return
+	}
+
+	for _, peer := range peers {
+		name := o.PortName(peer)
+		if other, ok := wanted[name]; ok {
+			return nil, fmt.Errorf("the tunnel ports of the peers '%s' and '%s' have the same name '%s'",
+				other, peer, name)
+		}
+		wanted[name] = peer
+	}
+	return
+}
+
+// options returns all the options of the tunnel interface to the remote IP.
+func (o Overlay) options(remoteIP string) map[string]string {
+	options := make(map[string]string, len(o.Options)+4)
+	for key, value := range o.Options {
+		options[key] = value
+	}
+	options["local_ip"] = o.LocalIP
+	options["remote_ip"] = remoteIP
+	options["in_key"] = "flow"
+	options["out_key"] = "flow"
+	return options
+}
+
+// portArgs returns the arguments of ovs-vsctl to add or update the tunnel port,
+// which replaces all the options of the interface.
+func (o Overlay) portArgs(name, remoteIP string) []string {
+	return []string{
+		"--may-exist", "add-port", o.Bridge, name,
+		"--", "set", "interface", name, "type=" + o.tunnelType(),
+		"options=" + dbMapArg(o.options(remoteIP)),
+		fmt.Sprintf("external_ids:%s=%s", overlayIDKey, o.prefix()),
+		fmt.Sprintf("external_ids:%s=%s", overlayBridgeKey, o.Bridge),
+		fmt.Sprintf("external_ids:%s=%s", overlayRemoteKey, remoteIP),
+	}
+}
+
+type overlayPort struct {
+	Name    string
+	Peer    string
+	OFPort  int
+	Type    string
+	Options map[string]string
+}
+
+func (o Overlay) listPorts() (ports []overlayPort, err error) {
+	records, err := listDBRecords("Interface", []string{"name", "type", "ofport", "options", "external_ids"})
+	if err == nil {
+		ports = o.parsePorts(records)
+	}
+	return
+}
+
+// parsePorts returns the tunnel ports of the overlay in the bridge
+// from the records of the Interface table.
+func (o Overlay) parsePorts(records []dbRecord) (ports []overlayPort) {
+	for _, r := range records {
+		ids := dbMap(r["external_ids"])
+		if ids[overlayIDKey] != o.prefix() || ids[overlayBridgeKey] != o.Bridge {
+			continue
+		}
+
+		ports = append(ports, overlayPort{
+			Name:    dbString(r["name"]),
+			Peer:    ids[overlayRemoteKey],
+			OFPort:  dbInt(r["ofport"]),
+			Type:    dbString(r["type"]),
+			Options: dbMap(r["options"]),
+		})
+	}
+	return
+}
+
+// isUpToDate reports whether the existing tunnel port has the same type,
+// options and peer as the wanted one to the remote IP.
+func (o Overlay) isUpToDate(port overlayPort, remoteIP string) bool {
+	return port.Type == o.tunnelType() && port.Peer == remoteIP &&
+		reflect.DeepEqual(port.Options, o.options(remoteIP))
+}
+
+// Ports returns the mapping from the remote IPs of the peers to the ofports
+// of the tunnel ports. In the flow-based mode, the key is OverlayFlowPeer.
+//
+// The ofport is -1 if the tunnel port fails to be created.
+func (o Overlay) Ports() (ports map[string]int, err error) {
+	list, err := o.listPorts()
+	if err != nil {
+		return
+	}
+
+	ports = make(map[string]int, len(list))
+	for _, port := range list {
+		ports[port.Peer] = port.OFPort
+	}
+	return
+}
+
+// Sync ensures that there is exactly one tunnel port per peer, or only one
+// flow-based tunnel port, and removes the tunnel ports of the departed peers.
+//
+// It returns the mapping from the remote IPs of the peers to the ofports.
+// In the flow-based mode, all the peers are mapped to the flow-based port.
+func (o Overlay) Sync(remoteIPs ...string) (ports map[string]int, err error) {
+	peers, err := o.validate(remoteIPs)
+	if err != nil {
+		return
+	}
+
+	wanted, err := o.wantedPorts(peers) // name -> remote ip
+	if err != nil {
+		return
+	}
+
+	existing, err := o.listPorts()
+	if err != nil {
+		return
+	}
+
+	current := make(map[string]overlayPort, len(existing))
+	for _, port := range existing {
+		if _, ok := wanted[port.Name]; !ok {
+			if err = exec.Execute(context.Background(), VsctlCmd, "--if-exists", "del-port", port.Name); err != nil {
+				return
+			}
+		} else {
+			current[port.Name] = port
+		}
+	}
+
+	names := make([]string, 0, len(wanted))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		remote := wanted[name]
+		if port, ok := current[name]; ok && o.isUpToDate(port, remote) {
+			continue
+		}
+
+		if err = exec.Execute(context.Background(), VsctlCmd, o.portArgs(name, remote)...); err != nil {
+			return
+		}
+	}
+
+	all, err := o.Ports()
+	if err != nil {
+		return
+	}
+
+	ports = make(map[string]int, len(peers))
+	for _, peer := range peers {
+		if o.FlowBased {
+			ports[peer] = all[OverlayFlowPeer]
+		} else {
+			ports[peer] = all[peer]
+		}
+	}
+	return
+}
+
+// Delete deletes all the tunnel ports of the overlay.
+func (o Overlay) Delete() (err error) {
+	ports, err := o.listPorts()
+	if err != nil {
+		return
+	}
+
+	for _, port := range ports {
+		if err = exec.Execute(context.Background(), VsctlCmd, "--if-exists", "del-port", port.Name); err != nil {
+			return
+		}
+	}
+	return
+}
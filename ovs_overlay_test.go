@@ -0,0 +1,127 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestOverlayPortName(t *testing.T) {
+	o := Overlay{Bridge: "br-tun", LocalIP: "192.168.0.1"}
+	name := o.PortName("192.168.0.2")
+	if len(name) != 12 || name[:4] != "ovl-" {
+		t.Errorf("unexpected port name '%s'", name)
+	} else if o.PortName("192.168.0.2") != name {
+		t.Error("the port name is not deterministic")
+	} else if o.PortName("192.168.0.3") == name {
+		t.Error("the port names of the different peers are the same")
+	} else if o.PortName("fd00:0::1") != o.PortName("fd00::1") {
+		t.Error("the port name does not use the canonical ip")
+	} else if (Overlay{Bridge: "br-int"}).PortName("192.168.0.2") == name {
+		t.Error("the port names of the different bridges are the same")
+	}
+
+	peers, err := o.validate([]string{"192.168.0.2", "192.168.0.1", "192.168.0.2"})
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(peers, []string{"192.168.0.2"}) {
+		t.Errorf("unexpected peers %v", peers)
+	}
+
+	if _, err = o.validate([]string{"fd00::1"}); err == nil {
+		t.Error("expect an error for the remote ip of the different family")
+	}
+
+	o.Prefix = "overlay"
+	if _, err = o.validate(nil); err == nil {
+		t.Error("expect an error for the too long prefix")
+	}
+}
+
+func TestOverlayPorts(t *testing.T) {
+	o := Overlay{Bridge: "br-tun", LocalIP: "192.168.0.1", Type: TunnelTypeGeneve,
+		Options: map[string]string{"dst_port": "6081"}}
+
+	expect := []string{
+		"--may-exist", "add-port", "br-tun", "ovl-flow",
+		"--", "set", "interface", "ovl-flow", "type=geneve",
+		`options={dst_port="6081",in_key="flow",local_ip="192.168.0.1",out_key="flow",remote_ip="flow"}`,
+		"external_ids:overlay=ovl", "external_ids:overlay_bridge=br-tun", "external_ids:overlay_remote_ip=flow",
+	}
+	if args := o.portArgs(o.FlowPortName(), OverlayFlowPeer); !reflect.DeepEqual(args, expect) {
+		t.Errorf("expect args %v, but got %v", expect, args)
+	}
+
+	records, err := parseDBRecords(`{"data":[
+["ovl-1a2b3c4d","geneve",5,["map",[["dst_port","6081"],["in_key","flow"],["local_ip","192.168.0.1"],["out_key","flow"],["remote_ip","192.168.0.2"]]],["map",[["overlay","ovl"],["overlay_bridge","br-tun"],["overlay_remote_ip","192.168.0.2"]]]],
+["ovl-5e6f7a8b","geneve",7,["map",[["local_ip","192.168.0.1"],["remote_ip","192.168.0.2"]]],["map",[["overlay","ovl"],["overlay_bridge","br-int"],["overlay_remote_ip","192.168.0.2"]]]],
+["tap0","",6,["map",[]],["map",[]]]
+],"headings":["name","type","ofport","options","external_ids"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports := o.parsePorts(records)
+	expectPorts := []overlayPort{{Name: "ovl-1a2b3c4d", Peer: "192.168.0.2", OFPort: 5, Type: "geneve",
+		Options: map[string]string{"dst_port": "6081", "in_key": "flow", "local_ip": "192.168.0.1",
+			"out_key": "flow", "remote_ip": "192.168.0.2"}}}
+	if !reflect.DeepEqual(ports, expectPorts) {
+		t.Fatalf("expect ports %+v, but got %+v", expectPorts, ports)
+	}
+
+	if !o.isUpToDate(ports[0], "192.168.0.2") {
+		t.Error("expect the port to be up to date")
+	}
+
+	o.Options = nil
+	if o.isUpToDate(ports[0], "192.168.0.2") {
+		t.Error("expect the port with the removed option to be out of date")
+	}
+}
+
+func TestOverlayWantedPorts(t *testing.T) {
+	o := Overlay{Bridge: "br-tun", LocalIP: "192.168.0.1"}
+	wanted, err := o.wantedPorts([]string{"192.168.0.2", "192.168.0.3"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(wanted) != 2 || wanted[o.PortName("192.168.0.3")] != "192.168.0.3" {
+		t.Errorf("unexpected wanted ports %v", wanted)
+	}
+
+	// Find two peers whose port names collide by the birthday paradox.
+	seen := make(map[string]string, 1<<17)
+	var peers []string
+	for i := 0; peers == nil; i++ {
+		peer := netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}).String()
+		name := o.PortName(peer)
+		if other, ok := seen[name]; ok {
+			peers = []string{other, peer}
+		}
+		seen[name] = peer
+	}
+
+	if _, err = o.wantedPorts(peers); err == nil {
+		t.Errorf("expect an error for the collided peers %v", peers)
+	}
+
+	o.FlowBased = true
+	if wanted, err = o.wantedPorts(peers); err != nil {
+		t.Error(err)
+	} else if len(wanted) != 1 || wanted[o.FlowPortName()] != OverlayFlowPeer {
+		t.Errorf("unexpected wanted ports %v", wanted)
+	}
+}
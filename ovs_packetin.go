@@ -0,0 +1,262 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-ovs/packet"
+)
+
+// Pre-define some reasons of the packet-in.
+const (
+	PacketInReasonAction     = "action"
+	PacketInReasonNoMatch    = "no_match"
+	PacketInReasonInvalidTTL = "invalid_ttl"
+)
+
+// PacketIn is a packet sent to the controller, for example,
+// by the action "controller" or "controller(userdata=01.02)".
+type PacketIn struct {
+	Table    uint8
+	Cookie   uint64
+	Reason   string // Such as PacketInReasonAction
+	InPort   int    // The reserved port is converted to the number, such as LOCAL to 65534.
+	TotalLen int
+
+	// Metadata is the pipeline fields of the packet,
+	// such as "reg0=0x1,in_port=1".
+	Metadata string
+
+	// UserData is the userdata of the action "controller".
+	UserData []byte
+
+	// Flow is the header fields of the packet,
+	// such as "arp,vlan_tci=0x0000,dl_src=...,arp_op=1".
+	Flow string
+
+	// Data is the raw ethernet packet, which may be decoded by packet.Decode.
+	Data []byte
+}
+
+// PacketInOptions is the options to listen the packet-in.
+type PacketInOptions struct {
+	// BufferSize is the capacity of the packet-in channel,
+	// which is 256 by default.
+	BufferSize int
+
+	// If DropIfFull is true, the packet-in is dropped when the channel
+	// is full, and OnDrop is called with it if set.
+	//
+	// Or, the reader blocks until the channel is not full, and the switch
+	// drops the packet-ins when the buffer of the connection is full.
+	DropIfFull bool
+	OnDrop     func(PacketIn)
+}
+
+// ListenPacketIn runs "ovs-ofctl monitor BRIDGE 65534" in the background
+// and delivers the packet-ins of the bridge by the returned channel.
+//
+// The monitor subprocess is restarted if it exits unexpectedly, and killed
+// when ctx is done, then the channel is closed.
+func ListenPacketIn(ctx context.Context, bridge string, opts PacketInOptions) (<-chan PacketIn, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 256
+	}
+
+	packets := make(chan PacketIn, opts.BufferSize)
+	parser := &packetInParser{handle: func(p PacketIn) {
+		if opts.DropIfFull {
+			select {
+			case packets <- p:
+			default:
+				if opts.OnDrop != nil {
+					opts.OnDrop(p)
+				}
+			}
+			return
+		}
+
+		select {
+		case packets <- p:
+		case <-ctx.Done():
+		}
+	}}
+
+	handle := func(line string) {
+		if err := parser.Parse(line); err != nil {
+			log.Printf("fail to parse the packet-in: bridge=%s, err=%v", bridge, err)
+		}
+	}
+
+	// Use "-mmm" to dump the packet data as hex.
+	args := []string{"--no-names", "-P", "nxt_packet_in", "-mmm", "monitor", bridge, "65534"}
+	if err := runMonitor(ctx, bridge, args, handle, func() { close(packets) }); err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+// packetInParser parses the packet-ins from the output of
+// "ovs-ofctl -P nxt_packet_in -mmm monitor BRIDGE 65534" line by line, like
+//
+//	NXT_PACKET_IN2 (xid=0x0): table_id=10 cookie=0x10 total_len=42 reg0=0x1,in_port=1 (via action) data_len=42 (unbuffered)
+//	 userdata=01.02
+//	arp,vlan_tci=0x0000,dl_src=00:00:00:00:00:01,dl_dst=ff:ff:ff:ff:ff:ff,arp_spa=10.0.0.1,...
+//	00000000  ff ff ff ff ff ff 00 00-00 00 00 01 08 06 00 01
+//	00000010  08 00 06 04 00 01 00 00-00 00 00 01 0a 00 00 01
+//	00000020  00 00 00 00 00 00 0a 00-00 02
+//
+// A packet-in is complete when all the data_len bytes have been read.
+type packetInParser struct {
+	handle  func(PacketIn)
+	packet  PacketIn
+	dataLen int
+	active  bool
+}
+
+func (p *packetInParser) Parse(line string) (err error) {
+	switch {
+	case strings.HasPrefix(line, "NXT_PACKET_IN") || strings.HasPrefix(line, "OFPT_PACKET_IN"):
+		p.active = false
+		if err = p.parseHeader(line); err != nil {
+			return
+		}
+		p.active = true
+
+	case !p.active, strings.TrimSpace(line) == "":
+		return
+
+	case isHexDumpLine(line):
+		var data []byte
+		if data, err = packet.ParseHex(line); err != nil {
+			p.active = false
+			return
+		}
+		p.packet.Data = append(p.packet.Data, data...)
+
+	case strings.HasPrefix(line, " "):
+		if err = p.parseFields(strings.Fields(line)); err != nil {
+			p.active = false
+			return
+		}
+
+	case p.packet.Flow == "":
+		p.packet.Flow = strings.TrimSpace(line)
+
+	default: // Other message
+		p.active = false
+		return
+	}
+
+	if len(p.packet.Data) >= p.dataLen {
+		p.active = false
+		p.handle(p.packet)
+	}
+	return
+}
+
+func (p *packetInParser) parseHeader(line string) (err error) {
+	index := strings.Index(line, "):")
+	if index < 0 {
+		return fmt.Errorf("invalid packet-in header '%s'", line)
+	}
+
+	p.packet, p.dataLen = PacketIn{}, 0
+	fields := strings.Fields(line[index+2:])
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == "(via" && i+1 < len(fields) {
+			p.packet.Reason = strings.TrimSuffix(fields[i+1], ")")
+			fields = append(fields[:i], fields[i+2:]...)
+			break
+		}
+	}
+
+	return p.parseFields(fields)
+}
+
+func (p *packetInParser) parseFields(fields []string) (err error) {
+	for _, field := range fields {
+		key, value := splitKeyValue(field)
+		switch key {
+		case "table_id":
+			var table uint64
+			table, err = strconv.ParseUint(value, 10, 8)
+			p.packet.Table = uint8(table)
+		case "cookie":
+			p.packet.Cookie, err = strconv.ParseUint(value, 0, 64)
+		case "total_len":
+			p.packet.TotalLen, err = strconv.Atoi(value)
+		case "data_len":
+			p.dataLen, err = strconv.Atoi(value)
+		case "userdata":
+			p.packet.UserData, err = hex.DecodeString(strings.ReplaceAll(value, ".", ""))
+		case "buffer", "continuation.bridge":
+		default:
+			if !strings.Contains(field, "=") || strings.HasPrefix(field, "continuation.") {
+				continue
+			}
+
+			p.packet.Metadata = field
+			for _, kv := range strings.Split(field, ",") {
+				if k, v := splitKeyValue(kv); k == "in_port" {
+					p.packet.InPort, err = parseOFPort(v)
+				}
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid packet-in field '%s'", field)
+		}
+	}
+	return
+}
+
+// reservedOFPorts is the numbers of the reserved OpenFlow ports,
+// which are printed by name even if "--no-names" is given.
+var reservedOFPorts = map[string]int{
+	"IN_PORT":    0xfff8,
+	"TABLE":      0xfff9,
+	"NORMAL":     0xfffa,
+	"FLOOD":      0xfffb,
+	"ALL":        0xfffc,
+	"CONTROLLER": 0xfffd,
+	"LOCAL":      0xfffe,
+	"NONE":       0xffff,
+}
+
+// parseOFPort parses the OpenFlow port number, which may be a reserved
+// port name, such as "LOCAL".
+func parseOFPort(s string) (int, error) {
+	if port, ok := reservedOFPorts[strings.ToUpper(s)]; ok {
+		return port, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// isHexDumpLine reports whether the line is the hex dump line, like
+// "00000010  08 00 06 04 00 01 00 00-00 00 00 01 0a 00 00 01".
+func isHexDumpLine(line string) bool {
+	if len(line) < 10 || line[8:10] != "  " {
+		return false
+	}
+	_, err := strconv.ParseUint(line[:8], 16, 32)
+	return err == nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPacketInParser(t *testing.T) {
+	out := `NXT_PACKET_IN2 (xid=0x0): table_id=10 cookie=0x10 total_len=42 reg0=0x1,in_port=1 (via action) data_len=42 (unbuffered)
+ userdata=01.02
+arp,vlan_tci=0x0000,dl_src=00:00:00:00:00:01,dl_dst=ff:ff:ff:ff:ff:ff,arp_spa=10.0.0.1,arp_tpa=10.0.0.2,arp_op=1
+00000000  ff ff ff ff ff ff 00 00-00 00 00 01 08 06 00 01
+00000010  08 00 06 04 00 01 00 00-00 00 00 01 0a 00 00 01
+00000020  00 00 00 00 00 00 0a 00-00 02
+OFPT_PORT_STATUS (xid=0x0): MOD: 1(vm1): addr:00:00:00:00:00:01
+NXT_PACKET_IN2 (xid=0x0): total_len=14 in_port=2 (via no_match) data_len=14 (unbuffered)
+ip,in_port=2
+00000000  00 00 00 00 00 02 00 00-00 00 00 01 08 00
+NXT_PACKET_IN2 (xid=0x0): total_len=14 in_port=LOCAL (via action) data_len=14 (unbuffered)
+ip,in_port=LOCAL
+00000000  00 00 00 00 00 02 00 00-00 00 00 01 08 00
+`
+
+	var packets []PacketIn
+	parser := &packetInParser{handle: func(p PacketIn) { packets = append(packets, p) }}
+	for _, line := range strings.Split(out, "\n") {
+		if err := parser.Parse(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(packets) != 3 {
+		t.Fatalf("expect 3 packet-ins, but got %d", len(packets))
+	}
+
+	p := packets[0]
+	if p.Table != 10 || p.Cookie != 0x10 || p.Reason != PacketInReasonAction ||
+		p.InPort != 1 || p.TotalLen != 42 || p.Metadata != "reg0=0x1,in_port=1" ||
+		!bytes.Equal(p.UserData, []byte{1, 2}) || !strings.HasPrefix(p.Flow, "arp,") {
+		t.Errorf("unexpected packet-in %+v", p)
+	} else if len(p.Data) != 42 || p.Data[12] != 0x08 || p.Data[13] != 0x06 || p.Data[41] != 0x02 {
+		t.Errorf("unexpected packet data %x", p.Data)
+	}
+
+	p = packets[1]
+	if p.Reason != PacketInReasonNoMatch || p.InPort != 2 || len(p.Data) != 14 || p.Flow != "ip,in_port=2" {
+		t.Errorf("unexpected packet-in %+v", p)
+	}
+
+	if p = packets[2]; p.InPort != 0xfffe || p.Metadata != "in_port=LOCAL" {
+		t.Errorf("unexpected packet-in %+v", p)
+	}
+}
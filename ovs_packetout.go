@@ -0,0 +1,180 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/xgfone/go-atexit"
+	"github.com/xgfone/go-exec"
+)
+
+// Pre-define some packet types of OpenFlow 1.5.
+const (
+	PacketTypeEthernet = "(0,0)"
+	PacketTypeIPv4     = "(1,0x800)"
+	PacketTypeIPv6     = "(1,0x86dd)"
+)
+
+// PacketOut is a packet to be sent by the bridge.
+type PacketOut struct {
+	// InPort is the input port of the packet, such as "1", "vm1",
+	// LOCAL or CONTROLLER, which is NONE by default.
+	InPort string
+
+	// PacketType is the packet type of OpenFlow 1.5, such as PacketTypeEthernet.
+	PacketType string
+
+	// Metadata is the pipeline fields of the packet,
+	// such as "reg0=0x1", "tun_id=0x10" or "metadata=0x2".
+	Metadata []string
+
+	// Packet is the raw packet, which is an ethernet frame by default.
+	Packet []byte
+
+	// Actions is the actions applied to the packet,
+	// such as "resubmit(,0)", "set_field:0x1->reg1" or "output:1".
+	Actions []string
+}
+
+func (p PacketOut) inPort() string {
+	if p.InPort == "" {
+		return NONE
+	}
+	return p.InPort
+}
+
+// String formats the packet-out as the modern argument
+// of "ovs-ofctl packet-out", which requires OVS 2.8 or later, like
+// "in_port=1,reg0=0x1,packet=ffffffffffff...,actions=resubmit(,0)".
+func (p PacketOut) String() string {
+	var b strings.Builder
+	b.WriteString("in_port=")
+	b.WriteString(p.inPort())
+	if p.PacketType != "" {
+		b.WriteString(",packet_type=")
+		b.WriteString(p.PacketType)
+	}
+	for _, field := range p.Metadata {
+		b.WriteByte(',')
+		b.WriteString(field)
+	}
+	b.WriteString(",packet=")
+	b.WriteString(hex.EncodeToString(p.Packet))
+	b.WriteString(",actions=")
+	b.WriteString(strings.Join(p.Actions, ","))
+	return b.String()
+}
+
+// legacyArgs returns the positional arguments of "ovs-ofctl packet-out"
+// for the older OVS, that's, "IN_PORT ACTIONS PACKET".
+//
+// Since the older OVS does not support the packet metadata, it is set
+// by the action set_field before the other actions.
+func (p PacketOut) legacyArgs() ([]string, error) {
+	if p.PacketType != "" && p.PacketType != PacketTypeEthernet {
+		return nil, errors.New("the packet type is not supported by the legacy packet-out")
+	}
+
+	actions := make([]string, 0, len(p.Metadata)+len(p.Actions))
+	for _, field := range p.Metadata {
+		key, value := splitKeyValue(field)
+		if key == "" || value == "" {
+			return nil, fmt.Errorf("invalid packet metadata '%s'", field)
+		}
+		actions = append(actions, fmt.Sprintf("set_field:%s->%s", value, key))
+	}
+	actions = append(actions, p.Actions...)
+
+	return []string{p.inPort(), strings.Join(actions, ","), hex.EncodeToString(p.Packet)}, nil
+}
+
+func (p PacketOut) validate() error {
+	if len(p.Packet) == 0 {
+		return errors.New("the packet-out has no packet")
+	} else if len(p.Actions) == 0 {
+		return errors.New("the packet-out has no actions")
+	}
+	return nil
+}
+
+// packetOutUnsupportedErrors is the errors of "ovs-ofctl packet-out",
+// which show that the modern syntax or the OpenFlow version is not supported.
+var packetOutUnsupportedErrors = []string{
+	"requires at least 3 arguments",
+	"unknown openflow version",
+	"version negotiation failed",
+}
+
+// isPacketOutUnsupported reports whether the error of "ovs-ofctl packet-out"
+// shows that the modern syntax or the OpenFlow version is not supported.
+func isPacketOutUnsupported(err error) bool {
+	var result exec.Result
+	if !errors.As(err, &result) {
+		return false
+	}
+
+	stderr := strings.ToLower(result.Stderr)
+	for _, s := range packetOutUnsupportedErrors {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendPacketOut sends the packets by the bridge with the modern syntax
+// of "ovs-ofctl packet-out" by OfctlProtocol. Only if the modern syntax
+// or the OpenFlow version is not supported, fall back to the legacy syntax
+// for the older OVS.
+func SendPacketOut(bridge string, packets ...PacketOut) (err error) {
+	for _, packet := range packets {
+		if err = packet.validate(); err != nil {
+			return
+		}
+
+		err = exec.Execute(context.Background(), OfctlCmd, "-O", OfctlProtocol,
+			"packet-out", bridge, packet.String())
+		if err == nil {
+			continue
+		} else if !isPacketOutUnsupported(err) {
+			return
+		}
+
+		args, lerr := packet.legacyArgs()
+		if lerr == nil {
+			args = append([]string{"packet-out", bridge}, args...)
+			lerr = exec.Execute(context.Background(), OfctlCmd, args...)
+		}
+		if lerr != nil {
+			return fmt.Errorf("modern packet-out: %v; legacy packet-out: %w", err, lerr)
+		}
+	}
+	return nil
+}
+
+// MustSendPacketOut is the same as SendPacketOut,
+// but the program exits if there is an error.
+func MustSendPacketOut(bridge string, packet PacketOut) {
+	if err := SendPacketOut(bridge, packet); err != nil {
+		log.Printf("fail to send packet-out: bridge=%s, packet=%s, err=%v", bridge, packet, err)
+		atexit.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xgfone/go-exec"
+)
+
+func ExamplePacketOut() {
+	packet := PacketOut{
+		InPort:   LOCAL,
+		Metadata: []string{"reg0=0x1", "tun_id=0x10"},
+		Packet:   []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		Actions:  []string{"resubmit(,0)"},
+	}
+	fmt.Println(packet)
+
+	args, _ := packet.legacyArgs()
+	fmt.Println(args)
+
+	// Output:
+	// in_port=local,reg0=0x1,tun_id=0x10,packet=ffffffffffff,actions=resubmit(,0)
+	// [local set_field:0x1->reg0,set_field:0x10->tun_id,resubmit(,0) ffffffffffff]
+}
+
+func TestIsPacketOutUnsupported(t *testing.T) {
+	unsupported := exec.NewResult(OfctlCmd, nil, "",
+		"ovs-ofctl: 'packet-out' command requires at least 3 arguments\n", errors.New("exit status 1"))
+	if !isPacketOutUnsupported(unsupported) {
+		t.Errorf("expect the unsupported error: %v", unsupported)
+	}
+
+	failed := exec.NewResult(OfctlCmd, nil, "",
+		"ovs-ofctl: br0 is not a bridge or a socket\n", errors.New("exit status 1"))
+	if isPacketOutUnsupported(failed) {
+		t.Errorf("unexpected unsupported error: %v", failed)
+	}
+
+	if isPacketOutUnsupported(errors.New("requires at least 3 arguments")) {
+		t.Errorf("unexpected unsupported error for the non-command error")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/go-ovs/packet"
+)
+
+// ProbeCookieBase is the base cookie of the temporary flows installed
+// by Probe, the low 32 bits of which is the sequence of the probe.
+var ProbeCookieBase uint64 = 0x5052 << 48
+
+// ProbeInterval is the interval to resend the request by Probe
+// until the reply is received.
+var ProbeInterval = time.Second
+
+var probeSeq uint32
+
+// probeLocks serializes the probes with the same bridge, port and dstIP,
+// the temporary flows of which have the same match and priority.
+var (
+	probeLocks   = make(map[string]*probeLock)
+	probeLocksMu sync.Mutex
+)
+
+type probeLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+// acquireProbe waits until no other probe with the same key is running
+// or ctx is done, and returns the function to release it.
+func acquireProbe(ctx context.Context, key string) (release func(), err error) {
+	probeLocksMu.Lock()
+	lock, ok := probeLocks[key]
+	if !ok {
+		lock = &probeLock{sem: make(chan struct{}, 1)}
+		probeLocks[key] = lock
+	}
+	lock.refs++
+	probeLocksMu.Unlock()
+
+	unref := func() {
+		probeLocksMu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(probeLocks, key)
+		}
+		probeLocksMu.Unlock()
+	}
+
+	select {
+	case lock.sem <- struct{}{}:
+		return func() { <-lock.sem; unref() }, nil
+	case <-ctx.Done():
+		unref()
+		return nil, ctx.Err()
+	}
+}
+
+// ProbeResult is the result of Probe.
+type ProbeResult struct {
+	MAC net.HardwareAddr
+	RTT time.Duration // The round-trip time of the last request
+}
+
+// Probe sends the ARP request for the IPv4 address dstIP, or the neighbor
+// solicitation for the IPv6 address dstIP, from the port of the bridge,
+// and waits for the reply until ctx is done, which should have a deadline.
+//
+// It temporarily installs a flow in table 0 with the highest priority
+// to send the reply from port to the controller, which is removed
+// before returning. So the probes with the same bridge, port and dstIP
+// are serialized.
+func Probe(ctx context.Context, bridge, port, srcMac, srcIP, dstIP string) (result ProbeResult, err error) {
+	dst, err := netip.ParseAddr(dstIP)
+	if err != nil {
+		return
+	}
+
+	isIPv6 := !dst.Is4()
+	if _, _, err = parseNeighborSource(srcMac, srcIP, isIPv6); err != nil {
+		return
+	} else if dst, err = parseNeighborIP(dstIP, isIPv6); err != nil {
+		return
+	}
+
+	send := func() error {
+		if isIPv6 {
+			return SendNeighborSolicitation(bridge, "output:"+port, CONTROLLER, srcMac, srcIP, dstIP)
+		}
+		return SendARPRequest(bridge, "output:"+port, CONTROLLER, srcMac, srcIP, dstIP)
+	}
+
+	release, err := acquireProbe(ctx, fmt.Sprintf("%s/%s/%s", bridge, port, dst))
+	if err != nil {
+		return result, fmt.Errorf("probing %s timeout: %w", dstIP, err)
+	}
+	defer release()
+
+	cookie := ProbeCookieBase | uint64(atomic.AddUint32(&probeSeq, 1))
+	match := fmt.Sprintf("arp,arp_op=2,arp_spa=%s,arp_tpa=%s", dstIP, srcIP)
+	if isIPv6 {
+		match = fmt.Sprintf("icmp6,icmp_type=%d,nd_target=%s", packet.ICMPv6TypeNeighborAdvertisement, dstIP)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	packets, err := ListenPacketIn(ctx, bridge, PacketInOptions{DropIfFull: true})
+	if err != nil {
+		return
+	}
+
+	flow := Flow{Cookie: cookie, Priority: 65535, Match: fmt.Sprintf("in_port=%s,%s", port, match), Actions: "controller"}
+	if err = AddFlows(bridge, flow.String()); err != nil {
+		return
+	}
+	defer func() {
+		if err := DelFlowsByCookie(bridge, cookie); err != nil {
+			log.Printf("fail to delete the probe flow: bridge=%s, cookie=0x%x, err=%v", bridge, cookie, err)
+		}
+	}()
+
+	// The packet-in listener may not be ready for the first request,
+	// so resend the request periodically.
+	ticker := time.NewTicker(ProbeInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	if err = send(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("probing %s timeout: %w", dstIP, ctx.Err())
+
+		case <-ticker.C:
+			start = time.Now()
+			if err = send(); err != nil {
+				return
+			}
+
+		case p, ok := <-packets:
+			if !ok {
+				return result, fmt.Errorf("probing %s timeout: %w", dstIP, ctx.Err())
+			} else if p.Cookie != cookie {
+				continue
+			}
+
+			if mac := probeReplyMAC(p.Data, dst); mac != nil {
+				return ProbeResult{MAC: mac, RTT: time.Since(start)}, nil
+			}
+		}
+	}
+}
+
+// probeReplyMAC returns the MAC address of ip from the ARP reply
+// or the neighbor advertisement. Return nil if data is not the reply for ip.
+func probeReplyMAC(data []byte, ip netip.Addr) net.HardwareAddr {
+	layers, err := packet.Decode(data)
+	if err != nil {
+		return nil
+	}
+
+	if arp, ok := packet.Find[packet.ARP](layers); ok {
+		if arp.Op == packet.ARPReply && arp.SenderIP == ip {
+			return arp.SenderMAC
+		}
+		return nil
+	}
+
+	if na, ok := packet.Find[packet.NeighborAdvertisement](layers); ok && na.Target == ip {
+		if len(na.TargetMAC) > 0 {
+			return na.TargetMAC
+		}
+
+		// Use the source MAC if no the target link-layer address option.
+		eth, _ := packet.Find[packet.Ethernet](layers)
+		return eth.Src
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/xgfone/go-ovs/packet"
+)
+
+func TestProbeReplyMAC(t *testing.T) {
+	mac1 := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ip1 := netip.MustParseAddr("10.0.0.1")
+	ip2 := netip.MustParseAddr("10.0.0.2")
+
+	reply, _ := packet.Encode(
+		packet.Ethernet{Dst: mac1, Src: mac2},
+		packet.ARP{Op: packet.ARPReply, SenderMAC: mac2, SenderIP: ip2, TargetMAC: mac1, TargetIP: ip1},
+	)
+	if mac := probeReplyMAC(reply, ip2); mac.String() != mac2.String() {
+		t.Errorf("expect mac %s, but got %s", mac2, mac)
+	}
+	if mac := probeReplyMAC(reply, ip1); mac != nil {
+		t.Errorf("expect no mac, but got %s", mac)
+	}
+
+	ip61 := netip.MustParseAddr("fe80::1")
+	ip62 := netip.MustParseAddr("fe80::2")
+	na, _ := packet.Encode(
+		packet.Ethernet{Dst: mac1, Src: mac2},
+		packet.IPv6{HopLimit: 255, Src: ip62, Dst: ip61},
+		packet.ICMPv6{},
+		packet.NeighborAdvertisement{Solicited: true, Target: ip62},
+	)
+	if mac := probeReplyMAC(na, ip62); mac.String() != mac2.String() {
+		t.Errorf("expect mac %s, but got %s", mac2, mac)
+	}
+}
+
+func TestAcquireProbe(t *testing.T) {
+	release, err := acquireProbe(context.Background(), "br0/1/10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireProbe(ctx, "br0/1/10.0.0.2"); err == nil {
+		t.Error("expect an error for the running probe with the same key")
+	}
+
+	other, err := acquireProbe(context.Background(), "br0/1/10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	done := make(chan struct{})
+	go func() {
+		if release, err := acquireProbe(context.Background(), "br0/1/10.0.0.2"); err != nil {
+			t.Error(err)
+		} else {
+			release()
+		}
+		close(done)
+	}()
+
+	release()
+	<-done
+
+	if len(probeLocks) != 0 {
+		t.Errorf("expect no probe locks, but got %d", len(probeLocks))
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DefaultResponderPriority is the default priority of the responder flows.
+const DefaultResponderPriority = 100
+
+// NeighborBinding is the binding of an IP address to a MAC address,
+// which is answered by NeighborResponder.
+type NeighborBinding struct {
+	IP  string
+	MAC string
+
+	// Match is the optional extra match to scope the binding,
+	// such as "dl_vlan=100", "tun_id=0x64" or "metadata=0x1".
+	Match string
+}
+
+func (b NeighborBinding) parseIP() (ip netip.Addr, err error) {
+	if ip, err = netip.ParseAddr(b.IP); err == nil && (ip.Zone() != "" || ip.Is4In6()) {
+		err = fmt.Errorf("invalid binding ip '%s'", b.IP)
+	}
+	return
+}
+
+func (b NeighborBinding) parse() (ip netip.Addr, mac net.HardwareAddr, err error) {
+	if ip, err = b.parseIP(); err != nil {
+		return
+	}
+
+	if mac, err = net.ParseMAC(normalizeMac(b.MAC)); err != nil || len(mac) != 6 {
+		err = fmt.Errorf("invalid binding mac '%s'", b.MAC)
+	}
+	return
+}
+
+// NeighborResponder generates the flows to answer the ARP requests
+// and the IPv6 neighbor solicitations for the bindings in the bridge,
+// which rewrites the request into the reply and outputs it to IN_PORT.
+//
+// The IPv6 responder requires OVS 2.12 or later, and the neighbor
+// solicitation must contain the source link-layer address option,
+// which is rewritten to the target link-layer address option.
+//
+// Notice: the duplicate address detection is not supported, because its
+// neighbor solicitation from "::" has no source link-layer address option
+// and must be answered to the all-nodes multicast address. So it should be
+// handled by the flows with the higher priority, such as matching
+// "icmp6,icmp_type=135,ipv6_src=::".
+type NeighborResponder struct {
+	Cookie   uint64
+	Table    uint8
+	Priority int // If 0, use DefaultResponderPriority.
+}
+
+func (r NeighborResponder) priority() int {
+	if r.Priority == 0 {
+		return DefaultResponderPriority
+	}
+	return r.Priority
+}
+
+func (r NeighborResponder) match(ip netip.Addr, extra string) string {
+	var match string
+	if ip.Is4() {
+		match = fmt.Sprintf("arp,arp_op=1,arp_tpa=%s", ip)
+	} else {
+		match = fmt.Sprintf("icmp6,icmp_type=135,icmp_code=0,nd_target=%s", ip)
+	}
+
+	if extra != "" {
+		match = extra + "," + match
+	}
+	return match
+}
+
+// Flows returns the responder flows of the bindings.
+func (r NeighborResponder) Flows(bindings ...NeighborBinding) (flows []string, err error) {
+	flows = make([]string, len(bindings))
+	for i, binding := range bindings {
+		ip, mac, err := binding.parse()
+		if err != nil {
+			return nil, err
+		}
+
+		var actions string
+		if ip.Is4() {
+			actions = fmt.Sprintf("move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],%s,"+
+				"%s,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],"+
+				"%s,%s,in_port",
+				SetFieldAction(mac.String(), "eth_src"),
+				LoadAction(2, "NXM_OF_ARP_OP[]"),
+				SetFieldAction(mac.String(), "arp_sha"),
+				SetFieldAction(ip.String(), "arp_spa"))
+		} else {
+			// The flags of NA are Solicited and Override.
+			actions = fmt.Sprintf("move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],%s,"+
+				"move:NXM_NX_IPV6_SRC[]->NXM_NX_IPV6_DST[],%s,%s,%s,%s,%s,%s,in_port",
+				SetFieldAction(mac.String(), "eth_src"),
+				SetFieldAction(ip.String(), "ipv6_src"),
+				SetFieldAction("255", "nw_ttl"),
+				SetFieldAction("136", "icmpv6_type"),
+				SetFieldAction("0x60000000", "nd_reserved"),
+				SetFieldAction("2", "nd_options_type"),
+				SetFieldAction(mac.String(), "nd_tll"))
+		}
+
+		flows[i] = Flow{
+			Cookie:   r.Cookie,
+			Table:    r.Table,
+			Priority: r.priority(),
+			Match:    r.match(ip, binding.Match),
+			Actions:  actions,
+		}.String()
+	}
+	return
+}
+
+// Add adds the responder flows of the bindings into the bridge.
+func (r NeighborResponder) Add(bridge string, bindings ...NeighborBinding) (err error) {
+	flows, err := r.Flows(bindings...)
+	if err != nil {
+		return
+	}
+	return AddFlows(bridge, flows...)
+}
+
+// Delete deletes the responder flows of the bindings from the bridge,
+// which only uses the IP and Match of the bindings.
+func (r NeighborResponder) Delete(bridge string, bindings ...NeighborBinding) (err error) {
+	matches := make([]string, len(bindings))
+	for i, binding := range bindings {
+		ip, err := binding.parseIP()
+		if err != nil {
+			return err
+		}
+		matches[i] = fmt.Sprintf("table=%d,%s", r.Table, r.match(ip, binding.Match))
+	}
+	return DelFlowsStrict(bridge, r.priority(), matches...)
+}
+
+// Replace replaces all the responder flows with the cookie in the bridge
+// with those of the bindings atomically.
+//
+// Notice: Cookie should be unique to the responder.
+func (r NeighborResponder) Replace(bridge string, bindings ...NeighborBinding) (err error) {
+	flows, err := r.Flows(bindings...)
+	if err != nil {
+		return
+	}
+	return ReplaceFlowsByCookie(bridge, r.Cookie, flows...)
+}
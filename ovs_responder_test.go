@@ -0,0 +1,65 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import "testing"
+
+func TestNeighborResponder(t *testing.T) {
+	r := NeighborResponder{Cookie: 0x1, Table: 10}
+	flows, err := r.Flows(
+		NeighborBinding{IP: "10.0.0.1", MAC: "fa:16:3e:00:00:01", Match: "dl_vlan=100"},
+		NeighborBinding{IP: "fd00::1", MAC: "fa:16:3e:00:00:01"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []string{
+		"cookie=0x1,table=10,priority=100,dl_vlan=100,arp,arp_op=1,arp_tpa=10.0.0.1," +
+			"actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:fa:16:3e:00:00:01->eth_src," +
+			"load:0x2->NXM_OF_ARP_OP[],move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]," +
+			"move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:fa:16:3e:00:00:01->arp_sha," +
+			"set_field:10.0.0.1->arp_spa,in_port",
+		"cookie=0x1,table=10,priority=100,icmp6,icmp_type=135,icmp_code=0,nd_target=fd00::1," +
+			"actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:fa:16:3e:00:00:01->eth_src," +
+			"move:NXM_NX_IPV6_SRC[]->NXM_NX_IPV6_DST[],set_field:fd00::1->ipv6_src," +
+			"set_field:255->nw_ttl,set_field:136->icmpv6_type,set_field:0x60000000->nd_reserved," +
+			"set_field:2->nd_options_type,set_field:fa:16:3e:00:00:01->nd_tll,in_port",
+	}
+	for i, flow := range flows {
+		if flow != expects[i] {
+			t.Errorf("expect flow '%s', but got '%s'", expects[i], flow)
+		}
+	}
+
+	for _, binding := range []NeighborBinding{
+		{IP: "10.0.0.1", MAC: "fa:16:3e:00:00:01:02"},
+		{IP: "::ffff:10.0.0.1", MAC: "fa:16:3e:00:00:01"},
+		{IP: "fe80::1%eth0", MAC: "fa:16:3e:00:00:01"},
+	} {
+		if _, err := r.Flows(binding); err == nil {
+			t.Errorf("expect an error for the binding %+v", binding)
+		}
+	}
+
+	for _, binding := range []NeighborBinding{
+		{IP: "::ffff:10.0.0.1"},
+		{IP: "fe80::1%eth0"},
+	} {
+		if err := r.Delete("br0", binding); err == nil {
+			t.Errorf("expect an error to delete the binding %+v", binding)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"context"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/go-exec"
+)
+
+// TraceOptions is the options of ofproto/trace.
+type TraceOptions struct {
+	// CTNext is the conntrack states used by the recirculations after ct()
+	// in turn, such as CTStateTrk|CTStateEst.
+	//
+	// If empty, the recirculated packet uses the default state "trk|new".
+	CTNext []CTStateFlag
+
+	// Packet is the raw ethernet packet to be traced. If set, the flow
+	// only contains the metadata, such as "in_port=1".
+	Packet []byte
+
+	// Generate generates a packet from the flow, which is used
+	// only if Packet is empty.
+	Generate bool
+}
+
+// TraceStage is a table lookup in the trace.
+type TraceStage struct {
+	Bridge string
+	Table  uint8
+	Depth  int // The nested depth, such as resubmit or the patch port.
+
+	// Flow is the matched flow, which only contains Cookie, Table, Priority
+	// and Match. If no flow matches, it is nil.
+	Flow *Flow
+
+	// Actions is the actions executed in the stage.
+	Actions []string
+
+	// Notes is the explanations of the actions, such as
+	// "NXM_NX_REG0[] is now 0x1".
+	Notes []string
+
+	// Recirculated reports whether the packet is recirculated to RecircTable,
+	// for example, by ct(table=N).
+	Recirculated bool
+	RecircTable  uint8
+}
+
+// TracePass is the pass of the packet through the pipeline, which is
+// the initial pass or a recirculation.
+type TracePass struct {
+	RecircID uint32 // 0 for the initial pass
+	Flow     string
+
+	Stages []TraceStage
+	Notes  []string // The notes not belonging to any stage.
+
+	FinalFlow       string
+	Megaflow        string
+	DatapathActions string
+}
+
+// Dropped reports whether the packet is dropped in the pass.
+func (p TracePass) Dropped() bool { return p.DatapathActions == "drop" }
+
+// TraceResult is the parsed result of ofproto/trace.
+type TraceResult struct {
+	Passes []TracePass
+}
+
+// Stages returns the stages of all the passes in order.
+func (r TraceResult) Stages() (stages []TraceStage) {
+	for _, pass := range r.Passes {
+		stages = append(stages, pass.Stages...)
+	}
+	return
+}
+
+// DatapathActions returns the datapath actions of the last pass.
+func (r TraceResult) DatapathActions() string {
+	if len(r.Passes) == 0 {
+		return ""
+	}
+	return r.Passes[len(r.Passes)-1].DatapathActions
+}
+
+// Megaflow returns the megaflow of the last pass.
+func (r TraceResult) Megaflow() string {
+	if len(r.Passes) == 0 {
+		return ""
+	}
+	return r.Passes[len(r.Passes)-1].Megaflow
+}
+
+// Dropped reports whether the packet is dropped finally.
+func (r TraceResult) Dropped() bool { return r.DatapathActions() == "drop" }
+
+// LastStage returns the last stage of the last pass, which is the stage
+// responsible for the drop if the packet is dropped.
+func (r TraceResult) LastStage() (stage TraceStage, ok bool) {
+	for i := len(r.Passes) - 1; i >= 0; i-- {
+		if stages := r.Passes[i].Stages; len(stages) > 0 {
+			return stages[len(stages)-1], true
+		}
+	}
+	return
+}
+
+// Trace traces the flow through the bridge by ofproto/trace,
+// such as "in_port=1,tcp,nw_src=10.0.0.1,nw_dst=10.0.0.2,tp_dst=80".
+func Trace(bridge, flow string) (TraceResult, error) {
+	return TraceWithOptions(bridge, flow, TraceOptions{})
+}
+
+// TraceWithOptions is the same as Trace, but with the options.
+func TraceWithOptions(bridge, flow string, opts TraceOptions) (result TraceResult, err error) {
+	args := []string{"ofproto/trace"}
+	for _, state := range opts.CTNext {
+		args = append(args, "--ct-next", formatCTStateFlags(state))
+	}
+
+	args = append(args, bridge, flow)
+	if len(opts.Packet) > 0 {
+		args = append(args, hex.EncodeToString(opts.Packet))
+	} else if opts.Generate {
+		args = append(args, "-generate")
+	}
+
+	out, err := exec.Output(context.Background(), AppctlCmd, args...)
+	if err == nil {
+		result = parseTrace(out)
+	}
+	return
+}
+
+func formatCTStateFlags(state CTStateFlag) string {
+	flags := make([]string, 0, len(ctStateFlags))
+	for _, f := range ctStateFlags {
+		if state&f.Flag != 0 {
+			flags = append(flags, f.Name)
+		}
+	}
+	return strings.Join(flags, "|")
+}
+
+var (
+	traceTableRe  = regexp.MustCompile(`^( *)(\d+)\. (.*)$`)
+	traceRecircRe = regexp.MustCompile(`^recirc\((0x[0-9a-fA-F]+|\d+)\)`)
+	traceResumeRe = regexp.MustCompile(`resumed at table (\d+)`)
+)
+
+func parseTrace(out string) (result TraceResult) {
+	var pass *TracePass
+	var stage *TraceStage
+	var bridge string
+
+	newPass := func(id uint32) {
+		result.Passes = append(result.Passes, TracePass{RecircID: id})
+		pass, stage = &result.Passes[len(result.Passes)-1], nil
+	}
+	newPass(0)
+
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "---"),
+			strings.HasPrefix(trimmed, "==="):
+
+		case strings.HasPrefix(line, "Flow: "):
+			pass.Flow = strings.TrimPrefix(line, "Flow: ")
+
+		case strings.HasPrefix(line, "Final flow: "):
+			pass.FinalFlow = strings.TrimPrefix(line, "Final flow: ")
+
+		case strings.HasPrefix(line, "Megaflow: "):
+			pass.Megaflow = strings.TrimPrefix(line, "Megaflow: ")
+
+		case strings.HasPrefix(line, "Datapath actions: "):
+			pass.DatapathActions = strings.TrimPrefix(line, "Datapath actions: ")
+
+		case traceRecircRe.MatchString(line):
+			id, _ := strconv.ParseUint(traceRecircRe.FindStringSubmatch(line)[1], 0, 32)
+			newPass(uint32(id))
+
+		case strings.HasPrefix(trimmed, `bridge("`):
+			bridge = strings.TrimSuffix(strings.TrimPrefix(trimmed, `bridge("`), `")`)
+
+		case traceTableRe.MatchString(line):
+			m := traceTableRe.FindStringSubmatch(line)
+			table, _ := strconv.ParseUint(m[2], 10, 8)
+			pass.Stages = append(pass.Stages, TraceStage{
+				Bridge: bridge,
+				Table:  uint8(table),
+				Depth:  (len(m[1]) + len(m[2]) - 2) / 4,
+				Flow:   parseTraceFlow(uint8(table), m[3]),
+			})
+			stage = &pass.Stages[len(pass.Stages)-1]
+
+		case stage == nil:
+			pass.Notes = append(pass.Notes, trimmed)
+
+		case strings.HasPrefix(trimmed, "->"), strings.HasPrefix(trimmed, ">>"):
+			note := strings.TrimSpace(strings.Trim(trimmed, "-<>"))
+			stage.Notes = append(stage.Notes, note)
+			if m := traceResumeRe.FindStringSubmatch(note); m != nil {
+				table, _ := strconv.ParseUint(m[1], 10, 8)
+				stage.Recirculated, stage.RecircTable = true, uint8(table)
+			}
+
+		default:
+			stage.Actions = append(stage.Actions, trimmed)
+		}
+	}
+
+	return
+}
+
+// parseTraceFlow parses the matched flow in the trace,
+// such as "ip,in_port=1, priority 100, cookie 0x10" or "No match.".
+func parseTraceFlow(table uint8, s string) *Flow {
+	if strings.HasPrefix(s, "No match") {
+		return nil
+	}
+
+	flow := &Flow{Table: table}
+	if index := strings.LastIndex(s, ", cookie "); index >= 0 {
+		flow.Cookie, _ = strconv.ParseUint(s[index+len(", cookie "):], 0, 64)
+		s = s[:index]
+	}
+
+	if index := strings.LastIndex(s, "priority "); index == 0 ||
+		(index > 0 && strings.HasSuffix(s[:index], ", ")) {
+		flow.Priority, _ = strconv.Atoi(s[index+len("priority "):])
+		s = strings.TrimSuffix(s[:index], ", ")
+	}
+
+	flow.Match = s
+	return flow
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import "testing"
+
+func TestParseTrace(t *testing.T) {
+	out := `Flow: tcp,in_port=1,vlan_tci=0x0000,dl_src=00:00:00:00:00:01,dl_dst=00:00:00:00:00:02,nw_src=10.0.0.1,nw_dst=10.0.0.2,nw_tos=0,nw_ecn=0,nw_ttl=64,tp_src=0,tp_dst=80,tcp_flags=0
+
+bridge("br0")
+-------------
+ 0. ip,in_port=1, priority 100, cookie 0x10
+    load:0x1->NXM_NX_REG5[]
+     -> NXM_NX_REG5[] is now 0x1
+    resubmit(,10)
+     10. priority 0
+            ct(table=20,zone=5)
+            drop
+             -> A clone of the packet is forked to recirculate. The forked pipeline will be resumed at table 20.
+             -> Sets the packet to an untracked state, and clears all the conntrack fields.
+
+Final flow: unchanged
+Megaflow: recirc_id=0,eth,ip,in_port=1,nw_frag=no
+Datapath actions: ct(zone=5),recirc(0x1)
+
+===============================================================================
+recirc(0x1) - resume conntrack with default ct_state=trk|new (use --ct-next to customize)
+===============================================================================
+
+Flow: recirc_id=0x1,ct_state=new|trk,ct_zone=5,eth,tcp,reg5=0x1,in_port=1,nw_src=10.0.0.1,nw_dst=10.0.0.2,tp_dst=80
+
+bridge("br0")
+-------------
+    thaw
+        Resuming from table 20
+20. No match.
+    drop
+
+Final flow: unchanged
+Megaflow: recirc_id=0x1,ct_state=+new+trk,eth,ip,in_port=1,nw_frag=no
+Datapath actions: drop
+`
+
+	result := parseTrace(out)
+	if len(result.Passes) != 2 {
+		t.Fatalf("expect 2 passes, but got %d", len(result.Passes))
+	}
+
+	first := result.Passes[0]
+	if first.RecircID != 0 || first.DatapathActions != "ct(zone=5),recirc(0x1)" ||
+		first.Megaflow != "recirc_id=0,eth,ip,in_port=1,nw_frag=no" || first.Dropped() {
+		t.Errorf("unexpected first pass: %+v", first)
+	} else if len(first.Stages) != 2 {
+		t.Fatalf("expect 2 stages, but got %d", len(first.Stages))
+	}
+
+	stage := first.Stages[0]
+	if stage.Bridge != "br0" || stage.Table != 0 || stage.Depth != 0 || stage.Flow == nil ||
+		*stage.Flow != (Flow{Cookie: 0x10, Priority: 100, Match: "ip,in_port=1"}) {
+		t.Errorf("unexpected stage %+v", stage)
+	} else if len(stage.Actions) != 2 || stage.Actions[1] != "resubmit(,10)" ||
+		len(stage.Notes) != 1 || stage.Notes[0] != "NXM_NX_REG5[] is now 0x1" {
+		t.Errorf("unexpected stage actions %+v", stage)
+	}
+
+	stage = first.Stages[1]
+	if stage.Table != 10 || stage.Depth != 1 || stage.Flow == nil ||
+		*stage.Flow != (Flow{Table: 10}) || !stage.Recirculated || stage.RecircTable != 20 {
+		t.Errorf("unexpected stage %+v", stage)
+	}
+
+	second := result.Passes[1]
+	if second.RecircID != 1 || !second.Dropped() || len(second.Notes) != 2 ||
+		len(second.Stages) != 1 || second.Stages[0].Flow != nil {
+		t.Errorf("unexpected second pass: %+v", second)
+	}
+
+	if last, ok := result.LastStage(); !ok || last.Table != 20 || !result.Dropped() {
+		t.Errorf("unexpected last stage %+v", last)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// Pre-define the operations of DHCPv4.
+const (
+	DHCPBootRequest = 1
+	DHCPBootReply   = 2
+)
+
+// Pre-define some DHCPv4 options.
+const (
+	DHCPOptionSubnetMask    = 1
+	DHCPOptionRouter        = 3
+	DHCPOptionDNSServer     = 6
+	DHCPOptionHostName      = 12
+	DHCPOptionRequestedIP   = 50
+	DHCPOptionLeaseTime     = 51
+	DHCPOptionMessageType   = 53
+	DHCPOptionServerID      = 54
+	DHCPOptionParamRequest  = 55
+	DHCPOptionRenewalTime   = 58
+	DHCPOptionRebindingTime = 59
+	DHCPOptionClientID      = 61
+
+	dhcpOptionPad = 0
+	dhcpOptionEnd = 255
+)
+
+// Pre-define the DHCPv4 message types, which is the value
+// of the option DHCPOptionMessageType.
+const (
+	DHCPDiscover = 1
+	DHCPOffer    = 2
+	DHCPRequest  = 3
+	DHCPDecline  = 4
+	DHCPAck      = 5
+	DHCPNak      = 6
+	DHCPRelease  = 7
+	DHCPInform   = 8
+)
+
+const dhcpMagicCookie = 0x63825363
+
+// DHCPOption is an option of DHCPv4.
+type DHCPOption struct {
+	Code uint8
+	Data []byte
+}
+
+// DHCPv4 is the DHCPv4 message.
+type DHCPv4 struct {
+	Op     uint8 // DHCPBootRequest or DHCPBootReply
+	Hops   uint8
+	XID    uint32
+	Secs   uint16
+	Flags  uint16 // 0x8000 is the broadcast flag.
+	CIAddr netip.Addr
+	YIAddr netip.Addr
+	SIAddr netip.Addr
+	GIAddr netip.Addr
+
+	ClientMAC net.HardwareAddr
+	Options   []DHCPOption // Not contain the pad and end options.
+}
+
+// Option returns the data of the first option with the code.
+func (d DHCPv4) Option(code uint8) (data []byte, ok bool) {
+	for _, opt := range d.Options {
+		if opt.Code == code {
+			return opt.Data, true
+		}
+	}
+	return
+}
+
+// MessageType returns the DHCPv4 message type, such as DHCPDiscover.
+//
+// Return 0 if the option DHCPOptionMessageType does not exist.
+func (d DHCPv4) MessageType() uint8 {
+	if data, ok := d.Option(DHCPOptionMessageType); ok && len(data) == 1 {
+		return data[0]
+	}
+	return 0
+}
+
+func putOptionalAddr(b []byte, addr netip.Addr) error {
+	if !addr.IsValid() {
+		return nil
+	}
+	return putAddr(b, addr, true)
+}
+
+func (d DHCPv4) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	b := make([]byte, 240, 256+len(payload))
+	b[0], b[1], b[2], b[3] = d.Op, 1, 6, d.Hops
+	binary.BigEndian.PutUint32(b[4:], d.XID)
+	binary.BigEndian.PutUint16(b[8:], d.Secs)
+	binary.BigEndian.PutUint16(b[10:], d.Flags)
+
+	for i, addr := range []netip.Addr{d.CIAddr, d.YIAddr, d.SIAddr, d.GIAddr} {
+		if err := putOptionalAddr(b[12+4*i:16+4*i], addr); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := putMAC(b[28:34], d.ClientMAC); err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint32(b[236:], dhcpMagicCookie)
+	for _, opt := range d.Options {
+		if len(opt.Data) > 255 {
+			return nil, errors.New("the DHCP option is too long")
+		}
+		b = append(append(b, opt.Code, uint8(len(opt.Data))), opt.Data...)
+	}
+	b = append(b, dhcpOptionEnd)
+
+	return append(b, payload...), nil
+}
+
+func decodeDHCPv4(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 240 {
+		return nil, nil, nil, errTruncated
+	} else if binary.BigEndian.Uint32(data[236:]) != dhcpMagicCookie {
+		return decodePayload(data)
+	}
+
+	d := DHCPv4{
+		Op:        data[0],
+		Hops:      data[3],
+		XID:       binary.BigEndian.Uint32(data[4:]),
+		Secs:      binary.BigEndian.Uint16(data[8:]),
+		Flags:     binary.BigEndian.Uint16(data[10:]),
+		CIAddr:    netip.AddrFrom4(*(*[4]byte)(data[12:16])),
+		YIAddr:    netip.AddrFrom4(*(*[4]byte)(data[16:20])),
+		SIAddr:    netip.AddrFrom4(*(*[4]byte)(data[20:24])),
+		GIAddr:    netip.AddrFrom4(*(*[4]byte)(data[24:28])),
+		ClientMAC: cloneMAC(data[28:34]),
+	}
+
+	for options := data[240:]; len(options) > 0; {
+		code := options[0]
+		if code == dhcpOptionEnd {
+			break
+		} else if code == dhcpOptionPad {
+			options = options[1:]
+			continue
+		} else if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return nil, nil, nil, errTruncated
+		}
+
+		length := int(options[1])
+		d.Options = append(d.Options, DHCPOption{
+			Code: code,
+			Data: append([]byte{}, options[2:2+length]...),
+		})
+		options = options[2+length:]
+	}
+
+	// Ignore the padding after the end option.
+	return d, nil, nil, nil
+}
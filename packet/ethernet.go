@@ -0,0 +1,199 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Pre-define some ether types.
+const (
+	EtherTypeIPv4     = 0x0800
+	EtherTypeARP      = 0x0806
+	EtherTypeVLAN     = 0x8100 // 802.1Q
+	EtherTypeQinQ     = 0x88a8 // 802.1ad
+	EtherTypeIPv6     = 0x86dd
+	EtherTypeEthernet = 0x6558 // Transparent Ethernet Bridging
+)
+
+// VLAN is a 802.1Q or 802.1ad VLAN tag.
+type VLAN struct {
+	TPID     uint16 // EtherTypeVLAN or EtherTypeQinQ, which is EtherTypeVLAN by default.
+	Priority uint8  // PCP
+	DEI      bool
+	ID       uint16
+}
+
+// Ethernet is the ethernet header with the optional VLAN tags.
+type Ethernet struct {
+	Dst net.HardwareAddr
+	Src net.HardwareAddr
+
+	// VLANs is the VLAN tags from the outermost to the innermost.
+	// For QinQ, the outer tag is the service tag with EtherTypeQinQ.
+	VLANs []VLAN
+
+	// EtherType is the type of the inner layer. If 0, it is derived
+	// from the inner layer when encoding.
+	EtherType uint16
+}
+
+func (e Ethernet) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	etherType := e.EtherType
+	if etherType == 0 {
+		switch next.(type) {
+		case ARP:
+			etherType = EtherTypeARP
+		case IPv4:
+			etherType = EtherTypeIPv4
+		case IPv6:
+			etherType = EtherTypeIPv6
+		default:
+			return nil, errors.New("missing the ether type")
+		}
+	}
+
+	b := make([]byte, 14+4*len(e.VLANs), 14+4*len(e.VLANs)+len(payload))
+	if err := putMAC(b[0:6], e.Dst); err != nil {
+		return nil, err
+	} else if err = putMAC(b[6:12], e.Src); err != nil {
+		return nil, err
+	}
+
+	offset := 12
+	for _, vlan := range e.VLANs {
+		if vlan.ID > 0xfff || vlan.Priority > 7 {
+			return nil, fmt.Errorf("invalid vlan %+v", vlan)
+		}
+
+		tpid := vlan.TPID
+		if tpid == 0 {
+			tpid = EtherTypeVLAN
+		}
+
+		tci := uint16(vlan.Priority)<<13 | vlan.ID
+		if vlan.DEI {
+			tci |= 1 << 12
+		}
+
+		binary.BigEndian.PutUint16(b[offset:], tpid)
+		binary.BigEndian.PutUint16(b[offset+2:], tci)
+		offset += 4
+	}
+
+	binary.BigEndian.PutUint16(b[offset:], etherType)
+	return append(b, payload...), nil
+}
+
+func decodeEthernet(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 14 {
+		return nil, nil, nil, errTruncated
+	}
+
+	e := Ethernet{Dst: cloneMAC(data[0:6]), Src: cloneMAC(data[6:12])}
+	data = data[12:]
+	for {
+		if len(data) < 2 {
+			return nil, nil, nil, errTruncated
+		}
+
+		etherType := binary.BigEndian.Uint16(data)
+		if etherType != EtherTypeVLAN && etherType != EtherTypeQinQ {
+			e.EtherType, data = etherType, data[2:]
+			break
+		} else if len(data) < 4 {
+			return nil, nil, nil, errTruncated
+		}
+
+		tci := binary.BigEndian.Uint16(data[2:])
+		e.VLANs = append(e.VLANs, VLAN{
+			TPID:     etherType,
+			Priority: uint8(tci >> 13),
+			DEI:      tci&(1<<12) != 0,
+			ID:       tci & 0xfff,
+		})
+		data = data[4:]
+	}
+
+	switch e.EtherType {
+	case EtherTypeARP:
+		return e, data, decodeARP, nil
+	case EtherTypeIPv4:
+		return e, data, decodeIPv4, nil
+	case EtherTypeIPv6:
+		return e, data, decodeIPv6, nil
+	default:
+		return e, data, decodePayload, nil
+	}
+}
+
+// Pre-define the operations of ARP.
+const (
+	ARPRequest = 1
+	ARPReply   = 2
+)
+
+// ARP is the ARP packet for the ethernet and IPv4.
+type ARP struct {
+	Op        uint16 // ARPRequest or ARPReply
+	SenderMAC net.HardwareAddr
+	SenderIP  netip.Addr
+	TargetMAC net.HardwareAddr // The zero MAC by default
+	TargetIP  netip.Addr
+}
+
+func (a ARP) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	b := make([]byte, 28, 28+len(payload))
+	binary.BigEndian.PutUint16(b[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(b[2:], EtherTypeIPv4)
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:], a.Op)
+
+	if err := putMAC(b[8:14], a.SenderMAC); err != nil {
+		return nil, err
+	} else if err = putAddr(b[14:18], a.SenderIP, true); err != nil {
+		return nil, err
+	} else if err = putMAC(b[18:24], a.TargetMAC); err != nil {
+		return nil, err
+	} else if err = putAddr(b[24:28], a.TargetIP, true); err != nil {
+		return nil, err
+	}
+
+	return append(b, payload...), nil
+}
+
+func decodeARP(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 28 {
+		return nil, nil, nil, errTruncated
+	} else if binary.BigEndian.Uint16(data[0:]) != 1 || data[4] != 6 ||
+		binary.BigEndian.Uint16(data[2:]) != EtherTypeIPv4 || data[5] != 4 {
+		return decodePayload(data)
+	}
+
+	a := ARP{
+		Op:        binary.BigEndian.Uint16(data[6:]),
+		SenderMAC: cloneMAC(data[8:14]),
+		SenderIP:  netip.AddrFrom4(*(*[4]byte)(data[14:18])),
+		TargetMAC: cloneMAC(data[18:24]),
+		TargetIP:  netip.AddrFrom4(*(*[4]byte)(data[24:28])),
+	}
+
+	// Ignore the ethernet padding.
+	return a, nil, nil, nil
+}
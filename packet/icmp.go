@@ -0,0 +1,250 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// Pre-define some ICMP types.
+const (
+	ICMPTypeEchoReply       = 0
+	ICMPTypeDestUnreachable = 3
+	ICMPTypeEchoRequest     = 8
+	ICMPTypeTimeExceeded    = 11
+)
+
+// Pre-define some ICMPv6 types.
+const (
+	ICMPv6TypeDestUnreachable       = 1
+	ICMPv6TypePacketTooBig          = 2
+	ICMPv6TypeTimeExceeded          = 3
+	ICMPv6TypeEchoRequest           = 128
+	ICMPv6TypeEchoReply             = 129
+	ICMPv6TypeRouterSolicitation    = 133
+	ICMPv6TypeRouterAdvertisement   = 134
+	ICMPv6TypeNeighborSolicitation  = 135
+	ICMPv6TypeNeighborAdvertisement = 136
+)
+
+// ICMP is the ICMP header for IPv4.
+//
+// ID and Seq are the identifier and the sequence number of the echo
+// request and reply, which are the rest of the header for other types.
+type ICMP struct {
+	Type uint8
+	Code uint8
+	ID   uint16
+	Seq  uint16
+}
+
+func (c ICMP) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	b := make([]byte, 8, 8+len(payload))
+	b[0], b[1] = c.Type, c.Code
+	binary.BigEndian.PutUint16(b[4:], c.ID)
+	binary.BigEndian.PutUint16(b[6:], c.Seq)
+	b = append(b, payload...)
+	binary.BigEndian.PutUint16(b[2:], checksum(b, 0))
+	return b, nil
+}
+
+func decodeICMP(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 8 {
+		return nil, nil, nil, errTruncated
+	}
+
+	c := ICMP{
+		Type: data[0],
+		Code: data[1],
+		ID:   binary.BigEndian.Uint16(data[4:]),
+		Seq:  binary.BigEndian.Uint16(data[6:]),
+	}
+	return c, data[8:], decodePayload, nil
+}
+
+// ICMPv6 is the ICMPv6 header, the message body of which is the inner
+// layer, such as NeighborSolicitation, NeighborAdvertisement or Payload.
+type ICMPv6 struct {
+	// Type is the message type. If 0, it is derived from the inner layer
+	// when encoding.
+	Type uint8
+	Code uint8
+}
+
+func (c ICMPv6) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	typ := c.Type
+	if typ == 0 {
+		switch next.(type) {
+		case NeighborSolicitation:
+			typ = ICMPv6TypeNeighborSolicitation
+		case NeighborAdvertisement:
+			typ = ICMPv6TypeNeighborAdvertisement
+		default:
+			return nil, errors.New("missing the ICMPv6 type")
+		}
+	}
+
+	b := make([]byte, 4, 4+len(payload))
+	b[0], b[1] = typ, c.Code
+	b = append(b, payload...)
+
+	sum, err := l4Checksum(b, outer, ProtoICMPv6)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[2:], sum)
+	return b, nil
+}
+
+func decodeICMPv6(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 4 {
+		return nil, nil, nil, errTruncated
+	}
+
+	c := ICMPv6{Type: data[0], Code: data[1]}
+	switch c.Type {
+	case ICMPv6TypeNeighborSolicitation:
+		return c, data[4:], decodeNeighborSolicitation, nil
+	case ICMPv6TypeNeighborAdvertisement:
+		return c, data[4:], decodeNeighborAdvertisement, nil
+	default:
+		return c, data[4:], decodePayload, nil
+	}
+}
+
+// The types of the neighbor discovery options.
+const (
+	ndOptionSourceLinkAddr = 1
+	ndOptionTargetLinkAddr = 2
+)
+
+func appendLinkAddrOption(b []byte, typ uint8, mac net.HardwareAddr) ([]byte, error) {
+	if len(mac) == 0 {
+		return b, nil
+	} else if len(mac) != 6 {
+		return nil, errors.New("invalid link-layer address")
+	}
+	return append(append(b, typ, 1), mac...), nil
+}
+
+// findLinkAddrOption returns the link-layer address in the options
+// of the neighbor discovery message.
+func findLinkAddrOption(options []byte, typ uint8) (net.HardwareAddr, error) {
+	for len(options) >= 2 {
+		length := int(options[1]) * 8
+		if length == 0 || len(options) < length {
+			return nil, errTruncated
+		}
+
+		if options[0] == typ && length == 8 {
+			return cloneMAC(options[2:8]), nil
+		}
+		options = options[length:]
+	}
+	return nil, nil
+}
+
+// NeighborSolicitation is the message body of the ICMPv6 neighbor
+// solicitation, which is the inner layer of ICMPv6.
+type NeighborSolicitation struct {
+	Target    netip.Addr
+	SourceMAC net.HardwareAddr // The source link-layer address option
+}
+
+func (ns NeighborSolicitation) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	b := make([]byte, 20, 28+len(payload))
+	if err := putAddr(b[4:20], ns.Target, false); err != nil {
+		return nil, err
+	}
+
+	b, err := appendLinkAddrOption(b, ndOptionSourceLinkAddr, ns.SourceMAC)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, payload...), nil
+}
+
+func decodeNeighborSolicitation(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 20 {
+		return nil, nil, nil, errTruncated
+	}
+
+	mac, err := findLinkAddrOption(data[20:], ndOptionSourceLinkAddr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ns := NeighborSolicitation{
+		Target:    netip.AddrFrom16(*(*[16]byte)(data[4:20])),
+		SourceMAC: mac,
+	}
+	return ns, nil, nil, nil
+}
+
+// NeighborAdvertisement is the message body of the ICMPv6 neighbor
+// advertisement, which is the inner layer of ICMPv6.
+type NeighborAdvertisement struct {
+	Router    bool
+	Solicited bool
+	Override  bool
+	Target    netip.Addr
+	TargetMAC net.HardwareAddr // The target link-layer address option
+}
+
+func (na NeighborAdvertisement) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	b := make([]byte, 20, 28+len(payload))
+	if na.Router {
+		b[0] |= 0x80
+	}
+	if na.Solicited {
+		b[0] |= 0x40
+	}
+	if na.Override {
+		b[0] |= 0x20
+	}
+
+	if err := putAddr(b[4:20], na.Target, false); err != nil {
+		return nil, err
+	}
+
+	b, err := appendLinkAddrOption(b, ndOptionTargetLinkAddr, na.TargetMAC)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, payload...), nil
+}
+
+func decodeNeighborAdvertisement(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 20 {
+		return nil, nil, nil, errTruncated
+	}
+
+	mac, err := findLinkAddrOption(data[20:], ndOptionTargetLinkAddr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	na := NeighborAdvertisement{
+		Router:    data[0]&0x80 != 0,
+		Solicited: data[0]&0x40 != 0,
+		Override:  data[0]&0x20 != 0,
+		Target:    netip.AddrFrom16(*(*[16]byte)(data[4:20])),
+		TargetMAC: mac,
+	}
+	return na, nil, nil, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// Pre-define some IP protocol numbers.
+const (
+	ProtoICMP   = 1
+	ProtoTCP    = 6
+	ProtoUDP    = 17
+	ProtoICMPv6 = 58
+)
+
+// DefaultTTL is the default TTL of IPv4 or the hop limit of IPv6
+// if it is 0 when encoding.
+const DefaultTTL = 64
+
+// Pre-define the flags of IPv4.
+const (
+	IPv4DontFragment  = 0x2
+	IPv4MoreFragments = 0x1
+)
+
+// IPv4 is the IPv4 header.
+type IPv4 struct {
+	TOS        uint8
+	ID         uint16
+	Flags      uint8  // Such as IPv4DontFragment
+	FragOffset uint16 // In the unit of 8 bytes
+	TTL        uint8  // If 0, use DefaultTTL when encoding.
+
+	// Protocol is the protocol of the inner layer. If 0, it is derived
+	// from the inner layer when encoding.
+	Protocol uint8
+
+	Src     netip.Addr
+	Dst     netip.Addr
+	Options []byte // It is padded to the multiple of 4 bytes when encoding.
+}
+
+func ipProtocol(next Layer) (uint8, error) {
+	switch next.(type) {
+	case ICMP:
+		return ProtoICMP, nil
+	case ICMPv6:
+		return ProtoICMPv6, nil
+	case TCP:
+		return ProtoTCP, nil
+	case UDP:
+		return ProtoUDP, nil
+	default:
+		return 0, errors.New("missing the IP protocol")
+	}
+}
+
+func (ip IPv4) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	proto := ip.Protocol
+	if proto == 0 {
+		var err error
+		if proto, err = ipProtocol(next); err != nil {
+			return nil, err
+		}
+	}
+
+	hlen := 20 + (len(ip.Options)+3)/4*4
+	if hlen > 60 {
+		return nil, errors.New("the IPv4 options are too long")
+	} else if hlen+len(payload) > 0xffff {
+		return nil, errors.New("the IPv4 packet is too long")
+	}
+
+	ttl := ip.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	b := make([]byte, hlen, hlen+len(payload))
+	b[0] = 0x40 | uint8(hlen/4)
+	b[1] = ip.TOS
+	binary.BigEndian.PutUint16(b[2:], uint16(hlen+len(payload)))
+	binary.BigEndian.PutUint16(b[4:], ip.ID)
+	binary.BigEndian.PutUint16(b[6:], uint16(ip.Flags&0x7)<<13|ip.FragOffset&0x1fff)
+	b[8], b[9] = ttl, proto
+	if err := putAddr(b[12:16], ip.Src, true); err != nil {
+		return nil, err
+	} else if err = putAddr(b[16:20], ip.Dst, true); err != nil {
+		return nil, err
+	}
+	copy(b[20:], ip.Options)
+	binary.BigEndian.PutUint16(b[10:], checksum(b, 0))
+
+	return append(b, payload...), nil
+}
+
+func decodeIPv4(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 20 {
+		return nil, nil, nil, errTruncated
+	} else if data[0]>>4 != 4 {
+		return nil, nil, nil, fmt.Errorf("invalid IPv4 version %d", data[0]>>4)
+	}
+
+	hlen := int(data[0]&0xf) * 4
+	total := int(binary.BigEndian.Uint16(data[2:]))
+	if hlen < 20 || total < hlen {
+		return nil, nil, nil, errors.New("invalid IPv4 header length")
+	} else if len(data) < total {
+		return nil, nil, nil, errTruncated
+	}
+
+	flags := binary.BigEndian.Uint16(data[6:])
+	ip := IPv4{
+		TOS:        data[1],
+		ID:         binary.BigEndian.Uint16(data[4:]),
+		Flags:      uint8(flags >> 13),
+		FragOffset: flags & 0x1fff,
+		TTL:        data[8],
+		Protocol:   data[9],
+		Src:        netip.AddrFrom4(*(*[4]byte)(data[12:16])),
+		Dst:        netip.AddrFrom4(*(*[4]byte)(data[16:20])),
+	}
+	if hlen > 20 {
+		ip.Options = append([]byte{}, data[20:hlen]...)
+	}
+
+	// Remove the ethernet padding.
+	data = data[hlen:total]
+	if ip.FragOffset > 0 {
+		return ip, data, decodePayload, nil
+	}
+	return ip, data, ipDecoder(ip.Protocol, false), nil
+}
+
+func ipDecoder(proto uint8, isIPv6 bool) decoder {
+	switch {
+	case proto == ProtoICMP && !isIPv6:
+		return decodeICMP
+	case proto == ProtoICMPv6 && isIPv6:
+		return decodeICMPv6
+	case proto == ProtoTCP:
+		return decodeTCP
+	case proto == ProtoUDP:
+		return decodeUDP
+	default:
+		return decodePayload
+	}
+}
+
+// IPv6 is the IPv6 header without the extension headers.
+type IPv6 struct {
+	TrafficClass uint8
+	FlowLabel    uint32
+	HopLimit     uint8 // If 0, use DefaultTTL when encoding.
+
+	// NextHeader is the protocol of the inner layer. If 0, it is derived
+	// from the inner layer when encoding.
+	NextHeader uint8
+
+	Src netip.Addr
+	Dst netip.Addr
+}
+
+func (ip IPv6) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	proto := ip.NextHeader
+	if proto == 0 {
+		var err error
+		if proto, err = ipProtocol(next); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(payload) > 0xffff {
+		return nil, errors.New("the IPv6 payload is too long")
+	} else if ip.FlowLabel > 0xfffff {
+		return nil, fmt.Errorf("invalid IPv6 flow label %d", ip.FlowLabel)
+	}
+
+	hopLimit := ip.HopLimit
+	if hopLimit == 0 {
+		hopLimit = DefaultTTL
+	}
+
+	b := make([]byte, 40, 40+len(payload))
+	binary.BigEndian.PutUint32(b[0:], 6<<28|uint32(ip.TrafficClass)<<20|ip.FlowLabel)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(payload)))
+	b[6], b[7] = proto, hopLimit
+	if err := putAddr(b[8:24], ip.Src, false); err != nil {
+		return nil, err
+	} else if err = putAddr(b[24:40], ip.Dst, false); err != nil {
+		return nil, err
+	}
+
+	return append(b, payload...), nil
+}
+
+func decodeIPv6(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 40 {
+		return nil, nil, nil, errTruncated
+	}
+
+	first := binary.BigEndian.Uint32(data)
+	if first>>28 != 6 {
+		return nil, nil, nil, fmt.Errorf("invalid IPv6 version %d", first>>28)
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 40+length {
+		return nil, nil, nil, errTruncated
+	}
+
+	ip := IPv6{
+		TrafficClass: uint8(first >> 20),
+		FlowLabel:    first & 0xfffff,
+		NextHeader:   data[6],
+		HopLimit:     data[7],
+		Src:          netip.AddrFrom16(*(*[16]byte)(data[8:24])),
+		Dst:          netip.AddrFrom16(*(*[16]byte)(data[24:40])),
+	}
+
+	// Remove the ethernet padding.
+	return ip, data[40 : 40+length], ipDecoder(ip.NextHeader, true), nil
+}
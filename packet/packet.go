@@ -0,0 +1,236 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package packet supplies a small encoder and decoder of the packet headers,
+// which are used by the payloads of packet-out and packet-in.
+//
+// A packet is a list of the layers from the outermost to the innermost,
+// such as Ethernet, IPv4, UDP and DHCPv4. When encoding, the fields
+// derived from the inner layers, such as the ether type, the IP protocol,
+// the lengths and the checksums, are computed automatically if they are
+// zero. For example,
+//
+//	data, err := packet.Encode(
+//		packet.Ethernet{Dst: packet.BroadcastMAC, Src: mac},
+//		packet.ARP{Op: packet.ARPRequest, SenderMAC: mac, SenderIP: ip, TargetIP: ip},
+//	)
+package packet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// BroadcastMAC is the broadcast MAC address.
+var BroadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+var errTruncated = errors.New("the packet is truncated")
+
+// Layer is a protocol header of the packet, which is one of Ethernet, ARP,
+// IPv4, IPv6, ICMP, ICMPv6, NeighborSolicitation, NeighborAdvertisement,
+// UDP, TCP, DHCPv4, VXLAN, GENEVE and Payload.
+type Layer interface {
+	// encode returns the layer with the payload, that's, the encoded
+	// inner layers. outer is the outer layers and next is the inner layer,
+	// which may be nil.
+	encode(payload []byte, outer []Layer, next Layer) ([]byte, error)
+}
+
+// Payload is the raw data of the packet, which is not decoded.
+type Payload []byte
+
+func (p Payload) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	return append(append([]byte{}, p...), payload...), nil
+}
+
+// Encode encodes the layers from the outermost to the innermost
+// as the raw packet.
+func Encode(layers ...Layer) (data []byte, err error) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		var next Layer
+		if i+1 < len(layers) {
+			next = layers[i+1]
+		}
+
+		if data, err = layers[i].encode(data, layers[:i], next); err != nil {
+			return nil, fmt.Errorf("%T: %v", layers[i], err)
+		}
+	}
+	return
+}
+
+// EncodeHex is the same as Encode, but returns the hex string,
+// which may be used by "ovs-ofctl packet-out".
+func EncodeHex(layers ...Layer) (string, error) {
+	data, err := Encode(layers...)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// ParseHex parses the hex string to the raw packet.
+//
+// The hex string may contain the separators, such as ":", "." or the spaces,
+// or be the hex dump of ovs-ofctl, like
+//
+//	00000000  ff ff ff ff ff ff 00 00-00 00 00 01 08 06 00 01 |................|
+//	00000010  08 00 06 04 00 01 00 00-00 00 00 01 0a 00 00 01 |................|
+func ParseHex(s string) ([]byte, error) {
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 10 && line[8:10] == "  " {
+			if _, err := hex.DecodeString(line[:8]); err == nil {
+				line = line[10:] // Remove the offset of the hex dump.
+			}
+		}
+
+		if index := strings.IndexByte(line, '|'); index > -1 {
+			line = line[:index] // Remove the ascii part of the hex dump.
+		}
+
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "0x"), "0X")
+		for _, c := range line {
+			switch c {
+			case ' ', '\t', '\r', ':', '.', '-':
+			default:
+				b.WriteRune(c)
+			}
+		}
+	}
+	return hex.DecodeString(b.String())
+}
+
+// DecodeHex is the same as Decode, but parses the packet by ParseHex.
+func DecodeHex(s string) ([]Layer, error) {
+	data, err := ParseHex(s)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Find returns the first layer with the type T in the layers.
+func Find[T Layer](layers []Layer) (layer T, ok bool) {
+	for _, l := range layers {
+		if layer, ok = l.(T); ok {
+			return
+		}
+	}
+	return
+}
+
+type decoder func(data []byte) (layer Layer, rest []byte, next decoder, err error)
+
+// Decode decodes the ethernet packet to the layers from the outermost
+// to the innermost.
+//
+// The data which cannot be decoded is returned as Payload at last.
+// If the packet is truncated, return the decoded layers and the error.
+func Decode(data []byte) (layers []Layer, err error) {
+	var layer Layer
+	for next := decoder(decodeEthernet); next != nil && len(data) > 0; {
+		if layer, data, next, err = next(data); err != nil {
+			return
+		}
+		layers = append(layers, layer)
+	}
+
+	if len(data) > 0 {
+		layers = append(layers, Payload(data))
+	}
+	return
+}
+
+func decodePayload(data []byte) (Layer, []byte, decoder, error) {
+	return Payload(data), nil, nil, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+
+// checksum returns the internet checksum of the data with the initial sum.
+func checksum(data []byte, sum uint32) uint16 {
+	for ; len(data) >= 2; data = data[2:] {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// pseudoHeaderSum returns the sum of the pseudo header of IPv4 or IPv6.
+func pseudoHeaderSum(src, dst netip.Addr, proto uint8, length int) (sum uint32) {
+	for _, addr := range []netip.Addr{src, dst} {
+		b := addr.AsSlice()
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+	}
+	return sum + uint32(proto) + uint32(length)
+}
+
+// l4Checksum returns the checksum of the transport layer data,
+// the pseudo header of which is from the nearest IPv4 or IPv6 layer in outer.
+func l4Checksum(data []byte, outer []Layer, proto uint8) (uint16, error) {
+	for i := len(outer) - 1; i >= 0; i-- {
+		switch ip := outer[i].(type) {
+		case IPv4:
+			return checksum(data, pseudoHeaderSum(ip.Src, ip.Dst, proto, len(data))), nil
+		case IPv6:
+			return checksum(data, pseudoHeaderSum(ip.Src, ip.Dst, proto, len(data))), nil
+		}
+	}
+	return 0, errors.New("missing the outer IP layer to compute the checksum")
+}
+
+func putAddr(b []byte, addr netip.Addr, is4 bool) error {
+	switch {
+	case is4 && addr.Is4():
+		a := addr.As4()
+		copy(b, a[:])
+	case !is4 && addr.Is6():
+		a := addr.As16()
+		copy(b, a[:])
+	case !addr.IsValid():
+		return errors.New("missing the IP address")
+	default:
+		return fmt.Errorf("unexpected IP address '%s'", addr)
+	}
+	return nil
+}
+
+func putMAC(b []byte, mac net.HardwareAddr) error {
+	switch len(mac) {
+	case 0:
+	case 6:
+		copy(b, mac)
+	default:
+		return fmt.Errorf("invalid MAC address '%s'", mac)
+	}
+	return nil
+}
+
+func cloneMAC(b []byte) net.HardwareAddr {
+	return append(net.HardwareAddr{}, b...)
+}
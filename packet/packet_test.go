@@ -0,0 +1,217 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+var (
+	mac1 = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	mac2 = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ip1  = netip.MustParseAddr("10.0.0.1")
+	ip2  = netip.MustParseAddr("10.0.0.2")
+	ip61 = netip.MustParseAddr("fe80::1")
+	ip62 = netip.MustParseAddr("fe80::2")
+)
+
+func ExampleEncodeHex() {
+	s, _ := EncodeHex(
+		Ethernet{Dst: BroadcastMAC, Src: mac1, VLANs: []VLAN{{ID: 100}}},
+		ARP{Op: ARPRequest, SenderMAC: mac1, SenderIP: ip1, TargetIP: ip2},
+	)
+	fmt.Println(s)
+
+	// Output:
+	// ffffffffffff00000000000181000064080600010800060400010000000000010a0000010000000000000a000002
+}
+
+func testRoundTrip(t *testing.T, layers ...Layer) []byte {
+	data, err := Encode(layers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, layers) {
+		t.Errorf("expect %+v, but got %+v", layers, decoded)
+	}
+	return data
+}
+
+func TestCodecIPv4(t *testing.T) {
+	data := testRoundTrip(t,
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv4, VLANs: []VLAN{
+			{TPID: EtherTypeQinQ, ID: 10, Priority: 3},
+			{TPID: EtherTypeVLAN, ID: 20, DEI: true},
+		}},
+		IPv4{ID: 1, Flags: IPv4DontFragment, TTL: 64, Protocol: ProtoTCP, Src: ip1, Dst: ip2},
+		TCP{SrcPort: 34567, DstPort: 80, Seq: 1, Flags: TCPFlagSYN, Window: 1024,
+			Options: []byte{2, 4, 5, 0xb4}},
+		Payload("hello"),
+	)
+
+	ip := data[22:42]
+	if checksum(ip, 0) != 0 {
+		t.Errorf("invalid IPv4 checksum")
+	}
+
+	tcp := data[42:]
+	if checksum(tcp, pseudoHeaderSum(ip1, ip2, ProtoTCP, len(tcp))) != 0 {
+		t.Errorf("invalid TCP checksum")
+	}
+
+	data = testRoundTrip(t,
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv4},
+		IPv4{TTL: 64, Protocol: ProtoICMP, Src: ip1, Dst: ip2},
+		ICMP{Type: ICMPTypeEchoRequest, ID: 1, Seq: 2},
+		Payload("ping"),
+	)
+	if checksum(data[34:], 0) != 0 {
+		t.Errorf("invalid ICMP checksum")
+	}
+}
+
+func TestCodecIPv6(t *testing.T) {
+	data := testRoundTrip(t,
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv6},
+		IPv6{HopLimit: 255, NextHeader: ProtoICMPv6, Src: ip61, Dst: ip62},
+		ICMPv6{Type: ICMPv6TypeNeighborAdvertisement},
+		NeighborAdvertisement{Solicited: true, Override: true, Target: ip61, TargetMAC: mac1},
+	)
+
+	icmp := data[54:]
+	if checksum(icmp, pseudoHeaderSum(ip61, ip62, ProtoICMPv6, len(icmp))) != 0 {
+		t.Errorf("invalid ICMPv6 checksum")
+	}
+
+	testRoundTrip(t,
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv6},
+		IPv6{HopLimit: 255, NextHeader: ProtoICMPv6, Src: ip61, Dst: ip62},
+		ICMPv6{Type: ICMPv6TypeNeighborSolicitation},
+		NeighborSolicitation{Target: ip62, SourceMAC: mac1},
+	)
+
+	testRoundTrip(t,
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv6},
+		IPv6{TrafficClass: 1, FlowLabel: 2, HopLimit: 64, NextHeader: ProtoUDP, Src: ip61, Dst: ip62},
+		UDP{SrcPort: 1234, DstPort: 53},
+		Payload("dns"),
+	)
+}
+
+func TestCodecDHCPv4(t *testing.T) {
+	zero := netip.AddrFrom4([4]byte{})
+	data := testRoundTrip(t,
+		Ethernet{Dst: BroadcastMAC, Src: mac1, EtherType: EtherTypeIPv4},
+		IPv4{TTL: 64, Protocol: ProtoUDP, Src: zero, Dst: netip.AddrFrom4([4]byte{255, 255, 255, 255})},
+		UDP{SrcPort: PortDHCPClient, DstPort: PortDHCPServer},
+		DHCPv4{
+			Op:        DHCPBootRequest,
+			XID:       0x12345678,
+			Flags:     0x8000,
+			CIAddr:    zero,
+			YIAddr:    zero,
+			SIAddr:    zero,
+			GIAddr:    zero,
+			ClientMAC: mac1,
+			Options: []DHCPOption{
+				{Code: DHCPOptionMessageType, Data: []byte{DHCPDiscover}},
+				{Code: DHCPOptionParamRequest, Data: []byte{1, 3, 6}},
+			},
+		},
+	)
+
+	layers, _ := Decode(data)
+	if dhcp, ok := Find[DHCPv4](layers); !ok || dhcp.MessageType() != DHCPDiscover {
+		t.Errorf("unexpected DHCP %+v", dhcp)
+	}
+}
+
+func TestCodecTunnel(t *testing.T) {
+	inner := []Layer{
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv4},
+		IPv4{TTL: 64, Protocol: ProtoICMP, Src: ip1, Dst: ip2},
+		ICMP{Type: ICMPTypeEchoReply},
+	}
+
+	outer := netip.MustParseAddr("192.168.0.1")
+	testRoundTrip(t, append([]Layer{
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv4},
+		IPv4{TTL: 64, Protocol: ProtoUDP, Src: outer, Dst: outer},
+		UDP{SrcPort: 50000, DstPort: PortVXLAN},
+		VXLAN{VNI: 100},
+	}, inner...)...)
+
+	testRoundTrip(t, append([]Layer{
+		Ethernet{Dst: mac2, Src: mac1, EtherType: EtherTypeIPv4},
+		IPv4{TTL: 64, Protocol: ProtoUDP, Src: outer, Dst: outer},
+		UDP{SrcPort: 50000, DstPort: PortGENEVE},
+		GENEVE{Protocol: EtherTypeEthernet, VNI: 200, Critical: true, Options: []byte{1, 2, 3, 4}},
+	}, inner...)...)
+
+	// Derive the fields from the inner layers.
+	data, err := Encode(Ethernet{}, IPv4{Src: outer, Dst: outer}, UDP{}, GENEVE{VNI: 1}, inner[0], inner[1], inner[2])
+	if err != nil {
+		t.Fatal(err)
+	} else if port := binary.BigEndian.Uint16(data[36:]); port != PortGENEVE {
+		t.Errorf("expect the GENEVE port, but got %d", port)
+	} else if proto := binary.BigEndian.Uint16(data[44:]); proto != EtherTypeEthernet {
+		t.Errorf("expect the GENEVE protocol 0x6558, but got 0x%x", proto)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	expect := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 1, 8, 6, 0, 1, 8, 0}
+	for _, s := range []string{
+		"ffffffffffff0000000000010806000108 00",
+		"ff:ff:ff:ff:ff:ff:00:00:00:00:00:01:08:06:00:01:08:00",
+		`00000000  ff ff ff ff ff ff 00 00-00 00 00 01 08 06 00 01 |................|
+00000010  08 00                                           |..|`,
+	} {
+		if data, err := ParseHex(s); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(data, expect) {
+			t.Errorf("expect %x, but got %x", expect, data)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data, _ := Encode(
+		Ethernet{Dst: mac2, Src: mac1},
+		IPv4{Src: ip1, Dst: ip2},
+		UDP{SrcPort: 1, DstPort: 2},
+	)
+
+	layers, err := Decode(data[:30])
+	if err == nil || len(layers) != 1 {
+		t.Errorf("expect the truncated error with 1 layer, but got %v, %+v", err, layers)
+	}
+
+	// The ethernet padding is ignored.
+	layers, err = Decode(append(data, 0, 0, 0, 0))
+	if err != nil || len(layers) != 3 {
+		t.Errorf("unexpected layers %+v: %v", layers, err)
+	}
+}
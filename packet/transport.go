@@ -0,0 +1,167 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Pre-define some well-known UDP ports.
+const (
+	PortDHCPServer = 67
+	PortDHCPClient = 68
+	PortVXLAN      = 4789
+	PortGENEVE     = 6081
+)
+
+// UDP is the UDP header.
+type UDP struct {
+	SrcPort uint16
+
+	// DstPort is the destination port. If 0 and the inner layer is VXLAN
+	// or GENEVE, it is the well-known port of the tunnel when encoding.
+	DstPort uint16
+}
+
+func (u UDP) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	dstPort := u.DstPort
+	if dstPort == 0 {
+		switch next.(type) {
+		case VXLAN:
+			dstPort = PortVXLAN
+		case GENEVE:
+			dstPort = PortGENEVE
+		}
+	}
+
+	if 8+len(payload) > 0xffff {
+		return nil, errors.New("the UDP packet is too long")
+	}
+
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(b[0:], u.SrcPort)
+	binary.BigEndian.PutUint16(b[2:], dstPort)
+	binary.BigEndian.PutUint16(b[4:], uint16(8+len(payload)))
+	b = append(b, payload...)
+
+	sum, err := l4Checksum(b, outer, ProtoUDP)
+	if err != nil {
+		return nil, err
+	} else if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(b[6:], sum)
+	return b, nil
+}
+
+func decodeUDP(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 8 {
+		return nil, nil, nil, errTruncated
+	}
+
+	u := UDP{
+		SrcPort: binary.BigEndian.Uint16(data[0:]),
+		DstPort: binary.BigEndian.Uint16(data[2:]),
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length < 8 || len(data) < length {
+		return nil, nil, nil, errTruncated
+	}
+
+	next := decoder(decodePayload)
+	switch {
+	case u.DstPort == PortVXLAN:
+		next = decodeVXLAN
+	case u.DstPort == PortGENEVE:
+		next = decodeGENEVE
+	case u.DstPort == PortDHCPServer || u.DstPort == PortDHCPClient:
+		next = decodeDHCPv4
+	}
+	return u, data[8:length], next, nil
+}
+
+// Pre-define the TCP flags.
+const (
+	TCPFlagFIN = 0x01
+	TCPFlagSYN = 0x02
+	TCPFlagRST = 0x04
+	TCPFlagPSH = 0x08
+	TCPFlagACK = 0x10
+	TCPFlagURG = 0x20
+)
+
+// TCP is the TCP header.
+type TCP struct {
+	SrcPort uint16
+	DstPort uint16
+	Seq     uint32
+	Ack     uint32
+	Flags   uint8 // Such as TCPFlagSYN|TCPFlagACK
+	Window  uint16
+	Urgent  uint16
+	Options []byte // It is padded to the multiple of 4 bytes when encoding.
+}
+
+func (t TCP) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	hlen := 20 + (len(t.Options)+3)/4*4
+	if hlen > 60 {
+		return nil, errors.New("the TCP options are too long")
+	}
+
+	b := make([]byte, hlen, hlen+len(payload))
+	binary.BigEndian.PutUint16(b[0:], t.SrcPort)
+	binary.BigEndian.PutUint16(b[2:], t.DstPort)
+	binary.BigEndian.PutUint32(b[4:], t.Seq)
+	binary.BigEndian.PutUint32(b[8:], t.Ack)
+	b[12], b[13] = uint8(hlen/4)<<4, t.Flags
+	binary.BigEndian.PutUint16(b[14:], t.Window)
+	binary.BigEndian.PutUint16(b[18:], t.Urgent)
+	copy(b[20:], t.Options)
+	b = append(b, payload...)
+
+	sum, err := l4Checksum(b, outer, ProtoTCP)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[16:], sum)
+	return b, nil
+}
+
+func decodeTCP(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 20 {
+		return nil, nil, nil, errTruncated
+	}
+
+	hlen := int(data[12]>>4) * 4
+	if hlen < 20 || len(data) < hlen {
+		return nil, nil, nil, errTruncated
+	}
+
+	t := TCP{
+		SrcPort: binary.BigEndian.Uint16(data[0:]),
+		DstPort: binary.BigEndian.Uint16(data[2:]),
+		Seq:     binary.BigEndian.Uint32(data[4:]),
+		Ack:     binary.BigEndian.Uint32(data[8:]),
+		Flags:   data[13],
+		Window:  binary.BigEndian.Uint16(data[14:]),
+		Urgent:  binary.BigEndian.Uint16(data[18:]),
+	}
+	if hlen > 20 {
+		t.Options = append([]byte{}, data[20:hlen]...)
+	}
+	return t, data[hlen:], decodePayload, nil
+}
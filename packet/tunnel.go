@@ -0,0 +1,124 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// VXLAN is the VXLAN header, the inner layer of which is Ethernet.
+type VXLAN struct {
+	VNI uint32
+}
+
+func (v VXLAN) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	if v.VNI > 0xffffff {
+		return nil, fmt.Errorf("invalid VXLAN VNI %d", v.VNI)
+	}
+
+	b := make([]byte, 8, 8+len(payload))
+	b[0] = 0x08 // The VNI is valid.
+	binary.BigEndian.PutUint32(b[4:], v.VNI<<8)
+	return append(b, payload...), nil
+}
+
+func decodeVXLAN(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 8 {
+		return nil, nil, nil, errTruncated
+	}
+	return VXLAN{VNI: binary.BigEndian.Uint32(data[4:]) >> 8}, data[8:], decodeEthernet, nil
+}
+
+// GENEVE is the GENEVE header.
+type GENEVE struct {
+	OAM      bool
+	Critical bool
+
+	// Protocol is the ether type of the inner layer. If 0, it is derived
+	// from the inner layer when encoding, such as EtherTypeEthernet.
+	Protocol uint16
+
+	VNI     uint32
+	Options []byte // The raw options, the length of which must be the multiple of 4.
+}
+
+func (g GENEVE) encode(payload []byte, outer []Layer, next Layer) ([]byte, error) {
+	protocol := g.Protocol
+	if protocol == 0 {
+		switch next.(type) {
+		case Ethernet:
+			protocol = EtherTypeEthernet
+		case IPv4:
+			protocol = EtherTypeIPv4
+		case IPv6:
+			protocol = EtherTypeIPv6
+		default:
+			return nil, errors.New("missing the GENEVE protocol")
+		}
+	}
+
+	if g.VNI > 0xffffff {
+		return nil, fmt.Errorf("invalid GENEVE VNI %d", g.VNI)
+	} else if len(g.Options)%4 != 0 || len(g.Options) > 63*4 {
+		return nil, errors.New("invalid GENEVE options")
+	}
+
+	b := make([]byte, 8, 8+len(g.Options)+len(payload))
+	b[0] = uint8(len(g.Options) / 4)
+	if g.OAM {
+		b[1] |= 0x80
+	}
+	if g.Critical {
+		b[1] |= 0x40
+	}
+	binary.BigEndian.PutUint16(b[2:], protocol)
+	binary.BigEndian.PutUint32(b[4:], g.VNI<<8)
+	b = append(b, g.Options...)
+	return append(b, payload...), nil
+}
+
+func decodeGENEVE(data []byte) (Layer, []byte, decoder, error) {
+	if len(data) < 8 {
+		return nil, nil, nil, errTruncated
+	}
+
+	hlen := 8 + int(data[0]&0x3f)*4
+	if len(data) < hlen {
+		return nil, nil, nil, errTruncated
+	}
+
+	g := GENEVE{
+		OAM:      data[1]&0x80 != 0,
+		Critical: data[1]&0x40 != 0,
+		Protocol: binary.BigEndian.Uint16(data[2:]),
+		VNI:      binary.BigEndian.Uint32(data[4:]) >> 8,
+	}
+	if hlen > 8 {
+		g.Options = append([]byte{}, data[8:hlen]...)
+	}
+
+	switch g.Protocol {
+	case EtherTypeEthernet:
+		return g, data[hlen:], decodeEthernet, nil
+	case EtherTypeIPv4:
+		return g, data[hlen:], decodeIPv4, nil
+	case EtherTypeIPv6:
+		return g, data[hlen:], decodeIPv6, nil
+	default:
+		return g, data[hlen:], decodePayload, nil
+	}
+}
@@ -14,7 +14,12 @@
 
 package ovs
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 func hexStr(num int) string {
 	return fmt.Sprintf("%#04x", num)
@@ -208,6 +213,9 @@ func genRulesPortMax(maxPort, topBit int) []string {
 // written in hexadecimal prefixed by 0x. Each 1-bit in mask requires that
 // the corresponding bit in port must match. Each 0-bit in mask causes the
 // corresponding bit to be ignored.
+//
+// It panics if maxPort is smaller than minPort. See PortRangeMasks,
+// which returns the typed result and an error.
 func PortRuleMasking(minPort, maxPort int) []string {
 	// Let binary representation of minPort and maxPort be n bits long and
 	// have first m bits in common, 0 <= m <= n.
@@ -260,3 +268,147 @@ func PortRuleMasking(minPort, maxPort int) []string {
 	// sort.Strings(rules)
 	return rules
 }
+
+// ValueMask is a bitwise match of the 16-bit field, such as the transport port.
+//
+// Each 1-bit in Mask requires that the corresponding bit in Value must match.
+// Each 0-bit in Mask causes the corresponding bit to be ignored.
+type ValueMask struct {
+	Value uint16
+	Mask  uint16
+}
+
+// String formats the value and mask as "0xVVVV/0xMMMM",
+// or "0xVVVV" if Mask is 0xffff.
+func (vm ValueMask) String() string {
+	if vm.Mask == 0xffff {
+		return hexStr(int(vm.Value))
+	}
+	return fmt.Sprintf("%s/%s", hexStr(int(vm.Value)), hexStr(int(vm.Mask)))
+}
+
+// PortRange is a range of the transport ports, that's, [Min, Max].
+type PortRange struct {
+	Min int
+	Max int
+}
+
+func (r PortRange) validate() error {
+	switch {
+	case r.Min < 0 || r.Min > 65535:
+		return fmt.Errorf("the min port %d is out of [0, 65535]", r.Min)
+	case r.Max < 0 || r.Max > 65535:
+		return fmt.Errorf("the max port %d is out of [0, 65535]", r.Max)
+	case r.Max < r.Min:
+		return fmt.Errorf("the max port %d is smaller than the min port %d", r.Max, r.Min)
+	default:
+		return nil
+	}
+}
+
+// ParsePortRanges parses the port ranges separated by the comma,
+// such as "22,80,1000-1999,8000-8100".
+func ParsePortRanges(s string) (ranges []PortRange, err error) {
+	items := strings.Split(s, ",")
+	ranges = make([]PortRange, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		var r PortRange
+		min, max, isRange := strings.Cut(item, "-")
+		if r.Min, err = strconv.Atoi(strings.TrimSpace(min)); err != nil {
+			return nil, fmt.Errorf("invalid port range '%s'", item)
+		}
+
+		if !isRange {
+			r.Max = r.Min
+		} else if r.Max, err = strconv.Atoi(strings.TrimSpace(max)); err != nil {
+			return nil, fmt.Errorf("invalid port range '%s'", item)
+		}
+
+		if err = r.validate(); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	return
+}
+
+// MergePortRanges sorts and merges the overlapping or adjacent port ranges.
+func MergePortRanges(ranges ...PortRange) ([]PortRange, error) {
+	for _, r := range ranges {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]PortRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min < sorted[j].Min })
+
+	merged := make([]PortRange, 0, len(sorted))
+	for _, r := range sorted {
+		if last := len(merged) - 1; last >= 0 && r.Min <= merged[last].Max+1 {
+			if r.Max > merged[last].Max {
+				merged[last].Max = r.Max
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
+
+// PortRangeMasks is the same as PortRuleMasking, but returns the typed
+// bitwise matches instead of the formatted strings and an error instead of
+// panicking.
+//
+// It supports the multiple ranges which may be overlapping, which will be
+// merged and covered by the minimal set of the bitwise matches.
+// The result is sorted by the value.
+func PortRangeMasks(ranges ...PortRange) ([]ValueMask, error) {
+	merged, err := MergePortRanges(ranges...)
+	if err != nil {
+		return nil, err
+	}
+
+	var masks []ValueMask
+	for _, r := range merged {
+		masks = appendRangeMasks(masks, uint32(r.Min), uint32(r.Max))
+	}
+	return masks, nil
+}
+
+// PortRangeMasksExcept is the complement of PortRangeMasks, which returns
+// the bitwise matches covering all the ports in [0, 65535] except the ranges.
+func PortRangeMasksExcept(ranges ...PortRange) ([]ValueMask, error) {
+	merged, err := MergePortRanges(ranges...)
+	if err != nil {
+		return nil, err
+	}
+
+	var min uint32
+	var masks []ValueMask
+	for _, r := range merged {
+		if uint32(r.Min) > min {
+			masks = appendRangeMasks(masks, min, uint32(r.Min)-1)
+		}
+		min = uint32(r.Max) + 1
+	}
+	if min <= 0xffff {
+		masks = appendRangeMasks(masks, min, 0xffff)
+	}
+	return masks, nil
+}
+
+// appendRangeMasks appends the minimal set of the bitwise matches covering
+// the port range [min, max].
+func appendRangeMasks(masks []ValueMask, min, max uint32) []ValueMask {
+	for _, m := range appendRangeMasks128(nil, 16, Uint128{Lo: uint64(min)}, Uint128{Lo: uint64(max)}) {
+		masks = append(masks, ValueMask{Value: uint16(m.Value.Lo), Mask: uint16(m.Mask.Lo)})
+	}
+	return masks
+}
@@ -14,7 +14,10 @@
 
 package ovs
 
-import "fmt"
+import (
+	"fmt"
+	"testing"
+)
 
 func ExamplePortRuleMasking() {
 	ports := PortRuleMasking(1000, 1999)
@@ -37,3 +40,82 @@ func ExamplePortRuleMasking() {
 	// 0x0780/0xffc0
 	// 0x07c0/0xfff0
 }
+
+func ExamplePortRangeMasks() {
+	ranges, err := ParsePortRanges("22,80,1000-1999,1500-2047,8000-8100")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	masks, err := PortRangeMasks(ranges...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, mask := range masks {
+		fmt.Println(mask)
+	}
+
+	// Output:
+	// 0x0016
+	// 0x0050
+	// 0x03e8/0xfff8
+	// 0x03f0/0xfff0
+	// 0x0400/0xfc00
+	// 0x1f40/0xffc0
+	// 0x1f80/0xffe0
+	// 0x1fa0/0xfffc
+	// 0x1fa4
+}
+
+func TestPortRangeMasks(t *testing.T) {
+	if _, err := PortRangeMasks(PortRange{Min: 10, Max: 1}); err == nil {
+		t.Errorf("expect an error for the range [10, 1]")
+	}
+	if _, err := PortRangeMasks(PortRange{Min: 1, Max: 65536}); err == nil {
+		t.Errorf("expect an error for the range [1, 65536]")
+	}
+	if _, err := ParsePortRanges("1-a"); err == nil {
+		t.Errorf("expect an error for the range '1-a'")
+	}
+
+	ranges := []PortRange{{22, 22}, {80, 80}, {1000, 1999}, {1500, 2047}, {8000, 8100}}
+	in := func(port int) bool {
+		for _, r := range ranges {
+			if port >= r.Min && port <= r.Max {
+				return true
+			}
+		}
+		return false
+	}
+
+	masks, _ := PortRangeMasks(ranges...)
+	excepts, _ := PortRangeMasksExcept(ranges...)
+	for port := 0; port <= 0xffff; port++ {
+		var matched, excepted int
+		for _, m := range masks {
+			if uint16(port)&m.Mask == m.Value {
+				matched++
+			}
+		}
+		for _, m := range excepts {
+			if uint16(port)&m.Mask == m.Value {
+				excepted++
+			}
+		}
+
+		if in(port) {
+			if matched != 1 || excepted != 0 {
+				t.Fatalf("port %d: matched=%d, excepted=%d", port, matched, excepted)
+			}
+		} else if matched != 0 || excepted != 1 {
+			t.Fatalf("port %d: matched=%d, excepted=%d", port, matched, excepted)
+		}
+	}
+
+	if masks, _ = PortRangeMasksExcept(); len(masks) != 1 || masks[0] != (ValueMask{}) {
+		t.Errorf("expect all the ports, but got %v", masks)
+	}
+}
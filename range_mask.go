@@ -0,0 +1,128 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// FieldMask is a bitwise match of the field whose width is Bits,
+// such as 12 for VLAN ID, 24 for VNI, 32 for register, 128 for IPv6, etc.
+type FieldMask struct {
+	Bits  int
+	Value Uint128
+	Mask  Uint128
+}
+
+// PrefixLen returns the number of the leading 1-bits of the mask
+// in the field width.
+func (m FieldMask) PrefixLen() int {
+	return m.Bits - m.Mask.Not().And(Uint128Ones(m.Bits)).Add(Uint128{Lo: 1}).TrailingZeros()
+}
+
+// String formats the value and mask as the hexadecimal "VALUE/MASK",
+// or "VALUE" if all the bits of the mask in the field width are 1.
+func (m FieldMask) String() string {
+	if m.Mask == Uint128Ones(m.Bits) {
+		return m.Value.String()
+	}
+	return fmt.Sprintf("%s/%s", m.Value, m.Mask)
+}
+
+// RangeMasks is the generic version of PortRangeMasks, which returns
+// the minimal set of the bitwise matches covering the range [min, max]
+// of the field whose width is bits, sorted by the value.
+//
+// bits must be in [1, 128].
+func RangeMasks(bits int, min, max Uint128) ([]FieldMask, error) {
+	if bits < 1 || bits > 128 {
+		return nil, fmt.Errorf("the field width %d is out of [1, 128]", bits)
+	}
+
+	ones := Uint128Ones(bits)
+	switch {
+	case max.Cmp(ones) > 0:
+		return nil, fmt.Errorf("the max %s overflows %d bits", max, bits)
+	case max.Cmp(min) < 0:
+		return nil, fmt.Errorf("the max %s is smaller than the min %s", max, min)
+	}
+
+	return appendRangeMasks128(nil, bits, min, max), nil
+}
+
+// appendRangeMasks128 appends the minimal set of the bitwise matches covering
+// the range [min, max], each of which is the largest aligned block
+// starting from min.
+func appendRangeMasks128(masks []FieldMask, bits int, min, max Uint128) []FieldMask {
+	ones := Uint128Ones(bits)
+	for {
+		// The largest block aligned to min and not exceeding max.
+		k := min.TrailingZeros()
+		if k > bits {
+			k = bits
+		}
+		span := max.Sub(min)
+		for k > 0 && Uint128Ones(k).Cmp(span) > 0 {
+			k--
+		}
+
+		block := Uint128Ones(k)
+		masks = append(masks, FieldMask{Bits: bits, Value: min, Mask: ones.And(block.Not())})
+		end := min.Add(block)
+		if end == max {
+			return masks
+		}
+		min = end.Add(Uint128{Lo: 1})
+	}
+}
+
+// IPRangePrefixes returns the minimal set of the CIDR prefixes covering
+// the address range [start, end], which must be the same family.
+func IPRangePrefixes(start, end netip.Addr) ([]netip.Prefix, error) {
+	if !start.IsValid() || !end.IsValid() {
+		return nil, fmt.Errorf("invalid ip range [%s, %s]", start, end)
+	} else if start.Is4() != end.Is4() {
+		return nil, fmt.Errorf("the ip range [%s, %s] is not the same family", start, end)
+	}
+
+	bits := start.BitLen()
+	masks, err := RangeMasks(bits, Uint128FromAddr(start), Uint128FromAddr(end))
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]netip.Prefix, len(masks))
+	for i, m := range masks {
+		prefixes[i] = netip.PrefixFrom(m.Value.Addr(start.Is4()), m.PrefixLen())
+	}
+	return prefixes, nil
+}
+
+// IPRangeMatches is the same as IPRangePrefixes, but returns the matches
+// of the field, such as "nw_src=10.0.0.1/32" for the field "nw_src",
+// or "ipv6_dst=fd00::/127" for the field "ipv6_dst".
+func IPRangeMatches(field string, start, end netip.Addr) ([]string, error) {
+	prefixes, err := IPRangePrefixes(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		matches[i] = fmt.Sprintf("%s=%s", field, prefix)
+	}
+	return matches, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func ExampleIPRangeMatches() {
+	matches, err := IPRangeMatches("nw_src", netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.0.20"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, match := range matches {
+		fmt.Println(match)
+	}
+
+	matches, _ = IPRangeMatches("ipv6_dst", netip.MustParseAddr("fd00::"),
+		netip.MustParseAddr("fd00::2"))
+	for _, match := range matches {
+		fmt.Println(match)
+	}
+
+	// Output:
+	// nw_src=10.0.0.5/32
+	// nw_src=10.0.0.6/31
+	// nw_src=10.0.0.8/29
+	// nw_src=10.0.0.16/30
+	// nw_src=10.0.0.20/32
+	// ipv6_dst=fd00::/127
+	// ipv6_dst=fd00::2/128
+}
+
+func TestRangeMasksExhaustive(t *testing.T) {
+	for _, bits := range []int{1, 3, 8} {
+		max := 1<<uint(bits) - 1
+		for lo := 0; lo <= max; lo++ {
+			for hi := lo; hi <= max; hi++ {
+				masks, err := RangeMasks(bits, Uint128{Lo: uint64(lo)}, Uint128{Lo: uint64(hi)})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for v := 0; v <= max; v++ {
+					var matched int
+					for _, m := range masks {
+						if uint64(v)&m.Mask.Lo == m.Value.Lo {
+							matched++
+						}
+					}
+
+					if expect := v >= lo && v <= hi; (expect && matched != 1) || (!expect && matched != 0) {
+						t.Fatalf("bits=%d, range=[%d, %d], value=%d: matched %d times", bits, lo, hi, v, matched)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRangeMasksCoverage(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randUint128 := func(bits int) Uint128 {
+		return Uint128{Hi: random.Uint64(), Lo: random.Uint64()}.And(Uint128Ones(bits))
+	}
+
+	for _, bits := range []int{12, 16, 24, 32, 64, 100, 128} {
+		for i := 0; i < 1000; i++ {
+			min, max := randUint128(bits), randUint128(bits)
+			if max.Cmp(min) < 0 {
+				min, max = max, min
+			}
+			checkRangeMasksCoverage(t, bits, min, max)
+		}
+		checkRangeMasksCoverage(t, bits, Uint128{}, Uint128Ones(bits))
+		checkRangeMasksCoverage(t, bits, Uint128Ones(bits), Uint128Ones(bits))
+	}
+
+	if _, err := RangeMasks(12, Uint128{}, Uint128{Lo: 4096}); err == nil {
+		t.Errorf("expect an error for the overflowed max")
+	}
+	if _, err := RangeMasks(129, Uint128{}, Uint128{}); err == nil {
+		t.Errorf("expect an error for the field width 129")
+	}
+}
+
+// checkRangeMasksCoverage checks that the masks are the contiguous
+// aligned blocks covering exactly [min, max], and that they are minimal,
+// that's, no two adjacent blocks may be merged into one.
+func checkRangeMasksCoverage(t *testing.T, bits int, min, max Uint128) {
+	t.Helper()
+
+	masks, err := RangeMasks(bits, min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := min
+	ones := Uint128Ones(bits)
+	for i, m := range masks {
+		host := m.Mask.Not().And(ones)
+		if m.Value != next {
+			t.Fatalf("bits=%d, range=[%s, %s]: block %d starts at %s, expect %s",
+				bits, min, max, i, m.Value, next)
+		} else if !m.Value.And(host).IsZero() {
+			t.Fatalf("bits=%d, range=[%s, %s]: block %s is not aligned", bits, min, max, m)
+		} else if Uint128Ones(bits-m.PrefixLen()) != host {
+			t.Fatalf("bits=%d: unexpected prefix length %d of %s", bits, m.PrefixLen(), m)
+		}
+
+		// Two adjacent blocks with the same size may be merged
+		// if the first is aligned to the double size.
+		if i > 0 && masks[i-1].Mask == m.Mask &&
+			masks[i-1].Value.And(host.Add(host).Add(Uint128{Lo: 1})).IsZero() {
+			t.Fatalf("bits=%d, range=[%s, %s]: blocks %s and %s may be merged",
+				bits, min, max, masks[i-1], m)
+		}
+
+		end := m.Value.Add(host)
+		if i == len(masks)-1 {
+			if end != max {
+				t.Fatalf("bits=%d, range=[%s, %s]: the last block ends at %s", bits, min, max, end)
+			}
+		} else {
+			next = end.Add(Uint128{Lo: 1})
+		}
+	}
+}
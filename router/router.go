@@ -0,0 +1,327 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package router compiles the simple L3 routers into the OpenFlow pipeline
+// inside a bridge, which routes the packets between the segments,
+// such as the VLANs or the tunnels.
+//
+// A routed packet walks through four tables:
+//
+//	Classify: ARP/NS for the interface IP -> reply to IN_PORT
+//	          segment,dl_dst=MAC,IP       -> reg7=ROUTER -> Routing
+//	Routing:  nw_dst=interface IP         -> drop
+//	          nw_dst=CIDR (longest first) -> dec_ttl, dl_src=MAC, reg4=INTERFACE,
+//	                                         reg0/xxreg0=NEXTHOP -> Neighbor
+//	Neighbor: reg0/xxreg0=NEXTHOP         -> dl_dst=MAC -> Egress
+//	          others                      -> controller
+//	Egress:   reg4=INTERFACE              -> segment -> output
+//
+// The next hop of the connected route is the destination address.
+// The IPv4 next hop is stored in reg0 and the IPv6 one in xxreg0,
+// so reg0-reg3 must not be used by the other flows across the pipeline.
+//
+// The routers are isolated by reg7, which holds the router id, so one bridge
+// can host many routers, and UpdateRouter touches one router only.
+package router
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/xgfone/go-ovs"
+)
+
+const (
+	regRouter    = "NXM_NX_REG7[]"
+	regInterface = "NXM_NX_REG4[]"
+)
+
+// Tables is the OpenFlow tables used by the router pipeline.
+type Tables struct {
+	Classify uint8
+	Routing  uint8
+	Neighbor uint8
+	Egress   uint8
+}
+
+// DefaultTables is the default tables of the router pipeline.
+var DefaultTables = Tables{
+	Classify: 0,
+	Routing:  30,
+	Neighbor: 31,
+	Egress:   32,
+}
+
+// DefaultCookieBase is the default base of the cookies of the flows.
+const DefaultCookieBase uint64 = 0x5254 << 48
+
+// Interface is an interface of the router attached to a segment.
+//
+// If VLAN and TunnelID are both 0, the segment is flat.
+type Interface struct {
+	MAC      string
+	CIDR     netip.Prefix // Such as 10.0.0.1/24, the address of which is the interface IP.
+	VLAN     uint16
+	TunnelID uint64
+
+	// Output is the output action of the routed packets, such as "output:1",
+	// which is "NORMAL" by default.
+	Output string
+}
+
+func (i Interface) segment() string {
+	switch {
+	case i.VLAN > 0:
+		return fmt.Sprintf("dl_vlan=%d", i.VLAN)
+	case i.TunnelID > 0:
+		return fmt.Sprintf("tun_id=0x%x", i.TunnelID)
+	default:
+		return ""
+	}
+}
+
+// Route is a static route, the next hop of which must be in the CIDR
+// of an interface.
+type Route struct {
+	Prefix  netip.Prefix
+	NextHop netip.Addr
+}
+
+// Neighbor is a static neighbor entry.
+type Neighbor struct {
+	IP  netip.Addr
+	MAC string
+}
+
+// Router is a simple L3 router.
+type Router struct {
+	ID         uint32 // Must not be 0
+	Interfaces []Interface
+	Routes     []Route
+	Neighbors  []Neighbor
+}
+
+// Compiler is used to compile the routers into the flows.
+type Compiler struct {
+	Tables Tables
+
+	// CookieBase identifies the flows owned by the routers. The router id
+	// is put into its lower 32 bits, which must be 0, so the flows of
+	// the router N carry the cookie CookieBase|N, and the default drops
+	// of the Routing, Neighbor and Egress tables carry CookieBase.
+	CookieBase uint64
+}
+
+// NewCompiler returns a new compiler with the default tables and cookie base.
+func NewCompiler() *Compiler {
+	return &Compiler{Tables: DefaultTables, CookieBase: DefaultCookieBase}
+}
+
+// RouterCookie returns the cookie of the flows of the router.
+func (c *Compiler) RouterCookie(router Router) uint64 {
+	return c.CookieBase | uint64(router.ID)
+}
+
+// BaseFlows returns the lowest-priority drops of the Routing, Neighbor
+// and Egress tables, which catch the packets of the unknown routers.
+//
+// The Classify table is usually shared with the other pipelines,
+// so the packets not destined to any router are left to the caller.
+func (c *Compiler) BaseFlows() []string {
+	t := c.Tables
+	return []string{
+		ovs.Flow{Cookie: c.CookieBase, Table: t.Routing, Actions: ovs.DROP}.String(),
+		ovs.Flow{Cookie: c.CookieBase, Table: t.Neighbor, Actions: ovs.DROP}.String(),
+		ovs.Flow{Cookie: c.CookieBase, Table: t.Egress, Actions: ovs.DROP}.String(),
+	}
+}
+
+// Compile compiles the interfaces, routes and neighbors of the router
+// into its own flows, all of which carry the cookie of the router.
+// The flows returned by BaseFlows should be installed once beforehand.
+func (c *Compiler) Compile(router Router) (flows []string, err error) {
+	if router.ID == 0 {
+		return nil, fmt.Errorf("the router id must not be 0")
+	} else if uint32(c.CookieBase) != 0 {
+		return nil, fmt.Errorf("the lower 32 bits of the cookie base 0x%x must be 0", c.CookieBase)
+	}
+
+	macs := make([]string, len(router.Interfaces))
+	for i, iface := range router.Interfaces {
+		if macs[i], err = ovs.ParseMAC(iface.MAC); err != nil {
+			return nil, fmt.Errorf("router %d: %v", router.ID, err)
+		} else if !iface.CIDR.IsValid() || iface.CIDR.Addr().Zone() != "" {
+			return nil, fmt.Errorf("router %d: invalid interface cidr '%s'", router.ID, iface.CIDR)
+		} else if iface.VLAN > 4095 {
+			return nil, fmt.Errorf("router %d: invalid interface vlan %d", router.ID, iface.VLAN)
+		}
+	}
+
+	t := c.Tables
+	cookie := c.RouterCookie(router)
+	router7 := fmt.Sprintf("reg7=%d", router.ID)
+	add := func(table uint8, priority int, match, actions string) {
+		flows = append(flows, ovs.Flow{Cookie: cookie, Table: table, Priority: priority,
+			Match: match, Actions: actions}.String())
+	}
+
+	// Classify
+	for i, iface := range router.Interfaces {
+		segment := iface.segment()
+		responders, err := ovs.NeighborResponder{Cookie: cookie, Table: t.Classify}.Flows(
+			ovs.NeighborBinding{IP: iface.CIDR.Addr().String(), MAC: macs[i], Match: segment})
+		if err != nil {
+			return nil, fmt.Errorf("router %d: %v", router.ID, err)
+		}
+		flows = append(flows, responders...)
+
+		actions := fmt.Sprintf("%s,goto_table:%d", ovs.LoadAction(uint64(router.ID), regRouter), t.Routing)
+		if iface.VLAN > 0 {
+			actions = "strip_vlan," + actions
+		}
+
+		match := ovs.JoinMatches(segment, "dl_dst="+macs[i], ipProto(iface.CIDR.Addr()))
+		add(t.Classify, 90, match, actions)
+	}
+
+	// Routing
+	for i, iface := range router.Interfaces {
+		ip := iface.CIDR.Addr()
+		add(t.Routing, 300, ovs.JoinMatches(router7, ipDst(netip.PrefixFrom(ip, ip.BitLen()))), ovs.DROP)
+
+		prefix := iface.CIDR.Masked()
+		nexthop := "move:NXM_OF_IP_DST[]->NXM_NX_REG0[]"
+		if ip.Is6() {
+			nexthop = "move:NXM_NX_IPV6_DST[]->NXM_NX_XXREG0[]"
+		}
+		add(t.Routing, 100+prefix.Bits(), ovs.JoinMatches(router7, ipDst(prefix)),
+			c.routeActions(i, macs[i], nexthop))
+	}
+	for _, route := range router.Routes {
+		index := findInterface(router.Interfaces, route.NextHop)
+		if index < 0 {
+			return nil, fmt.Errorf("router %d: no interface for the next hop '%s' of the route '%s'",
+				router.ID, route.NextHop, route.Prefix)
+		} else if !route.Prefix.IsValid() || route.Prefix.Addr().Is4() != route.NextHop.Is4() {
+			return nil, fmt.Errorf("router %d: the route '%s' and the next hop '%s' are not the same family",
+				router.ID, route.Prefix, route.NextHop)
+		}
+
+		prefix := route.Prefix.Masked()
+		add(t.Routing, 100+prefix.Bits(), ovs.JoinMatches(router7, ipDst(prefix)),
+			c.routeActions(index, macs[index], nextHopAction(route.NextHop)))
+	}
+
+	// Neighbor
+	toEgress := fmt.Sprintf("goto_table:%d", t.Egress)
+	for _, neigh := range router.Neighbors {
+		mac, err := ovs.ParseMAC(neigh.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("router %d: %v", router.ID, err)
+		} else if !neigh.IP.IsValid() {
+			return nil, fmt.Errorf("router %d: missing the neighbor ip", router.ID)
+		}
+
+		add(t.Neighbor, 100, ovs.JoinMatches(router7, nextHopMatch(neigh.IP)),
+			ovs.SetFieldAction(mac, "eth_dst")+","+toEgress)
+	}
+	add(t.Neighbor, 10, ovs.JoinMatches(router7, "ip"), "controller")
+	add(t.Neighbor, 10, ovs.JoinMatches(router7, "ipv6"), "controller")
+
+	// Egress
+	for i, iface := range router.Interfaces {
+		output := iface.Output
+		if output == "" {
+			output = "NORMAL"
+		}
+
+		switch {
+		case iface.VLAN > 0:
+			output = fmt.Sprintf("mod_vlan_vid:%d,%s", iface.VLAN, output)
+		case iface.TunnelID > 0:
+			output = fmt.Sprintf("set_field:0x%x->tun_id,%s", iface.TunnelID, output)
+		}
+
+		add(t.Egress, 100, ovs.JoinMatches(router7, fmt.Sprintf("reg4=%d", i+1)), output)
+	}
+
+	return
+}
+
+func (c *Compiler) routeActions(index int, mac, nexthop string) string {
+	return fmt.Sprintf("dec_ttl,%s,%s,%s,goto_table:%d", ovs.SetFieldAction(mac, "eth_src"),
+		ovs.LoadAction(uint64(index+1), regInterface), nexthop, c.Tables.Neighbor)
+}
+
+// InstallBase installs the drops returned by BaseFlows on the bridge,
+// and removes the stale ones left by the old tables.
+func (c *Compiler) InstallBase(bridge string) error {
+	return ovs.ReplaceFlowsByCookie(bridge, c.CookieBase, c.BaseFlows()...)
+}
+
+// UpdateRouter compiles the router, then deletes the flows carrying
+// its cookie and adds the new ones in one OpenFlow bundle, so the routed
+// packets never see a half-updated routing table.
+//
+// The cookie is derived from the router id, so if the id changes,
+// the flows of the old id must be removed by RemoveRouter.
+func (c *Compiler) UpdateRouter(bridge string, router Router) error {
+	flows, err := c.Compile(router)
+	if err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.RouterCookie(router), flows...)
+}
+
+// RemoveRouter removes all the flows of the router from the bridge.
+func (c *Compiler) RemoveRouter(bridge string, router Router) error {
+	return ovs.DelFlowsByCookie(bridge, c.RouterCookie(router))
+}
+
+func findInterface(ifaces []Interface, ip netip.Addr) int {
+	for i, iface := range ifaces {
+		if iface.CIDR.Contains(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+func ipProto(ip netip.Addr) string {
+	if ip.Is4() {
+		return "ip"
+	}
+	return "ipv6"
+}
+
+func ipDst(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return fmt.Sprintf("ip,nw_dst=%s", prefix)
+	}
+	return fmt.Sprintf("ipv6,ipv6_dst=%s", prefix)
+}
+
+func nextHopMatch(ip netip.Addr) string {
+	if ip.Is4() {
+		return fmt.Sprintf("ip,reg0=%s", ovs.Uint128FromAddr(ip))
+	}
+	return fmt.Sprintf("ipv6,xxreg0=%s", ovs.Uint128FromAddr(ip))
+}
+
+func nextHopAction(ip netip.Addr) string {
+	if ip.Is4() {
+		return ovs.SetFieldAction(ovs.Uint128FromAddr(ip).String(), "reg0")
+	}
+	return ovs.SetFieldAction(ovs.Uint128FromAddr(ip).String(), "xxreg0")
+}
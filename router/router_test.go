@@ -0,0 +1,166 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/xgfone/go-ovs/flowsim"
+)
+
+func TestCompile(t *testing.T) {
+	c := NewCompiler()
+	flows, err := c.Compile(Router{
+		ID: 1,
+		Interfaces: []Interface{
+			{MAC: "fa:16:3e:00:00:01", CIDR: netip.MustParsePrefix("10.0.1.1/24"), TunnelID: 100, Output: "output:1"},
+			{MAC: "fa:16:3e:00:00:02", CIDR: netip.MustParsePrefix("10.0.2.1/24"), TunnelID: 200, Output: "output:2"},
+		},
+		Routes: []Route{
+			{Prefix: netip.MustParsePrefix("0.0.0.0/0"), NextHop: netip.MustParseAddr("10.0.2.254")},
+		},
+		Neighbors: []Neighbor{
+			{IP: netip.MustParseAddr("10.0.2.254"), MAC: "00:00:00:00:02:fe"},
+			{IP: netip.MustParseAddr("10.0.1.10"), MAC: "00:00:00:00:01:0a"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := flowsim.NewEvaluator()
+	if err := e.AddFlows(append(c.BaseFlows(), flows...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Route by the default route.
+	result, err := e.Trace("tun_id=100,ip,dl_dst=fa:16:3e:00:00:01,nw_src=10.0.1.10,nw_dst=8.8.8.8,nw_ttl=64")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.Outputs) != 1 || result.Outputs[0].Port != 2 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	}
+
+	p := result.Outputs[0].Packet
+	for field, expect := range map[string]string{
+		"dl_src": "0xfa163e000002",
+		"dl_dst": "0x2fe",
+		"tun_id": "0xc8",
+		"nw_ttl": "0x3f",
+	} {
+		if v := p.Get(field).String(); v != expect {
+			t.Errorf("expect %s '%s', but got '%s'", field, expect, v)
+		}
+	}
+
+	// Route by the connected route.
+	result, err = e.Trace("tun_id=200,ip,dl_dst=fa:16:3e:00:00:02,nw_src=8.8.8.8,nw_dst=10.0.1.10,nw_ttl=64")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.Outputs) != 1 || result.Outputs[0].Port != 1 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	} else if v := result.Outputs[0].Packet.Get("dl_dst").String(); v != "0x10a" {
+		t.Errorf("expect dl_dst '0x10a', but got '%s'", v)
+	}
+
+	// Unknown neighbor
+	result, err = e.Trace("tun_id=200,ip,dl_dst=fa:16:3e:00:00:02,nw_dst=10.0.1.20,nw_ttl=64")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.Outputs) != 1 || result.Outputs[0].Port != flowsim.PortController {
+		t.Errorf("expect the packet to the controller: %+v", result.Outputs)
+	}
+
+	// ARP for the router interface
+	result, err = e.Trace("in_port=3,tun_id=100,arp,arp_op=1,arp_tpa=10.0.1.1,arp_spa=10.0.1.10")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.Outputs) != 1 || result.Outputs[0].Port != 3 {
+		t.Fatalf("unexpected outputs: %+v", result.Outputs)
+	} else if v := result.Outputs[0].Packet.Get("arp_op").String(); v != "0x2" {
+		t.Errorf("expect the ARP reply, but got arp_op '%s'", v)
+	}
+}
+
+func TestCompileOverlappedRouters(t *testing.T) {
+	// Two routers with the same CIDRs on the different tunnels.
+	routers := []Router{
+		{ID: 1, Interfaces: []Interface{
+			{MAC: "fa:16:3e:00:01:01", CIDR: netip.MustParsePrefix("10.0.1.1/24"), TunnelID: 100, Output: "output:1"},
+			{MAC: "fa:16:3e:00:01:02", CIDR: netip.MustParsePrefix("10.0.2.1/24"), TunnelID: 101, Output: "output:1"},
+		}},
+		{ID: 2, Interfaces: []Interface{
+			{MAC: "fa:16:3e:00:02:01", CIDR: netip.MustParsePrefix("10.0.1.1/24"), TunnelID: 200, Output: "output:2"},
+			{MAC: "fa:16:3e:00:02:02", CIDR: netip.MustParsePrefix("10.0.2.1/24"), TunnelID: 201, Output: "output:2"},
+		}},
+	}
+
+	c := NewCompiler()
+	e := flowsim.NewEvaluator()
+	if err := e.AddFlows(c.BaseFlows()...); err != nil {
+		t.Fatal(err)
+	}
+	for _, router := range routers {
+		flows, err := c.Compile(router)
+		if err != nil {
+			t.Fatal(err)
+		} else if err = e.AddFlows(flows...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trace := func(tunnel int, mac string) flowsim.Result {
+		result, err := e.Trace(fmt.Sprintf("tun_id=%d,ip,dl_dst=%s,nw_src=10.0.1.10,nw_dst=10.0.2.10,nw_ttl=64", tunnel, mac))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// The neighbor of router 2 is unknown, so the packet goes to the controller.
+	if result := trace(200, "fa:16:3e:00:02:01"); len(result.Outputs) != 1 ||
+		result.Outputs[0].Port != flowsim.PortController || result.Packet.Get("reg7").Lo != 2 {
+		t.Errorf("expect the packet to be routed by router 2: %+v", result)
+	}
+
+	// Removing router 1 by its cookie does not affect router 2.
+	e.DelFlowsByCookie(c.RouterCookie(routers[0]))
+	if result := trace(100, "fa:16:3e:00:01:01"); !result.Dropped() {
+		t.Errorf("expect the packet of the removed router to be dropped: %+v", result.Outputs)
+	}
+	if result := trace(200, "fa:16:3e:00:02:01"); len(result.Outputs) != 1 {
+		t.Errorf("expect the packet to be still routed by router 2: %+v", result.Outputs)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	iface := Interface{MAC: "fa:16:3e:00:00:01", CIDR: netip.MustParsePrefix("10.0.1.1/24")}
+	for name, router := range map[string]Router{
+		"the router id 0": {Interfaces: []Interface{iface}},
+		"the invalid mac": {ID: 1, Interfaces: []Interface{{MAC: "invalid", CIDR: iface.CIDR}}},
+		"the invalid vlan": {ID: 1, Interfaces: []Interface{
+			{MAC: iface.MAC, CIDR: iface.CIDR, VLAN: 4096}}},
+		"the unreachable next hop": {ID: 1, Interfaces: []Interface{iface}, Routes: []Route{
+			{Prefix: netip.MustParsePrefix("0.0.0.0/0"), NextHop: netip.MustParseAddr("10.0.3.1")}}},
+		"the next hop of the different family": {ID: 1, Interfaces: []Interface{iface}, Routes: []Route{
+			{Prefix: netip.MustParsePrefix("::/0"), NextHop: netip.MustParseAddr("10.0.1.254")}}},
+	} {
+		if _, err := NewCompiler().Compile(router); err == nil {
+			t.Errorf("expect an error for %s", name)
+		}
+	}
+}
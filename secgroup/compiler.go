@@ -0,0 +1,327 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secgroup compiles the security group rules into a stateful
+// OpenFlow pipeline based on conntrack, which is similar to the OVS firewall
+// driver of Neutron.
+//
+// The pipeline is as follow:
+//
+//	Classify:        in_port=OFPORT -> reg5=OFPORT, reg6=ZONE -> EgressBase
+//	                 others                                   -> IngressClassify
+//	EgressBase:      anti-spoofing, ARP/ND/DHCP -> IngressClassify, IP -> ct -> EgressRules
+//	EgressRules:     established or related     -> IngressClassify
+//	                 new matching the rules     -> ct(commit) -> IngressClassify
+//	IngressClassify: dl_dst=MAC -> reg5=OFPORT, reg6=ZONE -> IngressBase
+//	                 others     -> FallbackActions, such as NORMAL
+//	IngressBase:     ARP/ND/DHCP -> output, IP -> ct -> IngressRules
+//	IngressRules:    established or related -> output
+//	                 new matching the rules -> ct(commit) -> output
+//
+// All the flows of a port have the same cookie, so updating the rules
+// of a port only regenerates and reconciles the flows of the port.
+package secgroup
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/xgfone/go-ovs"
+)
+
+const (
+	regPort = "NXM_NX_REG5[]"
+	regZone = "NXM_NX_REG6[0..15]"
+)
+
+// Tables is the OpenFlow tables used by the security group pipeline.
+type Tables struct {
+	Classify        uint8
+	EgressBase      uint8
+	EgressRules     uint8
+	IngressClassify uint8
+	IngressBase     uint8
+	IngressRules    uint8
+}
+
+// DefaultTables is the default tables of the security group pipeline.
+var DefaultTables = Tables{
+	Classify:        0,
+	IngressClassify: 60,
+	EgressBase:      71,
+	EgressRules:     72,
+	IngressBase:     81,
+	IngressRules:    82,
+}
+
+// DefaultCookieBase is the default base of the cookies of the flows.
+const DefaultCookieBase uint64 = 0x5347 << 48
+
+// Port is a port on the bridge protected by the security group rules.
+type Port struct {
+	Name   string
+	OFPort int
+	MAC    string
+	IPs    []netip.Addr
+	Zone   uint16 // The conntrack zone
+	Rules  []Rule
+}
+
+// FlowSet is the set of the flows keyed by the port name.
+type FlowSet map[string][]string
+
+// Compiler is used to compile the security group rules into the flows.
+type Compiler struct {
+	Tables Tables
+
+	// CookieBase is the base of the cookies of the flows, the lower 32 bits
+	// of which must be 0. The cookie of the flows of a port is
+	// CookieBase|OFPort, and that of the base flows is CookieBase.
+	CookieBase uint64
+
+	// Groups is the addresses of the members of the remote security groups.
+	Groups map[string][]netip.Addr
+
+	// FallbackActions is the actions of the packets not destined to any port,
+	// which is "NORMAL" by default.
+	FallbackActions string
+}
+
+// NewCompiler returns a new compiler with the default tables and cookie base.
+func NewCompiler() *Compiler {
+	return &Compiler{
+		Tables:          DefaultTables,
+		CookieBase:      DefaultCookieBase,
+		Groups:          make(map[string][]netip.Addr),
+		FallbackActions: "NORMAL",
+	}
+}
+
+// PortCookie returns the cookie of the flows of the port.
+func (c *Compiler) PortCookie(port Port) uint64 {
+	return c.CookieBase | uint64(port.OFPort)
+}
+
+// BaseFlows returns the flows shared by all the ports.
+func (c *Compiler) BaseFlows() []string {
+	t := c.Tables
+	fallback := c.FallbackActions
+	if fallback == "" {
+		fallback = "NORMAL"
+	}
+
+	return []string{
+		c.flow(c.CookieBase, t.Classify, 1, "", fmt.Sprintf("goto_table:%d", t.IngressClassify)),
+		c.flow(c.CookieBase, t.IngressClassify, 0, "", fallback),
+		c.flow(c.CookieBase, t.EgressBase, 0, "", ovs.DROP),
+		c.flow(c.CookieBase, t.EgressRules, 0, "", ovs.DROP),
+		c.flow(c.CookieBase, t.IngressBase, 0, "", ovs.DROP),
+		c.flow(c.CookieBase, t.IngressRules, 0, "", ovs.DROP),
+	}
+}
+
+// Compile compiles the rules of all the ports into the flows.
+//
+// Notice: the base flows are not contained.
+func (c *Compiler) Compile(ports ...Port) (FlowSet, error) {
+	flows := make(FlowSet, len(ports))
+	for _, port := range ports {
+		portFlows, err := c.CompilePort(port)
+		if err != nil {
+			return nil, err
+		}
+		flows[port.Name] = portFlows
+	}
+	return flows, nil
+}
+
+// CompilePort compiles the rules of the port into the flows.
+func (c *Compiler) CompilePort(port Port) (flows []string, err error) {
+	if err = c.checkCookieBase(); err != nil {
+		return
+	} else if port.OFPort <= 0 || port.OFPort >= 0xff00 {
+		return nil, fmt.Errorf("invalid ofport %d of the port '%s'", port.OFPort, port.Name)
+	}
+
+	hwaddr, err := net.ParseMAC(port.MAC)
+	if err != nil || len(hwaddr) != 6 {
+		return nil, fmt.Errorf("invalid mac '%s' of the port '%s'", port.MAC, port.Name)
+	}
+	mac := hwaddr.String()
+
+	for _, rule := range port.Rules {
+		if err = rule.validate(); err != nil {
+			return nil, fmt.Errorf("port '%s': %v", port.Name, err)
+		}
+	}
+
+	t := c.Tables
+	cookie := c.PortCookie(port)
+	ofport := fmt.Sprintf("reg5=%d", port.OFPort)
+	output := fmt.Sprintf("output:%d", port.OFPort)
+	toIngress := fmt.Sprintf("goto_table:%d", t.IngressClassify)
+	load := fmt.Sprintf("%s,%s", ovs.LoadAction(uint64(port.OFPort), regPort),
+		ovs.LoadAction(uint64(port.Zone), regZone))
+	add := func(table uint8, priority int, match, actions string) {
+		flows = append(flows, c.flow(cookie, table, priority, match, actions))
+	}
+
+	// Classify
+	add(t.Classify, 100, fmt.Sprintf("in_port=%d", port.OFPort),
+		fmt.Sprintf("%s,goto_table:%d", load, t.EgressBase))
+	add(t.IngressClassify, 100, "dl_dst="+mac,
+		fmt.Sprintf("%s,goto_table:%d", load, t.IngressBase))
+
+	// Egress Base
+	for _, ip := range port.IPs {
+		if ip.Is4() {
+			add(t.EgressBase, 95, fmt.Sprintf("%s,arp,dl_src=%s,arp_sha=%s,arp_spa=%s",
+				ofport, mac, mac, ip), toIngress)
+			add(t.EgressBase, 65, fmt.Sprintf("%s,ip,dl_src=%s,nw_src=%s", ofport, mac, ip),
+				ovs.CT().ZoneField(regZone).Table(t.EgressRules).String())
+		} else {
+			add(t.EgressBase, 65, fmt.Sprintf("%s,ipv6,dl_src=%s,ipv6_src=%s", ofport, mac, ip),
+				ovs.CT().ZoneField(regZone).Table(t.EgressRules).String())
+		}
+	}
+	for _, icmpType := range []int{133, 135} { // RS, NS
+		add(t.EgressBase, 95, fmt.Sprintf("%s,icmp6,dl_src=%s,icmp_type=%d",
+			ofport, mac, icmpType), toIngress)
+	}
+	// Only advertise the IPv6 addresses owned by the port, including
+	// the link-local address, and drop the other NAs.
+	for _, ip := range ndTargets(hwaddr, port.IPs) {
+		add(t.EgressBase, 95, fmt.Sprintf("%s,icmp6,dl_src=%s,icmp_type=136,nd_target=%s",
+			ofport, mac, ip), toIngress)
+	}
+	add(t.EgressBase, 93, ofport+",icmp6,icmp_type=136", ovs.DROP)
+	add(t.EgressBase, 90, fmt.Sprintf("%s,udp,dl_src=%s,tp_src=68,tp_dst=67", ofport, mac), toIngress)
+	add(t.EgressBase, 90, fmt.Sprintf("%s,udp6,dl_src=%s,tp_src=546,tp_dst=547", ofport, mac), toIngress)
+	add(t.EgressBase, 80, ofport+",udp,tp_src=67,tp_dst=68", ovs.DROP)
+	add(t.EgressBase, 80, ofport+",udp6,tp_src=547,tp_dst=546", ovs.DROP)
+	add(t.EgressBase, 10, ofport, ovs.DROP)
+
+	// Ingress Base
+	add(t.IngressBase, 95, ofport+",arp", output)
+	for _, icmpType := range []int{134, 135, 136} { // RA, NS, NA
+		add(t.IngressBase, 95, fmt.Sprintf("%s,icmp6,icmp_type=%d", ofport, icmpType), output)
+	}
+	add(t.IngressBase, 90, ofport+",udp,tp_src=67,tp_dst=68", output)
+	add(t.IngressBase, 90, ofport+",udp6,tp_src=547,tp_dst=546", output)
+	add(t.IngressBase, 65, ofport+",ip", ovs.CT().ZoneField(regZone).Table(t.IngressRules).String())
+	add(t.IngressBase, 65, ofport+",ipv6", ovs.CT().ZoneField(regZone).Table(t.IngressRules).String())
+	add(t.IngressBase, 10, ofport, ovs.DROP)
+
+	// Egress and Ingress Rules
+	c.addRuleFlows(add, t.EgressRules, ofport, toIngress, Egress, port.Rules)
+	c.addRuleFlows(add, t.IngressRules, ofport, output, Ingress, port.Rules)
+
+	return
+}
+
+// ndTargets returns the IPv6 addresses which the port may advertise by NA,
+// that's, the link-local address derived from the mac by EUI-64
+// and the IPv6 addresses of the port.
+func ndTargets(mac net.HardwareAddr, ips []netip.Addr) []netip.Addr {
+	var lla [16]byte
+	lla[0], lla[1] = 0xfe, 0x80
+	lla[8], lla[9], lla[10] = mac[0]^0x02, mac[1], mac[2]
+	lla[11], lla[12] = 0xff, 0xfe
+	lla[13], lla[14], lla[15] = mac[3], mac[4], mac[5]
+
+	targets := []netip.Addr{netip.AddrFrom16(lla)}
+	for _, ip := range ips {
+		if ip.Is6() && ip != targets[0] {
+			targets = append(targets, ip)
+		}
+	}
+	return targets
+}
+
+func (c *Compiler) addRuleFlows(add func(uint8, int, string, string),
+	table uint8, ofport, accept, direction string, rules []Rule) {
+	established := ovs.CTState(ovs.CTStateTrk|ovs.CTStateEst, ovs.CTStateRel|ovs.CTStateInv)
+	related := ovs.CTState(ovs.CTStateTrk|ovs.CTStateRel, ovs.CTStateInv)
+	invalid := ovs.CTState(ovs.CTStateTrk|ovs.CTStateInv, 0)
+	newConn := ovs.CTState(ovs.CTStateTrk|ovs.CTStateNew, ovs.CTStateEst)
+	commit := ovs.CT().Commit().ZoneField(regZone).String() + "," + accept
+
+	add(table, 75, ofport+","+established, accept)
+	add(table, 75, ofport+","+related, accept)
+	add(table, 50, ofport+","+invalid, ovs.DROP)
+
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if rule.Direction != direction {
+			continue
+		}
+
+		for _, match := range rule.matches(c.Groups) {
+			match = ovs.JoinMatches(ofport, newConn, match)
+			if _, ok := seen[match]; !ok {
+				seen[match] = struct{}{}
+				add(table, 70, match, commit)
+			}
+		}
+	}
+
+	add(table, 10, ofport, ovs.DROP)
+}
+
+func (c *Compiler) flow(cookie uint64, table uint8, priority int, match, actions string) string {
+	if match == "" {
+		return fmt.Sprintf("cookie=0x%x,table=%d,priority=%d,actions=%s",
+			cookie, table, priority, actions)
+	}
+	return fmt.Sprintf("cookie=0x%x,table=%d,priority=%d,%s,actions=%s",
+		cookie, table, priority, match, actions)
+}
+
+func (c *Compiler) checkCookieBase() error {
+	if uint32(c.CookieBase) != 0 {
+		return fmt.Errorf("the lower 32 bits of the cookie base 0x%x must be 0", c.CookieBase)
+	}
+	return nil
+}
+
+// InstallBase installs or reconciles the base flows on the bridge.
+func (c *Compiler) InstallBase(bridge string) error {
+	if err := c.checkCookieBase(); err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.CookieBase, c.BaseFlows()...)
+}
+
+// UpdatePort compiles the rules of the port and replaces the flows with
+// the cookie CookieBase|OFPort in one OpenFlow bundle, so the packets
+// of the port never pass a half-updated rule set.
+//
+// The established connections are accepted whatever the rules are,
+// so the connections allowed by a removed rule are kept until their
+// conntrack entries in the zone of the port expire or are flushed.
+// And since the remote groups are expanded into the flows, all the ports
+// referring to a group must be updated after its members change.
+func (c *Compiler) UpdatePort(bridge string, port Port) error {
+	flows, err := c.CompilePort(port)
+	if err != nil {
+		return err
+	}
+	return ovs.ReplaceFlowsByCookie(bridge, c.PortCookie(port), flows...)
+}
+
+// RemovePort removes all the flows of the port from the bridge.
+func (c *Compiler) RemovePort(bridge string, port Port) error {
+	return ovs.DelFlowsByCookie(bridge, c.PortCookie(port))
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secgroup
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xgfone/go-ovs/flowsim"
+)
+
+func TestCompilePort(t *testing.T) {
+	c := NewCompiler()
+	c.Groups["web"] = []netip.Addr{
+		netip.MustParseAddr("10.0.0.10"),
+		netip.MustParseAddr("10.0.0.11"),
+		netip.MustParseAddr("fd00::10"),
+	}
+
+	port := Port{
+		Name:   "tap0",
+		OFPort: 5,
+		MAC:    "fa:16:3e:00:00:01",
+		IPs:    []netip.Addr{netip.MustParseAddr("10.0.0.5")},
+		Zone:   1,
+		Rules: []Rule{
+			{Direction: Ingress, EtherType: IPv4, Protocol: ProtoTCP,
+				PortRangeMin: Int(1000), PortRangeMax: Int(1999),
+				RemoteCIDR: netip.MustParsePrefix("192.168.0.0/16")},
+			{Direction: Ingress, EtherType: IPv4, Protocol: ProtoTCP,
+				PortRangeMin: Int(22), RemoteGroup: "web"},
+			{Direction: Egress, EtherType: IPv4},
+		},
+	}
+
+	flows, err := c.CompilePort(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ingress, egress int
+	for _, flow := range flows {
+		if !strings.HasPrefix(flow, "cookie=0x5347000000000005,") {
+			t.Errorf("unexpected cookie of the flow '%s'", flow)
+		}
+
+		if strings.Contains(flow, "ct_state=+trk+new-est") {
+			switch {
+			case strings.Contains(flow, "table=82,"):
+				ingress++
+			case strings.Contains(flow, "table=72,"):
+				egress++
+			}
+		}
+	}
+
+	// 7 masks for [1000, 1999] + 2 members for port 22
+	if ingress != 9 {
+		t.Errorf("expect 9 ingress rule flows, but got %d", ingress)
+	}
+	if egress != 1 {
+		t.Errorf("expect 1 egress rule flows, but got %d", egress)
+	}
+
+	expect := "cookie=0x5347000000000005,table=82,priority=70,reg5=5," +
+		"ct_state=+trk+new-est,tcp,nw_src=10.0.0.10,tp_dst=0x0016," +
+		"actions=ct(commit,zone=NXM_NX_REG6[0..15]),output:5"
+	if !containsFlow(flows, expect) {
+		t.Errorf("missing the flow '%s'", expect)
+	}
+
+	expect = "cookie=0x5347000000000005,table=71,priority=65,reg5=5," +
+		"ip,dl_src=fa:16:3e:00:00:01,nw_src=10.0.0.5," +
+		"actions=ct(zone=NXM_NX_REG6[0..15],table=72)"
+	if !containsFlow(flows, expect) {
+		t.Errorf("missing the flow '%s'", expect)
+	}
+}
+
+func TestCompilePortNeighborAdvertisement(t *testing.T) {
+	c := NewCompiler()
+	port := Port{
+		Name:   "tap0",
+		OFPort: 5,
+		MAC:    "fa:16:3e:00:00:01",
+		IPs:    []netip.Addr{netip.MustParseAddr("fd00::5")},
+		Zone:   1,
+		Rules:  []Rule{{Direction: Egress, EtherType: IPv6}},
+	}
+
+	flows, err := c.CompilePort(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := flowsim.NewEvaluator()
+	if err = e.AddFlows(append(c.BaseFlows(), flows...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, allowed := range map[string]bool{
+		"fd00::5":                true,
+		"fe80::f816:3eff:fe00:1": true,
+		"fd00::6":                false,
+		"fe80::f816:3eff:fe00:2": false,
+		"fe80::1":                false,
+	} {
+		result, err := e.Trace("in_port=5,icmp6,dl_src=fa:16:3e:00:00:01,ipv6_src=fd00::5," +
+			"icmp_type=136,icmp_code=0,nd_target=" + target)
+		if err != nil {
+			t.Fatal(err)
+		} else if dropped := result.Dropped(); dropped == allowed {
+			t.Errorf("nd_target=%s: expect allowed=%v, but got dropped=%v", target, allowed, dropped)
+		}
+	}
+}
+
+func TestCompilePortCookieBase(t *testing.T) {
+	c := NewCompiler()
+	c.CookieBase = 0x5347<<48 | 0x100
+	port := Port{Name: "tap0", OFPort: 1, MAC: "fa:16:3e:00:00:01"}
+	if _, err := c.CompilePort(port); err == nil {
+		t.Errorf("expect an error for the cookie base 0x%x", c.CookieBase)
+	}
+	if err := c.InstallBase("br0"); err == nil {
+		t.Errorf("expect an error for the cookie base 0x%x", c.CookieBase)
+	}
+}
+
+func TestRulePortMatches(t *testing.T) {
+	cases := []struct {
+		Rule   Rule
+		Expect []string
+	}{
+		{Rule{Protocol: ProtoICMP}, []string{""}},
+		{Rule{Protocol: ProtoICMP, PortRangeMin: Int(0)}, []string{"icmp_type=0"}},
+		{Rule{Protocol: ProtoICMP, PortRangeMin: Int(8), PortRangeMax: Int(0)}, []string{"icmp_type=8,icmp_code=0"}},
+		{Rule{Protocol: ProtoTCP}, []string{""}},
+		{Rule{Protocol: ProtoTCP, PortRangeMin: Int(0), PortRangeMax: Int(65535)}, []string{""}},
+		{Rule{Protocol: ProtoTCP, PortRangeMin: Int(22)}, []string{"tp_dst=0x0016"}},
+	}
+
+	for i, c := range cases {
+		if matches := c.Rule.portMatches(); !reflect.DeepEqual(matches, c.Expect) {
+			t.Errorf("%d: expect matches %q, but got %q", i, c.Expect, matches)
+		}
+	}
+}
+
+func TestCompilePortInvalidRule(t *testing.T) {
+	rules := []Rule{
+		{Direction: "in", EtherType: IPv4},
+		{Direction: Ingress, EtherType: IPv4, Protocol: ProtoTCP, PortRangeMin: Int(100), PortRangeMax: Int(10)},
+		{Direction: Ingress, EtherType: IPv4, Protocol: ProtoTCP, PortRangeMin: Int(70000)},
+		{Direction: Ingress, EtherType: IPv4, Protocol: ProtoICMP, PortRangeMax: Int(0)},
+		{Direction: Ingress, EtherType: IPv4, Protocol: ProtoICMP, PortRangeMin: Int(256)},
+		{Direction: Ingress, EtherType: IPv6, Protocol: ProtoICMP},
+		{Direction: Ingress, EtherType: IPv6, RemoteCIDR: netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	c := NewCompiler()
+	for i, rule := range rules {
+		port := Port{Name: "tap0", OFPort: 1, MAC: "fa:16:3e:00:00:01", Rules: []Rule{rule}}
+		if _, err := c.CompilePort(port); err == nil {
+			t.Errorf("%d: expect an error, but got nil", i)
+		}
+	}
+}
+
+func containsFlow(flows []string, flow string) bool {
+	for _, f := range flows {
+		if f == flow {
+			return true
+		}
+	}
+	return false
+}
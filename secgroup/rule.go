@@ -0,0 +1,245 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secgroup
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/xgfone/go-ovs"
+)
+
+// Directions of the security group rule.
+const (
+	Ingress = "ingress"
+	Egress  = "egress"
+)
+
+// Ether types of the security group rule.
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+// Protocols of the security group rule.
+const (
+	ProtoAny    = ""
+	ProtoTCP    = "tcp"
+	ProtoUDP    = "udp"
+	ProtoICMP   = "icmp"
+	ProtoICMPv6 = "icmpv6"
+)
+
+// Rule is a security group rule like Neutron.
+//
+// For TCP and UDP, PortRangeMin and PortRangeMax are the range of
+// the destination port. For ICMP and ICMPv6, PortRangeMin is the ICMP type
+// and PortRangeMax is the ICMP code. Like None of Neutron, if PortRangeMin
+// is nil, match any port or ICMP type. And if PortRangeMax is nil, it is
+// the same as PortRangeMin, or match any ICMP code.
+//
+// At most one of RemoteCIDR and RemoteGroup may be set. If neither is set,
+// match any remote address.
+type Rule struct {
+	Direction string // Ingress or Egress
+	EtherType string // IPv4 or IPv6
+	Protocol  string // ProtoAny, ProtoTCP, ProtoUDP, ProtoICMP, ProtoICMPv6
+
+	RemoteCIDR  netip.Prefix
+	RemoteGroup string
+
+	PortRangeMin *int
+	PortRangeMax *int
+}
+
+// Int returns the pointer to v, which is used to set PortRangeMin
+// and PortRangeMax of Rule.
+func Int(v int) *int { return &v }
+
+// portRange returns the range of the ports or the icmp type and code.
+//
+// If PortRangeMin or PortRangeMax is nil, return -1 for it.
+func (r Rule) portRange() (min, max int) {
+	min, max = -1, -1
+	if r.PortRangeMin != nil {
+		min = *r.PortRangeMin
+	}
+	if r.PortRangeMax != nil {
+		max = *r.PortRangeMax
+	}
+	return
+}
+
+func (r Rule) validate() error {
+	switch r.Direction {
+	case Ingress, Egress:
+	default:
+		return fmt.Errorf("invalid security group rule direction '%s'", r.Direction)
+	}
+
+	switch r.EtherType {
+	case IPv4, IPv6:
+	default:
+		return fmt.Errorf("invalid security group rule ethertype '%s'", r.EtherType)
+	}
+
+	min, max := r.portRange()
+	switch r.Protocol {
+	case ProtoAny:
+		if r.PortRangeMin != nil || r.PortRangeMax != nil {
+			return fmt.Errorf("the port range requires the protocol")
+		}
+
+	case ProtoTCP, ProtoUDP:
+		if r.PortRangeMin == nil && r.PortRangeMax != nil {
+			return fmt.Errorf("the port range max requires the min")
+		} else if (r.PortRangeMin != nil && (min < 0 || min > 65535)) ||
+			(r.PortRangeMax != nil && (max < 0 || max > 65535)) {
+			return fmt.Errorf("the port range [%d, %d] is out of [0, 65535]", min, max)
+		} else if r.PortRangeMax != nil && max < min {
+			return fmt.Errorf("the port range min %d is greater than max %d", min, max)
+		}
+
+	case ProtoICMP, ProtoICMPv6:
+		if (r.Protocol == ProtoICMP) != (r.EtherType == IPv4) {
+			return fmt.Errorf("the protocol '%s' mismatches the ethertype '%s'",
+				r.Protocol, r.EtherType)
+		} else if r.PortRangeMin == nil && r.PortRangeMax != nil {
+			return fmt.Errorf("the icmp code requires the icmp type")
+		} else if (r.PortRangeMin != nil && (min < 0 || min > 255)) ||
+			(r.PortRangeMax != nil && (max < 0 || max > 255)) {
+			return fmt.Errorf("the icmp type %d or code %d is out of [0, 255]", min, max)
+		}
+
+	default:
+		return fmt.Errorf("invalid security group rule protocol '%s'", r.Protocol)
+	}
+
+	if r.RemoteCIDR.IsValid() {
+		if r.RemoteGroup != "" {
+			return fmt.Errorf("the remote cidr and group are exclusive")
+		} else if r.RemoteCIDR.Addr().Is4() != (r.EtherType == IPv4) {
+			return fmt.Errorf("the remote cidr '%s' mismatches the ethertype '%s'",
+				r.RemoteCIDR, r.EtherType)
+		}
+	}
+
+	return nil
+}
+
+// protoMatch returns the protocol match of the rule, such as "tcp6".
+func (r Rule) protoMatch() string {
+	switch r.Protocol {
+	case ProtoTCP, ProtoUDP:
+		if r.EtherType == IPv6 {
+			return r.Protocol + "6"
+		}
+		return r.Protocol
+	case ProtoICMP:
+		return "icmp"
+	case ProtoICMPv6:
+		return "icmp6"
+	default:
+		if r.EtherType == IPv6 {
+			return "ipv6"
+		}
+		return "ip"
+	}
+}
+
+// portMatches returns the alternative matches of the destination port
+// or the icmp type and code.
+func (r Rule) portMatches() []string {
+	min, max := r.portRange()
+	switch r.Protocol {
+	case ProtoTCP, ProtoUDP:
+		if min < 0 {
+			return []string{""}
+		} else if max < 0 {
+			max = min
+		}
+		if min == 0 && max == 65535 {
+			return []string{""}
+		}
+
+		masks := ovs.PortRuleMasking(min, max)
+		for i, mask := range masks {
+			masks[i] = "tp_dst=" + mask
+		}
+		return masks
+
+	case ProtoICMP, ProtoICMPv6:
+		switch {
+		case min < 0:
+			return []string{""}
+		case max < 0:
+			return []string{fmt.Sprintf("icmp_type=%d", min)}
+		default:
+			return []string{fmt.Sprintf("icmp_type=%d,icmp_code=%d", min, max)}
+		}
+
+	default:
+		return []string{""}
+	}
+}
+
+// remoteMatches returns the alternative matches of the remote address.
+func (r Rule) remoteMatches(groups map[string][]netip.Addr) []string {
+	field := "nw_"
+	if r.EtherType == IPv6 {
+		field = "ipv6_"
+	}
+	if r.Direction == Ingress {
+		field += "src="
+	} else {
+		field += "dst="
+	}
+
+	if r.RemoteCIDR.IsValid() {
+		if r.RemoteCIDR.Bits() == 0 {
+			return []string{""}
+		}
+		return []string{field + r.RemoteCIDR.Masked().String()}
+	}
+
+	if r.RemoteGroup == "" {
+		return []string{""}
+	}
+
+	members := groups[r.RemoteGroup]
+	matches := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Is4() == (r.EtherType == IPv4) {
+			matches = append(matches, field+member.String())
+		}
+	}
+	return matches
+}
+
+// matches returns all the matches of the rule, which does not contain
+// the match of the port.
+func (r Rule) matches(groups map[string][]netip.Addr) []string {
+	proto := r.protoMatch()
+	remotes := r.remoteMatches(groups)
+	ports := r.portMatches()
+
+	matches := make([]string, 0, len(remotes)*len(ports))
+	for _, remote := range remotes {
+		for _, port := range ports {
+			matches = append(matches, ovs.JoinMatches(proto, remote, port))
+		}
+	}
+	return matches
+}
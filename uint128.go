@@ -0,0 +1,182 @@
+// Copyright 2020 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strconv"
+)
+
+// Uint128 is an unsigned 128-bit integer, such as ct_label.
+type Uint128 struct {
+	Hi uint64
+	Lo uint64
+}
+
+// Uint128Ones returns the integer whose lower n bits are 1 and others are 0.
+func Uint128Ones(n int) Uint128 {
+	switch {
+	case n <= 0:
+		return Uint128{}
+	case n < 64:
+		return Uint128{Lo: 1<<uint(n) - 1}
+	case n < 128:
+		return Uint128{Hi: 1<<uint(n-64) - 1, Lo: ^uint64(0)}
+	default:
+		return Uint128{Hi: ^uint64(0), Lo: ^uint64(0)}
+	}
+}
+
+// Uint128FromAddr converts the IPv4 or IPv6 address to Uint128.
+func Uint128FromAddr(addr netip.Addr) Uint128 {
+	var u Uint128
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			u.Lo = u.Lo<<8 | uint64(b)
+		}
+		return u
+	}
+
+	b := addr.As16()
+	for i := 0; i < 8; i++ {
+		u.Hi = u.Hi<<8 | uint64(b[i])
+		u.Lo = u.Lo<<8 | uint64(b[i+8])
+	}
+	return u
+}
+
+// Addr converts the integer to the IPv4 address if is4 is true,
+// or the IPv6 address.
+func (u Uint128) Addr(is4 bool) netip.Addr {
+	if is4 {
+		return netip.AddrFrom4([4]byte{byte(u.Lo >> 24), byte(u.Lo >> 16), byte(u.Lo >> 8), byte(u.Lo)})
+	}
+
+	var b [16]byte
+	for i := 7; i >= 0; i-- {
+		b[i], b[i+8] = byte(u.Hi), byte(u.Lo)
+		u.Hi >>= 8
+		u.Lo >>= 8
+	}
+	return netip.AddrFrom16(b)
+}
+
+// Cmp compares u and v, and returns -1 if u < v, 0 if u == v, or 1 if u > v.
+func (u Uint128) Cmp(v Uint128) int {
+	switch {
+	case u.Hi < v.Hi || (u.Hi == v.Hi && u.Lo < v.Lo):
+		return -1
+	case u == v:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Add returns u+v, which wraps around on overflow.
+func (u Uint128) Add(v Uint128) Uint128 {
+	lo, carry := bits.Add64(u.Lo, v.Lo, 0)
+	hi, _ := bits.Add64(u.Hi, v.Hi, carry)
+	return Uint128{Hi: hi, Lo: lo}
+}
+
+// Sub returns u-v, which wraps around on underflow.
+func (u Uint128) Sub(v Uint128) Uint128 {
+	lo, borrow := bits.Sub64(u.Lo, v.Lo, 0)
+	hi, _ := bits.Sub64(u.Hi, v.Hi, borrow)
+	return Uint128{Hi: hi, Lo: lo}
+}
+
+// And returns u&v.
+func (u Uint128) And(v Uint128) Uint128 { return Uint128{Hi: u.Hi & v.Hi, Lo: u.Lo & v.Lo} }
+
+// Or returns u|v.
+func (u Uint128) Or(v Uint128) Uint128 { return Uint128{Hi: u.Hi | v.Hi, Lo: u.Lo | v.Lo} }
+
+// Not returns ^u.
+func (u Uint128) Not() Uint128 { return Uint128{Hi: ^u.Hi, Lo: ^u.Lo} }
+
+// Lsh returns u<<n.
+func (u Uint128) Lsh(n uint) Uint128 {
+	switch {
+	case n >= 128:
+		return Uint128{}
+	case n >= 64:
+		return Uint128{Hi: u.Lo << (n - 64)}
+	case n == 0:
+		return u
+	default:
+		return Uint128{Hi: u.Hi<<n | u.Lo>>(64-n), Lo: u.Lo << n}
+	}
+}
+
+// Rsh returns u>>n.
+func (u Uint128) Rsh(n uint) Uint128 {
+	switch {
+	case n >= 128:
+		return Uint128{}
+	case n >= 64:
+		return Uint128{Lo: u.Hi >> (n - 64)}
+	case n == 0:
+		return u
+	default:
+		return Uint128{Hi: u.Hi >> n, Lo: u.Lo>>n | u.Hi<<(64-n)}
+	}
+}
+
+// TrailingZeros returns the number of the trailing zero bits,
+// which is 128 if u is zero.
+func (u Uint128) TrailingZeros() int {
+	if u.Lo != 0 {
+		return bits.TrailingZeros64(u.Lo)
+	}
+	return 64 + bits.TrailingZeros64(u.Hi)
+}
+
+// IsZero reports whether the integer is zero.
+func (u Uint128) IsZero() bool { return u.Hi == 0 && u.Lo == 0 }
+
+// String formats the integer as the hexadecimal with the prefix "0x".
+func (u Uint128) String() string {
+	if u.Hi == 0 {
+		return fmt.Sprintf("0x%x", u.Lo)
+	}
+	return fmt.Sprintf("0x%x%016x", u.Hi, u.Lo)
+}
+
+// ParseUint128 parses the decimal or hexadecimal string with the prefix "0x"
+// to Uint128.
+func ParseUint128(s string) (u Uint128, err error) {
+	if len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X") {
+		s = s[2:]
+		if len(s) > 32 {
+			return u, fmt.Errorf("the hexadecimal '%s' overflows 128 bits", s)
+		}
+
+		if len(s) > 16 {
+			if u.Hi, err = strconv.ParseUint(s[:len(s)-16], 16, 64); err != nil {
+				return
+			}
+			s = s[len(s)-16:]
+		}
+		u.Lo, err = strconv.ParseUint(s, 16, 64)
+		return
+	}
+
+	u.Lo, err = strconv.ParseUint(s, 10, 64)
+	return
+}